@@ -0,0 +1,357 @@
+// overlay-node 根据节点配置文件启动覆盖网络节点的各个角色：
+// 入口（ingress）、中继（relay）、出口（egress）、探测代理（probe）和信息代理（info）。
+//
+// 配置的优先级从低到高依次为：默认值、YAML 配置文件、OVERLAY_ 开头的环境变量、命令行参数。
+//
+//	overlay-node -config node.yaml -roles ingress,relay -set relay.listen=:9001
+package main
+
+import (
+	"context"
+	"demo1/info"
+	"demo1/proxy/accesslog"
+	"demo1/proxy/backend"
+	"demo1/proxy/cache"
+	"demo1/proxy/config"
+	"demo1/proxy/connection"
+	"demo1/proxy/handler"
+	smux2 "demo1/proxy/smux_usage"
+	"demo1/proxy/stripe"
+	"demo1/proxy/tracing"
+	"demo1/tcp"
+	"demo1/tcp_probe"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// setFlags 收集重复出现的 -set key=value 参数
+type setFlags []string
+
+func (s *setFlags) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *setFlags) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func main() {
+	configPath := flag.String("config", os.Getenv(config.EnvPrefix+"CONFIG"), "节点配置文件（YAML）")
+	roles := flag.String("roles", "", "启用的角色，逗号分隔：ingress,relay,egress,probe,info")
+	nodeIP := flag.String("node-ip", "", "本节点在转发路径中的 IP")
+	var sets setFlags
+	flag.Var(&sets, "set", "覆盖配置项，格式为 key=value，例如 relay.listen=:9001，可以重复")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath, *roles, *nodeIP, sets)
+	if err != nil {
+		log.Fatalf("Invalid node config: %v", err)
+	}
+
+	// 收到 SIGINT 或 SIGTERM 时停止接受新的请求，等待进行中的请求和流结束后退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, cfg); err != nil {
+		log.Fatalf("Node failed: %v", err)
+	}
+	fmt.Println("Node stopped")
+}
+
+// loadConfig 依次应用配置文件、环境变量和命令行参数，并检查配置
+func loadConfig(path, roles, nodeIP string, sets []string) (*config.NodeConfig, error) {
+	cfg, err := config.LoadNodeConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	if roles != "" {
+		sets = append([]string{"node.roles=" + roles}, sets...)
+	}
+	if nodeIP != "" {
+		sets = append([]string{"node.ip=" + nodeIP}, sets...)
+	}
+	for _, set := range sets {
+		key, value, ok := strings.Cut(set, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid -set %q, expected key=value", set)
+		}
+		if err := cfg.Set(key, value); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+// run 启动配置中启用的角色，ctx 结束后等待所有角色退出
+func run(ctx context.Context, cfg *config.NodeConfig) error {
+	// 各模块的默认参数
+	info.APIURL = cfg.Controller.InfoURL
+	info.ReportInterval = cfg.Info.Interval
+	info.NetworkInterface = cfg.Info.Interface
+	tcp_probe.ReportURL = cfg.Controller.ProbeURL
+	tcp_probe.DefaultPort = cfg.Probe.Port
+	tcp_probe.ProbeInterval = cfg.Probe.Interval
+	tcp_probe.ReportInterval = cfg.Probe.ReportInterval
+	tcp_probe.ProbeTimeout = cfg.Probe.Timeout
+
+	// 创建模块1和模块2，两者互相引用
+	module2 := handler.NewModule2API(nil)
+	module1 := handler.NewModule1API(module2)
+	module2.ClientServerAPI = module1
+	if err := configureProxy(cfg, module1, module2); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, 8)
+	start := func(name string, fn func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(); err != nil {
+				errCh <- fmt.Errorf("%s: %w", name, err)
+			}
+		}()
+	}
+
+	if cfg.HasRole(config.RoleRelay) || cfg.HasRole(config.RoleEgress) {
+		start("relay", func() error { return module2.StartProxyServer(ctx, cfg.Relay.Listen) })
+	}
+	if cfg.Relay.Tunnel.Rendezvous != "" {
+		start("tunnel", func() error { return module2.RunTunnel(ctx, cfg.Relay.Tunnel.Rendezvous) })
+	}
+	if module2.Tracer != nil {
+		start("tracing", func() error {
+			module2.Tracer.Run(ctx)
+			return nil
+		})
+	}
+	if module2.Backends != nil {
+		start("backends", func() error {
+			module2.Backends.Run(ctx)
+			return nil
+		})
+	}
+	if cfg.HasRole(config.RoleIngress) {
+		start("ingress", func() error { return module1.StartClientServer(ctx, cfg.Ingress.Listen) })
+	}
+	if cfg.HasRole(config.RoleProbe) {
+		start("probe", func() error {
+			tcp.StartTCPServer(ctx, cfg.Probe.Port)
+			return nil
+		})
+	}
+	if cfg.HasRole(config.RoleInfo) {
+		start("info", func() error {
+			info.StartInfoCollector(ctx)
+			return nil
+		})
+	}
+
+	// 探测任务接口和节点管理接口
+	admin := handler.NewAdminAPI(module1, module2)
+	admin.Token = cfg.API.AdminToken
+	start("api", func() error {
+		tcp_probe.StartAPIServer(ctx, cfg.API.Listen, admin.Register)
+		return nil
+	})
+
+	fmt.Printf("Node %s running with roles %s\n", cfg.Node.ID, strings.Join(cfg.Node.Roles, ","))
+	wg.Wait()
+
+	// 所有角色停止后关闭到下一跳的会话、连接池和访问日志
+	module2.CloseSessionPools()
+	module1.AccessLog.Close()
+
+	close(errCh)
+	var errs []error
+	for err := range errCh {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// configureProxy 按节点配置设置模块1和模块2，并加载路由表、限流和认证配置
+func configureProxy(cfg *config.NodeConfig, module1 *handler.Module1API, module2 *handler.Module2API) error {
+	module1.DrainTimeout = cfg.Ingress.DrainTimeout
+	module1.NodeID = cfg.Node.ID
+	module2.NodeID = cfg.Node.ID
+	module2.NodeIP = cfg.Node.IP
+	module2.DialTimeout = cfg.Relay.DialTimeout
+	module2.RequestTimeout = cfg.Relay.RequestTimeout
+	module2.DrainTimeout = cfg.Relay.DrainTimeout
+	module2.SessionsPerHop = cfg.Relay.SessionsPerHop
+	module2.MaxStreamsPerSession = cfg.Relay.MaxStreamsPerSession
+	module2.Pool = connection.ManagerOptions{
+		Pool: connection.Options{
+			MaxCap:      cfg.Pool.MaxCap,
+			MaxActive:   cfg.Pool.MaxActive,
+			MaxIdleTime: cfg.Pool.MaxIdleTime,
+			MaxLifetime: cfg.Pool.MaxLifetime,
+		},
+		MaxPools: cfg.Pool.MaxPools,
+		MaxTotal: cfg.Pool.MaxTotal,
+	}
+	if cfg.Pool.PingOnGet {
+		module2.Pool.Pool.Ping = connection.CheckConn
+	}
+	module2.PoolWaitTimeout = cfg.Pool.WaitTimeout
+	module2.Lanes = cfg.Relay.Stripe.Lanes
+	module2.PeerLanes = cfg.Relay.Stripe.PeerLanes
+	module2.Stripe = stripe.Options{
+		ChunkSize:     cfg.Relay.Stripe.ChunkSize,
+		ReorderBuffer: cfg.Relay.Stripe.ReorderBuffer,
+	}
+	module2.AcceptTunnels = cfg.Relay.Tunnel.Accept
+	module2.TunnelToken = cfg.Relay.Tunnel.Token
+	module2.TunnelMaxBackoff = cfg.Relay.Tunnel.MaxBackoff
+	module2.Egress = cfg.HasRole(config.RoleEgress)
+
+	// 模块1和模块2共用同一组链路参数
+	peers := make(map[string]smux2.LinkConfig, len(cfg.Relay.PeerSMUX))
+	for peer, s := range cfg.Relay.PeerSMUX {
+		peers[peer] = linkConfig(s)
+	}
+	module2.Links.Configure(linkConfig(cfg.Relay.SMUX), peers)
+	smux2.DefaultLink = linkConfig(cfg.Relay.SMUX)
+
+	forwarded, err := handler.NewForwardedPolicy(cfg.Ingress.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	module1.Forwarded = forwarded
+	module1.DirectFetch = cfg.Ingress.Direct
+
+	if cfg.AccessLog.Path != "" {
+		accessLog, err := accesslog.New(accesslog.Options{
+			Path:       cfg.AccessLog.Path,
+			MaxBytes:   cfg.AccessLog.MaxBytes,
+			MaxBackups: cfg.AccessLog.MaxBackups,
+		})
+		if err != nil {
+			return err
+		}
+		module1.AccessLog = accessLog
+		module2.AccessLog = accessLog
+	}
+
+	if cfg.Tracing.Enabled {
+		tracer, err := newTracer(cfg)
+		if err != nil {
+			return err
+		}
+		module1.Tracer = tracer
+		module2.Tracer = tracer
+	}
+
+	if cfg.HasRole(config.RoleIngress) && cfg.Cache.Enabled {
+		responseCache, err := cache.New(cache.Options{
+			MemoryBytes:   cfg.Cache.MemoryBytes,
+			DiskDir:       cfg.Cache.DiskDir,
+			DiskBytes:     cfg.Cache.DiskBytes,
+			MaxEntryBytes: cfg.Cache.MaxEntryBytes,
+		})
+		if err != nil {
+			return err
+		}
+		module1.Cache = responseCache
+	}
+
+	if !cfg.HasRole(config.RoleIngress) && !cfg.HasRole(config.RoleRelay) && !cfg.HasRole(config.RoleEgress) {
+		return nil
+	}
+
+	// 模块1和模块2共用同一张路由表
+	routes, err := config.LoadRouteTable(cfg.Ingress.RoutesFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		module1.Routes = routes
+	}
+	module2.Routes = module1.Routes
+
+	// 出口节点的后端池
+	if cfg.HasRole(config.RoleEgress) && routes != nil {
+		pools, err := config.LoadBackendPools(cfg.Ingress.RoutesFile)
+		if err != nil {
+			return err
+		}
+		if len(pools) > 0 {
+			module2.Backends = backend.NewSet(pools)
+		}
+	}
+
+	if !cfg.HasRole(config.RoleIngress) {
+		return nil
+	}
+
+	// 入口限流规则来自节点配置，没有路由文件（例如 direct: true）时同样生效
+	if len(cfg.Ingress.RateLimits) > 0 {
+		module1.Limiter = handler.NewRateLimiter(cfg.Ingress.RateLimits)
+	}
+
+	// 入口认证和授权配置与路由表在同一个文件中，没有路由文件时不启用
+	authConfig, err := config.LoadAuthConfig(cfg.Ingress.RoutesFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if authConfig != nil {
+		tlsConfig, err := authConfig.ServerTLSConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load ingress TLS config: %w", err)
+		}
+		module1.TLSConfig = tlsConfig
+		module1.Auth = handler.NewAccessControl(authConfig)
+	}
+	return nil
+}
+
+// linkConfig 把配置文件中的 SMUX 参数转换为会话池使用的链路参数
+func linkConfig(s config.SMUXSection) smux2.LinkConfig {
+	return smux2.LinkConfig{
+		Version:           s.Version,
+		MaxReceiveBuffer:  s.MaxReceiveBuffer,
+		MaxStreamBuffer:   s.MaxStreamBuffer,
+		MaxFrameSize:      s.MaxFrameSize,
+		KeepAliveInterval: s.KeepAliveInterval,
+		KeepAliveTimeout:  s.KeepAliveTimeout,
+		AutoTune:          s.AutoTune,
+	}
+}
+
+// newTracer 按配置创建追踪器和导出器，服务名使用节点 ID
+func newTracer(cfg *config.NodeConfig) (*tracing.Tracer, error) {
+	var exporters []tracing.Exporter
+	if cfg.Tracing.OTLPEndpoint != "" {
+		headers := make(map[string]string, len(cfg.Tracing.OTLPHeaders))
+		for _, header := range cfg.Tracing.OTLPHeaders {
+			key, value, found := strings.Cut(header, "=")
+			if !found {
+				return nil, fmt.Errorf("invalid tracing.otlp_headers entry %q", header)
+			}
+			headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		exporters = append(exporters, tracing.NewOTLPExporter(cfg.Tracing.OTLPEndpoint, cfg.Node.ID, headers))
+	}
+	if cfg.Tracing.File != "" {
+		exporter, err := tracing.NewFileExporter(cfg.Tracing.File, cfg.Node.ID)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, exporter)
+	}
+	return tracing.NewTracer(cfg.Tracing.SampleRatio, exporters...), nil
+}
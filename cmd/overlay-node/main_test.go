@@ -0,0 +1,43 @@
+package main
+
+import (
+	"demo1/proxy/handler"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRateLimitsWithoutRoutesFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "node.yaml")
+	yaml := `
+node:
+  roles: [ingress]
+ingress:
+  direct: true
+  routes_file: ` + filepath.Join(dir, "routes.json") + `
+  rate_limits:
+    - key: ip
+      requests_per_second: 10
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(path, "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 直接访问的入口没有路由文件，节点配置中的限流规则仍然生效
+	module2 := handler.NewModule2API(nil)
+	module1 := handler.NewModule1API(module2)
+	if err := configureProxy(cfg, module1, module2); err != nil {
+		t.Fatal(err)
+	}
+	if module1.Limiter == nil {
+		t.Error("expected the rate limiter to be built without a routes file")
+	}
+	if module1.Auth != nil {
+		t.Error("expected no access control without a routes file")
+	}
+}
@@ -13,18 +13,24 @@ import (
 	"strings"
 )
 
+// NetworkInterface 统计流量的网卡名称，可以通过节点配置修改
+var NetworkInterface = "eth0"
+
 // GetIP 获取公网IP地址
 // 通过向 http://icanhazip.com 发送GET请求来获取服务器的公网IP
 func GetIP() string {
 	resp, err := http.Get("http://icanhazip.com")
 	if err != nil {
-		log.Fatalf("Failed to get public IP: %v", err)
+		// 网络不可达时只记录错误，不影响同一进程中的其他角色
+		log.Printf("Failed to get public IP: %v", err)
+		return ""
 	}
 	defer resp.Body.Close()
 
 	ip, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalf("Failed to read response body: %v", err)
+		log.Printf("Failed to read response body: %v", err)
+		return ""
 	}
 
 	return strings.TrimSpace(string(ip))
@@ -116,8 +122,8 @@ func GetDiskInfo() DiskInfo {
 	}
 }
 
-// GetNetworkInfo 获取eth0接口的I/O统计信息
-// 返回一个NetworkInfo结构体，包含eth0接口的流量统计信息
+// GetNetworkInfo 获取 NetworkInterface 网卡（默认 eth0）的I/O统计信息
+// 返回一个NetworkInfo结构体，包含该网卡的流量统计信息
 func GetNetworkInfo() NetworkInfo {
 	// 获取所有网络接口的I/O统计信息
 	interfaces, err := net.IOCounters(true)
@@ -126,7 +132,7 @@ func GetNetworkInfo() NetworkInfo {
 	}
 
 	for _, iface := range interfaces {
-		if iface.Name == "eth0" {
+		if iface.Name == NetworkInterface {
 			return NetworkInfo{
 				InterfaceName: iface.Name,
 				BytesSent:     iface.BytesSent,
@@ -137,7 +143,7 @@ func GetNetworkInfo() NetworkInfo {
 		}
 	}
 
-	// 如果没有找到 eth0 接口，返回一个零值的 NetworkInfo 结构体
+	// 如果没有找到该网卡，返回一个零值的 NetworkInfo 结构体
 	return NetworkInfo{}
 }
 
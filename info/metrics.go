@@ -0,0 +1,36 @@
+package info
+
+import "demo1/metrics"
+
+// 最近一次收集的系统信息，由 /metrics 导出
+var (
+	cpuUsage      = metrics.NewGauge("overlay_host_cpu_usage_percent", "CPU usage of the host.")
+	cpuCores      = metrics.NewGauge("overlay_host_cpu_cores", "CPU cores of the host.")
+	memoryBytes   = metrics.NewGauge("overlay_host_memory_bytes", "Host memory by state.", "state")
+	memoryPercent = metrics.NewGauge("overlay_host_memory_used_percent", "Used host memory.")
+	diskBytes     = metrics.NewGauge("overlay_host_disk_bytes", "Root disk space by state.", "state")
+	diskPercent   = metrics.NewGauge("overlay_host_disk_used_percent", "Used root disk space.")
+	networkBytes  = metrics.NewGauge("overlay_host_network_bytes", "Bytes transferred on the monitored network interface since boot, by direction.", "direction")
+	loadAverage   = metrics.NewGauge("overlay_host_load", "Host load average by period.", "period")
+	uptime        = metrics.NewGauge("overlay_host_uptime_seconds", "Host uptime.")
+)
+
+// recordMetrics 更新系统信息指标
+func recordMetrics(info InfoData) {
+	cpuUsage.With().Set(info.CPUInfo.Usage)
+	cpuCores.With().Set(float64(info.CPUInfo.Cores))
+	memoryBytes.With("total").Set(float64(info.MemoryInfo.Total))
+	memoryBytes.With("used").Set(float64(info.MemoryInfo.Used))
+	memoryBytes.With("available").Set(float64(info.MemoryInfo.Available))
+	memoryPercent.With().Set(info.MemoryInfo.UsedPercent)
+	diskBytes.With("total").Set(float64(info.DiskInfo.Total))
+	diskBytes.With("used").Set(float64(info.DiskInfo.Used))
+	diskBytes.With("free").Set(float64(info.DiskInfo.Free))
+	diskPercent.With().Set(info.DiskInfo.UsedPercent)
+	networkBytes.With("sent").Set(float64(info.NetworkInfo.BytesSent))
+	networkBytes.With("recv").Set(float64(info.NetworkInfo.BytesRecv))
+	loadAverage.With("1m").Set(info.LoadInfo.Load1)
+	loadAverage.With("5m").Set(info.LoadInfo.Load5)
+	loadAverage.With("15m").Set(info.LoadInfo.Load15)
+	uptime.With().Set(float64(info.HostInfo.Uptime))
+}
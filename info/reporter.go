@@ -2,6 +2,7 @@ package info
 
 import (
 	"bytes"         // 用于处理字节缓冲区，方便数据处理
+	"context"       // 用于在节点关闭时停止收集
 	"encoding/json" // 用于将结构体编码为JSON格式数据
 	"fmt"           // 用于格式化字符串和输出
 	"log"           // 用于日志记录，便于错误调试
@@ -9,8 +10,12 @@ import (
 	"time"          // 用于处理时间和定时任务
 )
 
-// APIURL 定义了用于接收系统信息的远程API地址
-const APIURL = "http://124.70.34.63:8080/fetch_and_save"
+var (
+	// APIURL 定义了用于接收系统信息的远程API地址，可以通过节点配置修改
+	APIURL = "http://124.70.34.63:8080/fetch_and_save"
+	// ReportInterval 收集并上报系统信息的间隔
+	ReportInterval = 30 * time.Second
+)
 
 // ReportSystemInfo 上报系统信息
 // 接受一个 InfoData 类型的参数，将其转换为 JSON 格式并通过 HTTP POST 请求发送到指定的 APIURL
@@ -18,15 +23,17 @@ func ReportSystemInfo(info InfoData) {
 	// 将系统信息编码为 JSON 格式
 	jsonData, err := json.Marshal(info)
 	if err != nil {
-		// 如果编码失败，记录错误并终止程序
-		log.Fatalf("Failed to marshal json data: %v", err)
+		// 如果编码失败，记录错误，等待下一次上报
+		log.Printf("Failed to marshal json data: %v", err)
+		return
 	}
 
 	// 发送HTTP POST请求，将 JSON 数据发送到 API
 	resp, err := http.Post(APIURL, "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
-		// 如果请求失败，记录错误并终止程序
-		log.Fatalf("Failed to send data to API: %v", err)
+		// 如果请求失败，记录错误，等待下一次上报，不影响同一进程中的其他角色
+		log.Printf("Failed to send data to API: %v", err)
+		return
 	}
 
 	// 确保响应体在函数结束时关闭，避免资源泄漏
@@ -37,16 +44,25 @@ func ReportSystemInfo(info InfoData) {
 }
 
 // StartInfoCollector 启动信息收集和上报器
-// 该函数会持续地收集系统信息，并每隔30秒将其上报到指定的API
-func StartInfoCollector() {
+// 该函数会持续地收集系统信息，并每隔 ReportInterval（默认30秒）将其上报到指定的API
+// ctx 结束时返回；正在进行的上报会先完成，不会被中途打断
+func StartInfoCollector(ctx context.Context) {
+	ticker := time.NewTicker(ReportInterval)
+	defer ticker.Stop()
 	for {
 		// 收集当前的系统信息
 		info := CollectSystemInfo()
+		recordMetrics(info)
 
 		// 将收集到的信息上报到API
 		ReportSystemInfo(info)
 
-		// 30秒，然后再次收集并上报系统信息
-		time.Sleep(30 * time.Second)
+		// 等待 ReportInterval，然后再次收集并上报系统信息
+		select {
+		case <-ctx.Done():
+			log.Println("Info collector stopped")
+			return
+		case <-ticker.C:
+		}
 	}
 }
@@ -1,14 +1,26 @@
 package main
 
 import (
+	"context"
+	"demo1/proxy/config"
 	"demo1/tcp"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 
 	"demo1/info"
 	"demo1/tcp_probe"
 )
 
 func main() {
+	// 收到 SIGINT 或 SIGTERM 时取消 ctx，各模块停止接受新的任务并在收尾后返回
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 只启动探测代理和信息代理，使用节点配置的默认值；完整的节点见 cmd/overlay-node
+	cfg := config.DefaultNodeConfig()
+
 	var wg sync.WaitGroup
 
 	wg.Add(3)
@@ -16,18 +28,18 @@ func main() {
 	// 启动 API 服务
 	go func() {
 		defer wg.Done()
-		tcp_probe.StartAPIServer()
+		tcp_probe.StartAPIServer(ctx, cfg.API.Listen)
 	}()
 
 	// 启动信息收集和发送的模块
 	go func() {
 		defer wg.Done()
-		info.StartInfoCollector()
+		info.StartInfoCollector(ctx)
 	}()
 
 	go func() {
 		defer wg.Done()
-		tcp.StartTCPServer("50000")
+		tcp.StartTCPServer(ctx, cfg.Probe.Port)
 	}()
 
 	wg.Wait()
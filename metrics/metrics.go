@@ -0,0 +1,379 @@
+// Package metrics 以 Prometheus 文本格式导出节点指标。
+// 各模块在包级变量中定义自己的指标，注册到 Default，由 /metrics 接口统一输出
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 指标类型
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// DefaultBuckets 时延直方图默认的桶上界，单位为秒
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Default 进程内默认的指标注册表
+var Default = NewRegistry()
+
+// family 一个指标名及其所有样本
+type family interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry 指标注册表
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+	names    map[string]bool
+}
+
+// NewRegistry 创建空的注册表
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register 注册指标，同名指标重复注册时 panic，与在包级变量中定义指标的用法一致
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[f.name()] {
+		panic("metrics: duplicate metric " + f.name())
+	}
+	r.names[f.name()] = true
+	r.families = append(r.families, f)
+}
+
+// Write 按注册顺序输出所有指标
+func (r *Registry) Write(w *bufio.Writer) {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+	for _, f := range families {
+		f.write(w)
+	}
+}
+
+// Handler 返回输出 Prometheus 文本格式的 HTTP 处理函数
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		buf := bufio.NewWriter(w)
+		r.Write(buf)
+		buf.Flush()
+	})
+}
+
+// Handler 返回默认注册表的 HTTP 处理函数
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// desc 指标的名称、说明、类型和标签名
+type desc struct {
+	metricName string
+	help       string
+	typ        string
+	labels     []string
+}
+
+func (d *desc) name() string { return d.metricName }
+
+// writeHeader 输出 HELP 和 TYPE 行
+func (d *desc) writeHeader(w *bufio.Writer) {
+	w.WriteString("# HELP " + d.metricName + " " + escapeHelp(d.help) + "\n")
+	w.WriteString("# TYPE " + d.metricName + " " + d.typ + "\n")
+}
+
+// writeSample 输出一行样本，extra 为额外的标签，例如直方图的 le
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(label + `="` + escapeLabel(values[i]) + `"`)
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraName + `="` + extraValue + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+// vec 按标签值保存子指标
+type vec[T any] struct {
+	desc
+	mu       sync.Mutex
+	children map[string]*child[T]
+	newChild func() *T
+}
+
+type child[T any] struct {
+	values []string
+	metric *T
+}
+
+// with 返回标签值对应的子指标，不存在时创建；标签值数量必须与标签名一致
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic("metrics: " + v.metricName + " expects " + strconv.Itoa(len(v.labels)) + " label values")
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, exists := v.children[key]
+	if !exists {
+		c = &child[T]{values: append([]string(nil), values...), metric: v.newChild()}
+		v.children[key] = c
+	}
+	return c.metric
+}
+
+// sorted 按标签值排序返回所有子指标，使输出稳定
+func (v *vec[T]) sorted() []*child[T] {
+	v.mu.Lock()
+	children := make([]*child[T], 0, len(v.children))
+	for _, c := range v.children {
+		children = append(children, c)
+	}
+	v.mu.Unlock()
+	sort.Slice(children, func(i, j int) bool {
+		return strings.Join(children[i].values, "\xff") < strings.Join(children[j].values, "\xff")
+	})
+	return children
+}
+
+// Counter 只增不减的计数器
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// Inc 加一
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add 增加 delta，delta 必须非负
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	c.mu.Lock()
+	c.value += delta
+	c.mu.Unlock()
+}
+
+func (c *Counter) get() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+// CounterVec 带标签的计数器
+type CounterVec struct{ vec[Counter] }
+
+// NewCounter 在默认注册表中创建计数器，名称应以 _total 结尾
+func NewCounter(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{vec[Counter]{
+		desc:     desc{metricName: name, help: help, typ: TypeCounter, labels: labels},
+		children: make(map[string]*child[Counter]),
+		newChild: func() *Counter { return &Counter{} },
+	}}
+	Default.register(v)
+	return v
+}
+
+// With 返回标签值对应的计数器
+func (v *CounterVec) With(values ...string) *Counter { return v.with(values) }
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	for _, c := range v.sorted() {
+		writeSample(w, v.metricName, v.labels, c.values, "", "", c.metric.get())
+	}
+}
+
+// Gauge 可增可减的数值
+type Gauge struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// Set 设置当前值
+func (g *Gauge) Set(value float64) {
+	g.mu.Lock()
+	g.value = value
+	g.mu.Unlock()
+}
+
+// Add 增加 delta，可以为负数
+func (g *Gauge) Add(delta float64) {
+	g.mu.Lock()
+	g.value += delta
+	g.mu.Unlock()
+}
+
+func (g *Gauge) get() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+// GaugeVec 带标签的数值
+type GaugeVec struct{ vec[Gauge] }
+
+// NewGauge 在默认注册表中创建数值指标
+func NewGauge(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{vec[Gauge]{
+		desc:     desc{metricName: name, help: help, typ: TypeGauge, labels: labels},
+		children: make(map[string]*child[Gauge]),
+		newChild: func() *Gauge { return &Gauge{} },
+	}}
+	Default.register(v)
+	return v
+}
+
+// With 返回标签值对应的数值指标
+func (v *GaugeVec) With(values ...string) *Gauge { return v.with(values) }
+
+func (v *GaugeVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	for _, c := range v.sorted() {
+		writeSample(w, v.metricName, v.labels, c.values, "", "", c.metric.get())
+	}
+}
+
+// Histogram 按桶统计观测值的分布
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64 // 每个桶的计数（非累计），最后一个是 +Inf
+	sum     float64
+	count   uint64
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(value float64) {
+	index := sort.SearchFloat64s(h.buckets, value)
+	h.mu.Lock()
+	h.counts[index]++
+	h.sum += value
+	h.count++
+	h.mu.Unlock()
+}
+
+// HistogramVec 带标签的直方图
+type HistogramVec struct{ vec[Histogram] }
+
+// NewHistogram 在默认注册表中创建直方图，buckets 为空时使用 DefaultBuckets
+func NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	v := &HistogramVec{vec[Histogram]{
+		desc:     desc{metricName: name, help: help, typ: TypeHistogram, labels: labels},
+		children: make(map[string]*child[Histogram]),
+		newChild: func() *Histogram {
+			return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+		},
+	}}
+	Default.register(v)
+	return v
+}
+
+// With 返回标签值对应的直方图
+func (v *HistogramVec) With(values ...string) *Histogram { return v.with(values) }
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	for _, c := range v.sorted() {
+		h := c.metric
+		h.mu.Lock()
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += h.counts[i]
+			writeSample(w, v.metricName+"_bucket", v.labels, c.values, "le", formatFloat(bound), float64(cumulative))
+		}
+		writeSample(w, v.metricName+"_bucket", v.labels, c.values, "le", "+Inf", float64(h.count))
+		writeSample(w, v.metricName+"_sum", v.labels, c.values, "", "", h.sum)
+		writeSample(w, v.metricName+"_count", v.labels, c.values, "", "", float64(h.count))
+		h.mu.Unlock()
+	}
+}
+
+// Sample 采集函数返回的一个样本，Values 与指标的标签名一一对应
+type Sample struct {
+	Values []string
+	Value  float64
+}
+
+// funcFamily 在输出时调用采集函数获取样本，用于连接池、会话数等已经由模块自己维护的状态
+type funcFamily struct {
+	desc
+	collect func() []Sample
+}
+
+// NewGaugeFunc 在默认注册表中创建由采集函数提供样本的数值指标
+func NewGaugeFunc(name, help string, labels []string, collect func() []Sample) {
+	Default.register(&funcFamily{desc{metricName: name, help: help, typ: TypeGauge, labels: labels}, collect})
+}
+
+// NewCounterFunc 在默认注册表中创建由采集函数提供样本的计数器
+func NewCounterFunc(name, help string, labels []string, collect func() []Sample) {
+	Default.register(&funcFamily{desc{metricName: name, help: help, typ: TypeCounter, labels: labels}, collect})
+}
+
+func (f *funcFamily) write(w *bufio.Writer) {
+	samples := f.collect()
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].Values, "\xff") < strings.Join(samples[j].Values, "\xff")
+	})
+	f.writeHeader(w)
+	for _, s := range samples {
+		if len(s.Values) != len(f.labels) {
+			continue
+		}
+		writeSample(w, f.metricName, f.labels, s.Values, "", "", s.Value)
+	}
+}
+
+// formatFloat 按 Prometheus 的格式输出数值
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(value string) string { return labelEscaper.Replace(value) }
+func escapeHelp(help string) string   { return helpEscaper.Replace(help) }
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func render(t *testing.T) string {
+	t.Helper()
+	var out strings.Builder
+	w := bufio.NewWriter(&out)
+	Default.Write(w)
+	w.Flush()
+	return out.String()
+}
+
+func TestTextFormat(t *testing.T) {
+	requests := NewCounter("test_requests_total", "Requests.", "route", "code")
+	requests.With("api", "200").Add(2)
+	requests.With(`a"b`, "502").Inc()
+	latency := NewHistogram("test_latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	latency.With("api").Observe(0.05)
+	latency.With("api").Observe(0.5)
+	latency.With("api").Observe(3)
+	NewGaugeFunc("test_idle", "Idle.", []string{"next_hop"}, func() []Sample {
+		return []Sample{{Values: []string{"10.0.0.2"}, Value: 4}}
+	})
+
+	text := render(t)
+	for _, line := range []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{route="a\"b",code="502"} 1`,
+		`test_requests_total{route="api",code="200"} 2`,
+		"# TYPE test_latency_seconds histogram",
+		`test_latency_seconds_bucket{route="api",le="0.1"} 1`,
+		`test_latency_seconds_bucket{route="api",le="1"} 2`,
+		`test_latency_seconds_bucket{route="api",le="+Inf"} 3`,
+		`test_latency_seconds_sum{route="api"} 3.55`,
+		`test_latency_seconds_count{route="api"} 3`,
+		`test_idle{next_hop="10.0.0.2"} 4`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("missing %q in output:\n%s", line, text)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package overlaytest
+
+import (
+	"bytes"
+	"context"
+	"demo1/tcp_probe"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Response 经过覆盖网络返回的响应，响应体已经读完
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Path 按转发方向返回处理过该请求的节点 ID。每个节点在响应返回时追加 Via，
+// 出口最先、入口最后，这里反转为从入口到出口的顺序
+func (r *Response) Path() []string {
+	ids := viaNodes(r.Header)
+	for i, j := 0, len(ids)-1; i < j; i, j = i+1, j-1 {
+		ids[i], ids[j] = ids[j], ids[i]
+	}
+	return ids
+}
+
+// viaNodes 按出现顺序返回 Via 头部中的节点 ID
+func viaNodes(header http.Header) []string {
+	var ids []string
+	for _, value := range header.Values("Via") {
+		for _, entry := range strings.Split(value, ",") {
+			fields := strings.Fields(entry)
+			if len(fields) >= 2 {
+				ids = append(ids, fields[1])
+			}
+		}
+	}
+	return ids
+}
+
+// IDs 返回节点的 ID，用于和 Response.Path 比较
+func IDs(nodes ...*Node) []string {
+	ids := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		ids = append(ids, n.ID)
+	}
+	return ids
+}
+
+// Do 通过入口节点发送请求，req.URL 是目标服务器的地址，请求会发往入口并保留目标主机
+func (n *Node) Do(req *http.Request) (*Response, error) {
+	if n.IngressAddr == "" {
+		return nil, fmt.Errorf("node %s is not a running ingress", n.ID)
+	}
+	req = req.Clone(req.Context())
+	if req.Host == "" {
+		req.Host = req.URL.Host
+	}
+	req.URL.Scheme = "http"
+	req.URL.Host = n.IngressAddr
+	req.RequestURI = ""
+
+	resp, err := n.overlay.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}, nil
+}
+
+// Get 通过入口节点发送 GET 请求
+func (n *Node) Get(target string) (*Response, error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	return n.Do(req)
+}
+
+// MustGet 通过入口节点发送 GET 请求，发送失败时测试立即失败
+func (n *Node) MustGet(target string) *Response {
+	t := n.overlay.t
+	t.Helper()
+	resp, err := n.Get(target)
+	if err != nil {
+		t.Fatalf("overlaytest: GET %s via %s: %v", target, n.ID, err)
+	}
+	return resp
+}
+
+// AssertPath 检查响应经过的节点是否依次为 nodes
+func (o *Overlay) AssertPath(resp *Response, nodes ...*Node) {
+	o.t.Helper()
+	if got, want := resp.Path(), IDs(nodes...); !reflect.DeepEqual(got, want) {
+		o.t.Errorf("overlaytest: request took path %v, want %v", got, want)
+	}
+}
+
+// Origin 目标服务器，记录收到的每个请求
+type Origin struct {
+	ID string
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+// AddOrigin 启动一个目标服务器，handler 为空时返回 200 和 "hello from <id>"
+func (o *Overlay) AddOrigin(id string, handler http.HandlerFunc) *Origin {
+	if handler == nil {
+		handler = func(w http.ResponseWriter, _ *http.Request) {
+			fmt.Fprintf(w, "hello from %s", id)
+		}
+	}
+	origin := &Origin{ID: id}
+	origin.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin.mu.Lock()
+		origin.requests = append(origin.requests, r.Clone(context.Background()))
+		origin.mu.Unlock()
+		handler(w, r)
+	}))
+	o.t.Cleanup(origin.Close)
+	return origin
+}
+
+// Target 返回目标服务器上某个路径的地址
+func (s *Origin) Target(path string) string {
+	return s.URL + path
+}
+
+// Requests 返回目前收到的所有请求，请求体不可读
+func (s *Origin) Requests() []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*http.Request(nil), s.requests...)
+}
+
+// Controller 本地控制器，代替真实控制器接收探测结果
+type Controller struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	probes []tcp_probe.ProbeResult
+}
+
+func newController() *Controller {
+	c := &Controller{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fetch_detect", func(w http.ResponseWriter, r *http.Request) {
+		var result tcp_probe.ProbeResult
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		c.probes = append(c.probes, result)
+		c.mu.Unlock()
+	})
+	c.Server = httptest.NewServer(mux)
+	return c
+}
+
+// ProbeResults 返回目前收到的所有探测结果
+func (c *Controller) ProbeResults() []tcp_probe.ProbeResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]tcp_probe.ProbeResult(nil), c.probes...)
+}
+
+// StartProbe 通过节点的探测任务接口，让节点持续探测 target 的 TCP 服务，两者都需要 probe 角色。
+// 上报结果中 IP1 是本节点的 IP，IP2 是 target 监听的回环地址
+func (n *Node) StartProbe(target *Node) error {
+	if n.APIAddr == "" || target.ProbeAddr == "" {
+		return fmt.Errorf("nodes %s and %s must both run the probe role", n.ID, target.ID)
+	}
+	_, port, err := net.SplitHostPort(target.ProbeAddr)
+	if err != nil {
+		return err
+	}
+	task, _ := json.Marshal(tcp_probe.ProbeTask{IP1: n.IP, IP2: target.host, Port: port})
+	resp, err := n.overlay.client.Post("http://"+n.APIAddr+"/probe", "application/json", bytes.NewReader(task))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("probe task rejected: %s %s", resp.Status, body)
+	}
+	return nil
+}
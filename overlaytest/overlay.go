@@ -0,0 +1,351 @@
+// Package overlaytest 在一个进程内启动多个入口、中继和出口节点，节点监听回环地址上的临时端口，
+// 按测试给定的拓扑连接起来，用于在 go test 中离线地端到端测试转发、故障切换和探测。
+//
+//	o := overlaytest.New(t)
+//	in := o.AddNode("in", config.RoleIngress)
+//	r1 := o.AddNode("r1", config.RoleRelay)
+//	out := o.AddNode("out", config.RoleEgress)
+//	origin := o.AddOrigin("origin", nil)
+//	o.Route(config.DefaultRoute, overlaytest.Path(r1, out))
+//	o.Start()
+//	resp := in.MustGet(origin.Target("/"))
+//	o.AssertPath(resp, in, r1, out)
+//
+// 节点在转发路径中的 IP 依次使用 127.0.0.10、127.0.0.11……，系统不支持这些回环地址时监听 127.0.0.1，
+// 中继节点的拨号地址通过共用路由表的 peers 指向各自的临时端口。
+// 熔断器和节点健康状态每个节点独立，tcp_probe 的探测任务和上报地址是进程内全局的，
+// 同一时刻只能有一个探测任务，上报到 Overlay 自带的本地控制器。
+//
+// 每个节点有一条接入链路 Node.Link，到达该节点的连接（中继和探测目标）在拨号和接受两端都经过它，
+// 测试可以在运行时注入时延、带宽限制和重置：
+//
+//	r1.Link.Set(faultnet.Faults{Latency: 50 * time.Millisecond})
+package overlaytest
+
+import (
+	"context"
+	"demo1/proxy/config"
+	"demo1/proxy/faultnet"
+	"demo1/proxy/handler"
+	"demo1/tcp"
+	"demo1/tcp_probe"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 节点的默认参数，比生产环境短，使故障切换和关闭在测试中很快完成
+const (
+	dialTimeout    = time.Second
+	requestTimeout = 5 * time.Second
+	drainTimeout   = time.Second
+	healthInterval = 100 * time.Millisecond
+	waitTimeout    = 5 * time.Second
+	firstNodeOctet = 10
+	probeInterval  = 20 * time.Millisecond
+	reportInterval = 200 * time.Millisecond
+	probeTimeout   = time.Second
+)
+
+// Overlay 一组在进程内运行的节点和源站
+type Overlay struct {
+	t testing.TB
+
+	// Routes 所有节点共用的路由表
+	Routes *config.RouteTable
+	// Controller 接收探测结果上报的本地控制器
+	Controller *Controller
+
+	client *http.Client
+
+	mu    sync.Mutex
+	nodes []*Node
+	addrs map[string]*Node // 监听地址 -> 节点，用于找到连接要经过的接入链路
+}
+
+// New 创建空的覆盖网络，测试结束时自动停止所有节点和源站
+func New(t testing.TB) *Overlay {
+	t.Helper()
+	gin.SetMode(gin.ReleaseMode)
+
+	o := &Overlay{
+		t:          t,
+		Routes:     config.NewRouteTable(),
+		Controller: newController(),
+		client:     &http.Client{Timeout: 2 * requestTimeout},
+		addrs:      make(map[string]*Node),
+	}
+
+	// 探测任务使用较短的间隔，结果上报到本地控制器，不访问外部网络
+	reportURL, interval, report, timeout, resultsFile, dial := tcp_probe.ReportURL,
+		tcp_probe.ProbeInterval, tcp_probe.ReportInterval, tcp_probe.ProbeTimeout, tcp_probe.ResultsFile, tcp_probe.Dial
+	tcp_probe.ReportURL = o.Controller.URL + "/fetch_detect"
+	tcp_probe.ProbeInterval = probeInterval
+	tcp_probe.ReportInterval = reportInterval
+	tcp_probe.ProbeTimeout = probeTimeout
+	tcp_probe.ResultsFile = filepath.Join(t.TempDir(), "probe_results.json")
+	tcp_probe.Dial = o.dial
+
+	t.Cleanup(func() {
+		o.Close()
+		tcp_probe.ReportURL, tcp_probe.ProbeInterval, tcp_probe.ReportInterval,
+			tcp_probe.ProbeTimeout, tcp_probe.ResultsFile, tcp_probe.Dial = reportURL, interval, report, timeout, resultsFile, dial
+	})
+	return o
+}
+
+// Node 一个节点，按角色启动入口、中继或探测服务
+type Node struct {
+	ID    string
+	IP    string // 节点在转发路径中的 IP
+	Roles []string
+
+	Ingress *handler.Module1API
+	Relay   *handler.Module2API
+	Link    *faultnet.Link // 节点的接入链路，默认没有故障
+	// Rendezvous 不为空时节点位于 NAT 后面：不监听中继端口，启动后主动连接该节点注册反向隧道，
+	// 该节点需要设置 AcceptTunnels
+	Rendezvous *Node
+
+	// 各个服务的监听地址，第一次启动时分配，重启后保持不变
+	IngressAddr string
+	RelayAddr   string
+	APIAddr     string // 探测任务和管理接口，只有 probe 角色启动
+	ProbeAddr   string // 探测目标的 TCP 服务，只有 probe 角色启动
+
+	overlay *Overlay
+	host    string // 监听的回环地址
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// AddNode 添加一个节点，节点在 Start 之后才开始监听
+func (o *Overlay) AddNode(id string, roles ...string) *Node {
+	o.t.Helper()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	octet := firstNodeOctet + len(o.nodes)
+	if octet > 254 {
+		o.t.Fatalf("overlaytest: too many nodes")
+	}
+	ip := fmt.Sprintf("127.0.0.%d", octet)
+
+	// 与 overlay-node 相同，模块1和模块2互相引用并共用路由表
+	module2 := handler.NewModule2API(nil)
+	module1 := handler.NewModule1API(module2)
+	module2.ClientServerAPI = module1
+	module1.NodeID, module2.NodeID = id, id
+	module2.NodeIP = ip
+	module1.Routes, module2.Routes = o.Routes, o.Routes
+	module1.DrainTimeout, module2.DrainTimeout = drainTimeout, drainTimeout
+	module1.Health.Interval = healthInterval
+	module2.DialTimeout = dialTimeout
+	module2.RequestTimeout = requestTimeout
+	module2.Breakers = handler.NewBreakerSet(handler.DefaultBreakerSettings)
+	module2.Dial = o.dial
+
+	n := &Node{
+		ID:      id,
+		IP:      ip,
+		Roles:   roles,
+		Ingress: module1,
+		Relay:   module2,
+		Link:    faultnet.NewLink(faultnet.Faults{}),
+		overlay: o,
+		host:    loopbackHost(ip),
+	}
+	module2.Egress = n.HasRole(config.RoleEgress)
+	o.nodes = append(o.nodes, n)
+	return n
+}
+
+// loopbackHost 返回节点可以监听的回环地址，不支持 127.0.0.1 以外的回环地址时退回 127.0.0.1
+func loopbackHost(ip string) string {
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, "0"))
+	if err != nil {
+		return "127.0.0.1"
+	}
+	listener.Close()
+	return ip
+}
+
+// HasRole 判断节点是否启用了某个角色
+func (n *Node) HasRole(role string) bool {
+	for _, r := range n.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Nodes 返回所有节点
+func (o *Overlay) Nodes() []*Node {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]*Node(nil), o.nodes...)
+}
+
+// Start 启动所有尚未运行的节点
+func (o *Overlay) Start() {
+	o.t.Helper()
+	for _, n := range o.Nodes() {
+		n.Start()
+	}
+}
+
+// Close 停止所有节点和本地控制器
+func (o *Overlay) Close() {
+	for _, n := range o.Nodes() {
+		n.Stop()
+	}
+	o.client.CloseIdleConnections()
+	o.Controller.Close()
+}
+
+// Path 按顺序列出一条转发路径上的节点，使 Route 的调用更易读
+func Path(nodes ...*Node) []*Node {
+	return nodes
+}
+
+// Route 设置到目的主机的路由，第一条路径为主路径，其余为备用路径。
+// 源站都监听 127.0.0.1，通常使用 config.DefaultRoute 或 "127.0.0.1" 作为目的主机
+func (o *Overlay) Route(destination string, paths ...[]*Node) {
+	o.t.Helper()
+	route := &config.Route{Destination: destination}
+	for i, path := range paths {
+		ips := make([]string, 0, len(path))
+		for _, n := range path {
+			ips = append(ips, n.IP)
+		}
+		if i == 0 {
+			route.Primary = ips
+		} else {
+			route.Backups = append(route.Backups, ips)
+		}
+	}
+	if err := o.Routes.SetRoute(route); err != nil {
+		o.t.Fatalf("overlaytest: %v", err)
+	}
+}
+
+// Start 按角色启动节点的服务；重启时监听与上一次相同的地址，节点已在运行时不做任何事
+func (n *Node) Start() {
+	t := n.overlay.t
+	t.Helper()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	n.cancel = cancel
+
+	if (n.HasRole(config.RoleRelay) || n.HasRole(config.RoleEgress)) && n.Rendezvous != nil {
+		rendezvous := n.Rendezvous.RelayAddr
+		n.serve(func() { n.Relay.RunTunnel(ctx, rendezvous) })
+	} else if n.HasRole(config.RoleRelay) || n.HasRole(config.RoleEgress) {
+		listener := n.Link.Listener(n.listen(&n.RelayAddr))
+		n.overlay.Routes.SetPeer(n.IP, n.RelayAddr)
+		n.serve(func() { n.Relay.ServeProxy(ctx, listener) })
+	}
+	if n.HasRole(config.RoleIngress) {
+		listener := n.listen(&n.IngressAddr)
+		n.serve(func() { n.Ingress.ServeClient(ctx, listener) })
+	}
+	if n.HasRole(config.RoleProbe) {
+		target := n.Link.Listener(n.listen(&n.ProbeAddr))
+		n.serve(func() { tcp.ServeTCP(ctx, target) })
+		api := n.listen(&n.APIAddr)
+		n.serve(func() { tcp_probe.ServeAPI(ctx, api, handler.NewAdminAPI(n.Ingress, n.Relay).Register) })
+	}
+}
+
+// listen 在节点的回环地址上监听，addr 为空时分配临时端口并记录下来
+func (n *Node) listen(addr *string) net.Listener {
+	t := n.overlay.t
+	t.Helper()
+	if *addr == "" {
+		*addr = net.JoinHostPort(n.host, "0")
+	}
+
+	// 重启时上一个监听器可能刚刚关闭，短暂重试
+	var listener net.Listener
+	var err error
+	for deadline := time.Now().Add(waitTimeout); ; {
+		listener, err = net.Listen("tcp", *addr)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("overlaytest: node %s failed to listen on %s: %v", n.ID, *addr, err)
+	}
+	*addr = listener.Addr().String()
+	n.overlay.mu.Lock()
+	n.overlay.addrs[*addr] = n
+	n.overlay.mu.Unlock()
+	return listener
+}
+
+// dial 连接某个节点时经过该节点的接入链路，连接其他地址时直接拨号
+func (o *Overlay) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	o.mu.Lock()
+	n := o.addrs[addr]
+	o.mu.Unlock()
+	if n == nil {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return n.Link.Dial(nil)(ctx, network, addr)
+}
+
+// serve 在后台运行一个服务，Stop 等待它返回
+func (n *Node) serve(fn func()) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		fn()
+	}()
+}
+
+// Stop 停止节点的所有服务并等待它们退出，模拟节点故障；之后可以再次 Start
+func (n *Node) Stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.cancel == nil {
+		return
+	}
+	n.cancel()
+	n.wg.Wait()
+	n.Relay.CloseSessionPools()
+	n.cancel = nil
+}
+
+// Running 判断节点是否在运行
+func (n *Node) Running() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.cancel != nil
+}
+
+// Eventually 在超时之前反复检查条件，超时后测试失败
+func (o *Overlay) Eventually(what string, cond func() bool) {
+	o.t.Helper()
+	for deadline := time.Now().Add(waitTimeout); !cond(); {
+		if time.Now().After(deadline) {
+			o.t.Fatalf("overlaytest: timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
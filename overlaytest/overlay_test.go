@@ -0,0 +1,515 @@
+package overlaytest
+
+import (
+	"bytes"
+	"context"
+	"demo1/proxy/cache"
+	"demo1/proxy/config"
+	"demo1/proxy/faultnet"
+	"demo1/proxy/handler"
+	"demo1/proxy/tracing"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForwarding(t *testing.T) {
+	o := New(t)
+	in := o.AddNode("in", config.RoleIngress)
+	r1 := o.AddNode("r1", config.RoleRelay)
+	r2 := o.AddNode("r2", config.RoleRelay)
+	out := o.AddNode("out", config.RoleEgress)
+	origin := o.AddOrigin("origin", nil)
+	o.Route(config.DefaultRoute, Path(r1, r2, out))
+	o.Start()
+
+	resp := in.MustGet(origin.Target("/hello"))
+	if resp.StatusCode != http.StatusOK || string(resp.Body) != "hello from origin" {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, resp.Body)
+	}
+	o.AssertPath(resp, in, r1, r2, out)
+
+	// 目标服务器看到的请求按转发顺序经过每个节点
+	requests := origin.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("origin received %d requests, want 1", len(requests))
+	}
+	if got := viaNodes(requests[0].Header); !reflect.DeepEqual(got, IDs(in, r1, r2, out)) {
+		t.Errorf("origin saw Via %v", got)
+	}
+}
+
+func TestFailoverAndRecovery(t *testing.T) {
+	o := New(t)
+	in := o.AddNode("in", config.RoleIngress)
+	r1 := o.AddNode("r1", config.RoleRelay)
+	r2 := o.AddNode("r2", config.RoleRelay)
+	out1 := o.AddNode("out1", config.RoleEgress)
+	out2 := o.AddNode("out2", config.RoleEgress)
+	origin := o.AddOrigin("origin", nil)
+	o.Route(config.DefaultRoute, Path(r1, out1), Path(r2, out2))
+	o.Start()
+
+	o.AssertPath(in.MustGet(origin.Target("/")), in, r1, out1)
+
+	// 主路径的出口故障，r1 报告故障节点，入口改走备用路径
+	out1.Stop()
+	resp := in.MustGet(origin.Target("/"))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("failover returned %d %q", resp.StatusCode, resp.Body)
+	}
+	o.AssertPath(resp, in, r2, out2)
+	if !in.Ingress.Health.IsDown(out1.IP) {
+		t.Fatalf("expected %s to be marked down", out1.ID)
+	}
+
+	// 故障节点恢复后，健康检查把它重新加入选路
+	out1.Start()
+	o.Eventually("out1 to be marked up", func() bool { return !in.Ingress.Health.IsDown(out1.IP) })
+	o.AssertPath(in.MustGet(origin.Target("/")), in, r1, out1)
+}
+
+func TestFaultyLink(t *testing.T) {
+	o := New(t)
+	in := o.AddNode("in", config.RoleIngress)
+	r1 := o.AddNode("r1", config.RoleRelay)
+	r2 := o.AddNode("r2", config.RoleRelay)
+	out := o.AddNode("out", config.RoleEgress)
+	origin := o.AddOrigin("origin", nil)
+	o.Route(config.DefaultRoute, Path(r1, out), Path(r2, out))
+	o.Start()
+
+	// 到 r1 的链路有时延，往返至少经过两次
+	r1.Link.Set(faultnet.Faults{Latency: 40 * time.Millisecond})
+	start := time.Now()
+	o.AssertPath(in.MustGet(origin.Target("/")), in, r1, out)
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("request over a 40ms link took %v", elapsed)
+	}
+
+	// 到 r1 的连接全部被重置，入口改走备用路径
+	r1.Link.Set(faultnet.Faults{ResetRate: 1})
+	o.AssertPath(in.MustGet(origin.Target("/")), in, r2, out)
+	if r1.Link.Stats().Resets == 0 {
+		t.Error("expected injected resets on r1's link")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	o := New(t)
+	p1 := o.AddNode("p1", config.RoleProbe)
+	p2 := o.AddNode("p2", config.RoleProbe)
+	o.Start()
+
+	if err := p1.StartProbe(p2); err != nil {
+		t.Fatal(err)
+	}
+	o.Eventually("a probe report", func() bool { return len(o.Controller.ProbeResults()) > 0 })
+	result := o.Controller.ProbeResults()[0]
+	if result.IP1 != p1.IP || result.IP2 != p2.host {
+		t.Errorf("unexpected probe report %+v", result)
+	}
+}
+
+func TestStripedLinks(t *testing.T) {
+	o := New(t)
+	in := o.AddNode("in", config.RoleIngress)
+	relay := o.AddNode("relay", config.RoleRelay)
+	out := o.AddNode("out", config.RoleEgress)
+	body := bytes.Repeat([]byte("0123456789abcdef"), 64<<10)
+	origin := o.AddOrigin("origin", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(body)
+	})
+	o.Route(config.DefaultRoute, Path(relay, out))
+
+	// 入口到中继使用三条通道，中继到出口按下一跳设置为两条
+	in.Relay.Lanes = 3
+	relay.Relay.PeerLanes = map[string]int{out.IP: 2}
+	relay.Link.Set(faultnet.Faults{Latency: time.Millisecond, Jitter: time.Millisecond})
+	o.Start()
+
+	resp := in.MustGet(origin.Target("/large"))
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(resp.Body, body) {
+		t.Fatalf("unexpected response %d with %d bytes", resp.StatusCode, len(resp.Body))
+	}
+	o.AssertPath(resp, in, relay, out)
+
+	lanes := map[string]int{}
+	for _, n := range []*Node{in, relay} {
+		for _, s := range n.Relay.Sessions.Sessions() {
+			if s.Direction == handler.DirectionOutbound {
+				lanes[n.ID] = s.Lanes
+			}
+		}
+	}
+	if lanes["in"] != 3 || lanes["relay"] != 2 {
+		t.Errorf("unexpected outbound lanes %v", lanes)
+	}
+}
+
+func TestReverseTunnel(t *testing.T) {
+	o := New(t)
+	in := o.AddNode("in", config.RoleIngress)
+	rendezvous := o.AddNode("rendezvous", config.RoleRelay)
+	nat := o.AddNode("nat", config.RoleEgress)
+	origin := o.AddOrigin("origin", nil)
+	o.Route(config.DefaultRoute, Path(rendezvous, nat))
+
+	// nat 不监听中继端口，只能通过它主动建立的隧道到达
+	rendezvous.Relay.AcceptTunnels = true
+	rendezvous.Relay.TunnelToken, nat.Relay.TunnelToken = "secret", "secret"
+	nat.Rendezvous = rendezvous
+	nat.Relay.TunnelMaxBackoff = 50 * time.Millisecond
+	o.Start()
+
+	registered := func() time.Time {
+		tunnels := rendezvous.Relay.Tunnels()
+		if len(tunnels) != 1 || tunnels[0].NodeID != "nat" {
+			return time.Time{}
+		}
+		return tunnels[0].Registered
+	}
+	o.Eventually("tunnel registered", func() bool { return !registered().IsZero() })
+	first := registered()
+
+	resp := in.MustGet(origin.Target("/hello"))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, resp.Body)
+	}
+	o.AssertPath(resp, in, rendezvous, nat)
+
+	// 会合中继关闭隧道后，nat 自动重新连接并注册
+	for _, s := range rendezvous.Relay.Sessions.Sessions() {
+		if s.Direction == handler.DirectionOutbound {
+			rendezvous.Relay.Sessions.CloseSession(s.ID)
+		}
+	}
+	o.Eventually("tunnel re-registered", func() bool { return registered().After(first) })
+	resp = in.MustGet(origin.Target("/hello"))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected response after reconnect %d %q", resp.StatusCode, resp.Body)
+	}
+	o.AssertPath(resp, in, rendezvous, nat)
+}
+
+func TestUnroutedHostIsNotFetched(t *testing.T) {
+	o := New(t)
+	in := o.AddNode("in", config.RoleIngress)
+	out := o.AddNode("out", config.RoleEgress)
+	origin := o.AddOrigin("origin", nil)
+	o.Route("overlay.invalid", Path(out))
+	o.Start()
+
+	// 源站的主机没有路由，入口不能替客户端直接访问它
+	resp := in.MustGet(origin.Target("/"))
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected 502 for an unrouted host, got %d", resp.StatusCode)
+	}
+	if requests := origin.Requests(); len(requests) != 0 {
+		t.Errorf("origin received %d requests for an unrouted host", len(requests))
+	}
+}
+
+func TestClientRevalidationKeepsCacheEntry(t *testing.T) {
+	o := New(t)
+	in := o.AddNode("in", config.RoleIngress)
+	out := o.AddNode("out", config.RoleEgress)
+	origin := o.AddOrigin("origin", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Write([]byte("v1"))
+	})
+	o.Route(config.DefaultRoute, Path(out))
+	responseCache, err := cache.New(cache.Options{MemoryBytes: 1 << 20, MaxEntryBytes: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.Ingress.Cache = responseCache
+	o.Start()
+
+	if resp := in.MustGet(origin.Target("/doc")); resp.Header.Get(handler.CacheStatusHeader) != "MISS" {
+		t.Fatalf("expected the first request to miss, got %q", resp.Header.Get(handler.CacheStatusHeader))
+	}
+
+	// 客户端用自己的校验器得到 304，与缓存一致的条目被刷新而不是删除
+	req, _ := http.NewRequest(http.MethodGet, origin.Target("/doc"), nil)
+	req.Header.Set("If-None-Match", `"v1"`)
+	resp, err := in.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected the client's 304, got %d", resp.StatusCode)
+	}
+	resp = in.MustGet(origin.Target("/doc"))
+	if status := resp.Header.Get(handler.CacheStatusHeader); status != "HIT" || string(resp.Body) != "v1" {
+		t.Errorf("expected the refreshed entry to be served, got %s %q", status, resp.Body)
+	}
+}
+
+func TestDrainOutboundSession(t *testing.T) {
+	o := New(t)
+	in := o.AddNode("in", config.RoleIngress)
+	out := o.AddNode("out", config.RoleEgress)
+	origin := o.AddOrigin("origin", nil)
+	o.Route(config.DefaultRoute, Path(out))
+	o.Start()
+
+	outbound := func() []uint64 {
+		var ids []uint64
+		for _, s := range in.Relay.Sessions.Sessions() {
+			if s.Direction == handler.DirectionOutbound {
+				ids = append(ids, s.ID)
+			}
+		}
+		return ids
+	}
+	o.AssertPath(in.MustGet(origin.Target("/")), in, out)
+	before := outbound()
+	if len(before) != 1 {
+		t.Fatalf("expected one outbound session, got %v", before)
+	}
+
+	// 排空的会话移出会话池，没有流后关闭，之后的请求使用新的会话
+	if err := in.Relay.DrainSession(before[0]); err != nil {
+		t.Fatal(err)
+	}
+	o.AssertPath(in.MustGet(origin.Target("/")), in, out)
+	o.Eventually("drained session to close", func() bool {
+		after := outbound()
+		return len(after) == 1 && after[0] != before[0]
+	})
+
+	// 会话正常关闭时底层连接计入 closed，而不是被丢弃
+	stats, _ := in.Relay.PoolStats()
+	if info := stats[out.IP]; info.Closed != 1 || info.Discarded != 0 || info.Active != 1 {
+		t.Errorf("expected the drained session's connection to be counted as closed, got %+v", info.Stats)
+	}
+}
+
+func TestPoolStats(t *testing.T) {
+	o := New(t)
+	in := o.AddNode("in", config.RoleIngress)
+	out := o.AddNode("out", config.RoleEgress)
+	origin := o.AddOrigin("origin", nil)
+	o.Route(config.DefaultRoute, Path(out))
+	in.Relay.SessionsPerHop = 1
+	o.Start()
+
+	// 管理接口和指标报告的是转发实际使用的连接池和会话池
+	o.AssertPath(in.MustGet(origin.Target("/")), in, out)
+	o.AssertPath(in.MustGet(origin.Target("/")), in, out)
+	stats, total := in.Relay.PoolStats()
+	info, ok := stats[out.IP]
+	if !ok {
+		t.Fatalf("expected pool stats for %s, got %+v", out.IP, stats)
+	}
+	if info.Active != 1 || info.Created != 1 || info.Sessions.Sessions != 1 || info.Sessions.Dials != 1 {
+		t.Errorf("expected one pooled connection carrying one session, got %+v", info)
+	}
+	if total.Active != info.Active || total.Sessions.Dials != info.Sessions.Dials {
+		t.Errorf("total %+v does not match the only next hop %+v", total, info)
+	}
+}
+
+// spanRecorder 记录导出的 span，Close 在追踪器导出剩余的 span 之后调用
+type spanRecorder struct {
+	mu     sync.Mutex
+	spans  []*tracing.Span
+	closed chan struct{}
+}
+
+func (r *spanRecorder) Export(_ context.Context, spans []*tracing.Span) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *spanRecorder) Close() error {
+	close(r.closed)
+	return nil
+}
+
+func TestTracePoolCheckout(t *testing.T) {
+	o := New(t)
+	in := o.AddNode("in", config.RoleIngress)
+	out := o.AddNode("out", config.RoleEgress)
+	origin := o.AddOrigin("origin", nil)
+	o.Route(config.DefaultRoute, Path(out))
+	recorder := &spanRecorder{closed: make(chan struct{})}
+	tracer := tracing.NewTracer(1, recorder)
+	in.Ingress.Tracer, in.Relay.Tracer = tracer, tracer
+	o.Start()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go tracer.Run(ctx)
+	o.AssertPath(in.MustGet(origin.Target("/")), in, out)
+	cancel()
+	<-recorder.closed
+
+	// 第一个请求在会话池中同步建立会话：从会话池取流和从连接池取连接各是一个 pool.get span
+	byID := make(map[tracing.SpanID]*tracing.Span)
+	for _, span := range recorder.spans {
+		byID[span.Context.SpanID] = span
+	}
+	var sessionGet, connGet bool
+	for _, span := range recorder.spans {
+		if span.Name != "pool.get" {
+			continue
+		}
+		switch parent := byID[span.Parent]; {
+		case parent == nil:
+			t.Errorf("pool.get span has no exported parent")
+		case parent.Name == "smux.open_stream":
+			sessionGet = true
+		case parent.Name == "pool.get":
+			connGet = true
+		}
+	}
+	if !sessionGet || !connGet {
+		t.Errorf("expected pool.get spans for the session and the connection checkout, got session=%v conn=%v", sessionGet, connGet)
+	}
+}
+
+func TestPoolLimits(t *testing.T) {
+	o := New(t)
+	in := o.AddNode("in", config.RoleIngress)
+	out := o.AddNode("out", config.RoleEgress)
+	origin := o.AddOrigin("origin", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("ok"))
+	})
+	o.Route(config.DefaultRoute, Path(out))
+	in.Relay.SessionsPerHop = 2
+	in.Relay.Pool.Pool.MaxActive = 1
+	in.Relay.PoolWaitTimeout = 100 * time.Millisecond
+	o.Start()
+
+	// 连接数上限作用于会话的底层连接：会话池想要第二个会话时等不到连接，所有请求共用一个会话
+	o.AssertPath(in.MustGet(origin.Target("/")), in, out)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp, err := in.Get(origin.Target("/")); err != nil || resp.StatusCode != http.StatusOK {
+				t.Errorf("request under the connection limit failed: %v %+v", err, resp)
+			}
+		}()
+	}
+	wg.Wait()
+	o.Eventually("second session to give up waiting", func() bool {
+		stats, _ := in.Relay.PoolStats()
+		return stats[out.IP].WaitTimeouts > 0
+	})
+	stats, _ := in.Relay.PoolStats()
+	if info := stats[out.IP]; info.Created != 1 || info.Sessions.Sessions != 1 {
+		t.Errorf("expected one connection and one session under max_active 1, got %+v", info)
+	}
+}
+
+func TestPoolLimitReclaimsIdleSession(t *testing.T) {
+	o := New(t)
+	in := o.AddNode("in", config.RoleIngress)
+	out1 := o.AddNode("out1", config.RoleEgress)
+	out2 := o.AddNode("out2", config.RoleEgress)
+	origin := o.AddOrigin("origin", nil)
+	o.Route(config.DefaultRoute, Path(out1))
+	in.Relay.Pool.MaxTotal = 1
+	in.Relay.PoolWaitTimeout = 100 * time.Millisecond
+	o.Start()
+
+	o.AssertPath(in.MustGet(origin.Target("/")), in, out1)
+
+	// 到 out1 的空闲会话占满连接总数，换到新的下一跳时关闭它空出连接，而不是等待超时返回 503
+	time.Sleep(150 * time.Millisecond)
+	o.Route(config.DefaultRoute, Path(out2))
+	o.AssertPath(in.MustGet(origin.Target("/")), in, out2)
+
+	stats, _ := in.Relay.PoolStats()
+	if info := stats[out1.IP]; info.Sessions.Reclaimed != 1 || info.Sessions.Sessions != 0 {
+		t.Errorf("expected the idle session to out1 to be reclaimed, got %+v", info)
+	}
+	if info := stats[out2.IP]; info.WaitTimeouts != 0 || info.Sessions.Sessions != 1 {
+		t.Errorf("expected out2 to get a session without waiting, got %+v", info)
+	}
+}
+
+func TestPoolLimitRejectsStripedSession(t *testing.T) {
+	o := New(t)
+	in := o.AddNode("in", config.RoleIngress)
+	out := o.AddNode("out", config.RoleEgress)
+	origin := o.AddOrigin("origin", nil)
+	o.Route(config.DefaultRoute, Path(out))
+	in.Relay.Lanes = 2
+	in.Relay.Pool.MaxTotal = 1
+	in.Relay.PoolWaitTimeout = 50 * time.Millisecond
+	o.Start()
+
+	// 条带化会话取不到全部通道的连接时入口返回 503，不把下一跳标记为故障
+	resp := in.MustGet(origin.Target("/"))
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when the lanes exceed max_total, got %d", resp.StatusCode)
+	}
+	if down := in.Ingress.Health.DownHops(); len(down) != 0 {
+		t.Errorf("expected no hop to be marked down, got %v", down)
+	}
+	if n := len(origin.Requests()); n != 0 {
+		t.Errorf("expected the origin to receive no requests, got %d", n)
+	}
+}
+
+func TestDrainingRelayRejectsNewStreams(t *testing.T) {
+	o := New(t)
+	in := o.AddNode("in", config.RoleIngress)
+	r1 := o.AddNode("r1", config.RoleRelay)
+	r2 := o.AddNode("r2", config.RoleRelay)
+	out := o.AddNode("out", config.RoleEgress)
+	release := make(chan struct{})
+	origin := o.AddOrigin("origin", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.Write([]byte("ok"))
+	})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	t.Cleanup(unblock)
+	o.Route(config.DefaultRoute, Path(r1, out), Path(r2, out))
+	// 入口到 r1 只保持一个会话，新的流一定落在被排空的会话上
+	in.Relay.SessionsPerHop = 1
+	o.Start()
+
+	// 慢请求占住 r1 的入向会话，排空期间会话保持打开
+	slow := make(chan *Response, 1)
+	go func() { slow <- in.MustGet(origin.Target("/slow")) }()
+	o.Eventually("slow request at the origin", func() bool { return len(origin.Requests()) == 1 })
+	for _, s := range r1.Relay.Sessions.Sessions() {
+		if s.Direction == handler.DirectionInbound {
+			if err := r1.Relay.DrainSession(s.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// 排空中的 r1 返回 503 并标明自己，入口改走备用路径
+	resp := in.MustGet(origin.Target("/fast"))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, resp.Body)
+	}
+	o.AssertPath(resp, in, r2, out)
+	if !in.Ingress.Health.IsDown(r1.IP) {
+		t.Error("expected the draining relay to be reported by its 503")
+	}
+
+	unblock()
+	o.AssertPath(<-slow, in, r1, out)
+}
@@ -0,0 +1,226 @@
+// Package accesslog 输出结构化的访问日志：入口和每个中继节点为每个请求写一行 JSON，
+// 日志文件超过大小上限后轮转
+package accesslog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestIDHeader 入口为每个请求分配的 ID，随请求经过所有中继节点到达源站，并返回给客户端
+const RequestIDHeader = "X-Request-ID"
+
+// HopTimingHeader 中继节点在响应中报告各跳耗时，入口记录后从响应中删除。
+// 格式为 "10.0.0.2;dur=12.5, 10.0.0.3;dur=8.1"，按转发路径的顺序排列，dur 的单位是毫秒
+const HopTimingHeader = "X-Overlay-Hop-Timing"
+
+// 节点在一条访问记录中的角色
+const (
+	RoleIngress = "ingress"
+	RoleRelay   = "relay"
+	RoleEgress  = "egress"
+)
+
+// maxRequestIDLen 沿用客户端请求 ID 时允许的最大长度
+const maxRequestIDLen = 128
+
+// HopTiming 一跳的耗时：从该节点收到请求到拿到下游响应头的时间
+type HopTiming struct {
+	Hop        string  `json:"hop"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Record 一条访问记录
+type Record struct {
+	Time       time.Time   `json:"time"`
+	Node       string      `json:"node"`
+	Role       string      `json:"role"`
+	RequestID  string      `json:"request_id"`
+	PacketID   uint32      `json:"packet_id,omitempty"`
+	Client     string      `json:"client"`
+	Method     string      `json:"method"`
+	Host       string      `json:"host"`
+	Path       string      `json:"path"`
+	Status     int         `json:"status"`
+	BytesIn    int64       `json:"bytes_in"`  // 请求体字节数
+	BytesOut   int64       `json:"bytes_out"` // 响应体字节数
+	DurationMs float64     `json:"duration_ms"`
+	Hops       []string    `json:"hops,omitempty"`        // 选中的转发路径
+	HopTimings []HopTiming `json:"hop_timings,omitempty"` // 下游各跳的耗时
+	Cache      string      `json:"cache,omitempty"`       // 入口缓存结果
+	Error      string      `json:"error,omitempty"`
+}
+
+// Options 访问日志配置
+type Options struct {
+	Path       string // 日志文件路径，"-" 表示标准输出
+	MaxBytes   int64  // 单个文件的大小上限，超过后轮转，0 表示不轮转
+	MaxBackups int    // 保留的历史文件数量，历史文件依次命名为 Path.1、Path.2 ...
+}
+
+// Logger 访问日志，为空时不记录
+type Logger struct {
+	opts Options
+
+	mu   sync.Mutex
+	out  io.Writer
+	file *os.File
+	size int64
+}
+
+// New 创建访问日志，打开或创建日志文件
+func New(opts Options) (*Logger, error) {
+	l := &Logger{opts: opts}
+	if opts.Path == "" || opts.Path == "-" {
+		l.out = os.Stdout
+		return l, nil
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open 以追加方式打开日志文件
+func (l *Logger) open() error {
+	file, err := os.OpenFile(l.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open access log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open access log: %w", err)
+	}
+	l.file, l.out, l.size = file, file, info.Size()
+	return nil
+}
+
+// Log 写入一条访问记录
+func (l *Logger) Log(record *Record) {
+	if l == nil {
+		return
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Failed to encode access log record: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil && l.opts.MaxBytes > 0 && l.size > 0 && l.size+int64(len(line)) > l.opts.MaxBytes {
+		if err := l.rotate(); err != nil {
+			log.Printf("Failed to rotate access log: %v", err)
+		}
+	}
+	if l.out == nil {
+		return
+	}
+	n, err := l.out.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("Failed to write access log: %v", err)
+	}
+}
+
+// rotate 把当前文件重命名为 Path.1，已有的历史文件依次后移，超过 MaxBackups 的删除
+func (l *Logger) rotate() error {
+	l.file.Close()
+	l.file, l.out = nil, nil
+
+	path := l.opts.Path
+	if l.opts.MaxBackups <= 0 {
+		os.Remove(path)
+	} else {
+		os.Remove(path + "." + strconv.Itoa(l.opts.MaxBackups))
+		for i := l.opts.MaxBackups - 1; i >= 1; i-- {
+			os.Rename(path+"."+strconv.Itoa(i), path+"."+strconv.Itoa(i+1))
+		}
+		if err := os.Rename(path, path+".1"); err != nil {
+			return err
+		}
+	}
+	return l.open()
+}
+
+// Close 关闭日志文件
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file, l.out = nil, nil
+	return err
+}
+
+// NewRequestID 生成一个随机的请求 ID
+func NewRequestID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// ValidRequestID 判断客户端带来的请求 ID 能否沿用：长度有限且只包含可打印字符
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// AddHopTiming 在响应头部的各跳耗时前面加上本节点，上游节点排在下游节点之前
+func AddHopTiming(header http.Header, hop string, d time.Duration) {
+	entry := hop + ";dur=" + strconv.FormatFloat(Milliseconds(d), 'f', 3, 64)
+	if existing := header.Get(HopTimingHeader); existing != "" {
+		entry += ", " + existing
+	}
+	header.Set(HopTimingHeader, entry)
+}
+
+// ParseHopTimings 解析响应头部中的各跳耗时，格式错误的条目被忽略
+func ParseHopTimings(header http.Header) []HopTiming {
+	var timings []HopTiming
+	for _, entry := range strings.Split(header.Get(HopTimingHeader), ",") {
+		hop, params, found := strings.Cut(strings.TrimSpace(entry), ";")
+		if !found || hop == "" {
+			continue
+		}
+		value, ok := strings.CutPrefix(strings.TrimSpace(params), "dur=")
+		if !ok {
+			continue
+		}
+		ms, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		timings = append(timings, HopTiming{Hop: hop, DurationMs: ms})
+	}
+	return timings
+}
+
+// Milliseconds 以毫秒为单位的时长，保留小数
+func Milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
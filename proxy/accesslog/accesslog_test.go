@@ -0,0 +1,59 @@
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHopTimingHeader(t *testing.T) {
+	header := http.Header{}
+	// 响应从出口返回，离入口越近的节点越晚加入
+	AddHopTiming(header, "10.0.0.3", 8*time.Millisecond)
+	AddHopTiming(header, "10.0.0.2", 12500*time.Microsecond)
+
+	timings := ParseHopTimings(header)
+	if len(timings) != 2 || timings[0].Hop != "10.0.0.2" || timings[1].Hop != "10.0.0.3" {
+		t.Fatalf("unexpected hop order: %+v", timings)
+	}
+	if timings[0].DurationMs != 12.5 || timings[1].DurationMs != 8 {
+		t.Fatalf("unexpected durations: %+v", timings)
+	}
+}
+
+func TestLoggerRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	logger, err := New(Options{Path: path, MaxBytes: 300, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		logger.Log(&Record{RequestID: NewRequestID(), Method: "GET", Host: "example.com", Path: "/", Status: 200})
+	}
+	logger.Close()
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected at most 2 backups, stat err = %v", err)
+	}
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var record Record
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Fatalf("%s: invalid line %q: %v", name, scanner.Text(), err)
+			}
+		}
+		file.Close()
+		if info, _ := os.Stat(name); info.Size() > 300 {
+			t.Fatalf("%s exceeds MaxBytes: %d", name, info.Size())
+		}
+	}
+}
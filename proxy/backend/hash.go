@@ -0,0 +1,50 @@
+package backend
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// virtualNodes 每个源站在哈希环上的虚拟节点数，使请求分布更均匀
+const virtualNodes = 100
+
+// hashRing 一致性哈希环。环上包含所有源站，查找时跳过不可用的源站，
+// 这样源站被摘除时只有原本落在它上面的键会迁移
+type hashRing struct {
+	hashes  []uint32
+	origins map[uint32]*Origin
+}
+
+func newHashRing(origins []*Origin) *hashRing {
+	ring := &hashRing{origins: make(map[uint32]*Origin, len(origins)*virtualNodes)}
+	for _, origin := range origins {
+		for i := 0; i < virtualNodes; i++ {
+			hash := crc32.ChecksumIEEE([]byte(origin.Addr + "#" + strconv.Itoa(i)))
+			if _, exists := ring.origins[hash]; exists {
+				continue
+			}
+			ring.origins[hash] = origin
+			ring.hashes = append(ring.hashes, hash)
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool { return ring.hashes[i] < ring.hashes[j] })
+	return ring
+}
+
+// lookup 顺时针找到第一个可用的源站，没有时返回 nil
+func (ring *hashRing) lookup(key string, now time.Time) *Origin {
+	if len(ring.hashes) == 0 {
+		return nil
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(ring.hashes), func(i int) bool { return ring.hashes[i] >= hash })
+	for i := 0; i < len(ring.hashes); i++ {
+		origin := ring.origins[ring.hashes[(start+i)%len(ring.hashes)]]
+		if origin.available(now) {
+			return origin
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package backend
+
+import (
+	"context"
+	"demo1/proxy/config"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// checkClient 主动健康检查使用的 HTTP 客户端，不跟随重定向，3xx 也视为健康
+var checkClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// runHealthChecks 按配置的间隔检查池中的每个源站，ctx 结束时返回
+func (p *Pool) runHealthChecks(ctx context.Context) {
+	check := p.Config.HealthCheck
+	ticker := time.NewTicker(time.Duration(check.IntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+		for _, origin := range p.Origins {
+			wg.Add(1)
+			go func(origin *Origin) {
+				defer wg.Done()
+				p.recordCheck(origin, p.probe(ctx, origin))
+			}(origin)
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe 对源站执行一次健康检查
+func (p *Pool) probe(ctx context.Context, origin *Origin) error {
+	check := p.Config.HealthCheck
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(check.TimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	if check.Type == config.HealthCheckTCP {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", origin.Addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL(origin, check.Path), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := checkClient.Do(req)
+	if err != nil {
+		return err
+	}
+	discard(resp.Body)
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("health check returned %s", resp.Status)
+	}
+	return nil
+}
+
+// recordCheck 记录检查结果：健康的源站连续失败 UnhealthyThreshold 次后摘除，
+// 被摘除的源站连续成功 HealthyThreshold 次后恢复
+func (p *Pool) recordCheck(origin *Origin, err error) {
+	check := p.Config.HealthCheck
+	origin.mu.Lock()
+	defer origin.mu.Unlock()
+
+	if err == nil {
+		if origin.checkStreak < 0 {
+			origin.checkStreak = 0
+		}
+		origin.checkStreak++
+		if !origin.healthy && origin.checkStreak >= check.HealthyThreshold {
+			origin.healthy = true
+			origin.fails = 0
+			origin.ejectedUntil = time.Time{}
+			log.Printf("backend %s: origin %s is healthy again", p.Config.Name, origin.Addr)
+		}
+		return
+	}
+
+	origin.lastError = err.Error()
+	if origin.checkStreak > 0 {
+		origin.checkStreak = 0
+	}
+	origin.checkStreak--
+	if origin.healthy && -origin.checkStreak >= check.UnhealthyThreshold {
+		origin.healthy = false
+		log.Printf("backend %s: origin %s marked unhealthy: %v", p.Config.Name, origin.Addr, err)
+	}
+}
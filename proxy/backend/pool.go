@@ -0,0 +1,302 @@
+// Package backend 实现出口节点的后端池：按目的主机选择一组源站，
+// 用轮询、最少连接或一致性哈希分配请求，主动健康检查并摘除故障源站，可选按 Cookie 保持会话
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"demo1/proxy/config"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoHealthyOrigin 池中没有可用的源站
+var ErrNoHealthyOrigin = errors.New("no healthy origin")
+
+// Origin 后端池中的一个源站
+type Origin struct {
+	Addr string // host:port
+	ID   string // 会话保持 Cookie 中使用的标识
+
+	active atomic.Int64 // 正在处理的请求数
+
+	mu           sync.Mutex
+	healthy      bool      // 主动健康检查的结果
+	checkStreak  int       // 连续成功（正数）或失败（负数）的检查次数
+	fails        int       // 连续失败的请求数
+	ejectedUntil time.Time // 被动摘除的截止时间
+	lastError    string
+}
+
+// OriginStatus 源站的运行状态
+type OriginStatus struct {
+	Addr      string    `json:"addr"`
+	Healthy   bool      `json:"healthy"`
+	Ejected   bool      `json:"ejected"`
+	Active    int64     `json:"active"`
+	Fails     int       `json:"fails"`
+	EjectedAt time.Time `json:"ejected_until,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// PoolStatus 后端池的运行状态
+type PoolStatus struct {
+	Name    string         `json:"name"`
+	Balance string         `json:"balance"`
+	Origins []OriginStatus `json:"origins"`
+}
+
+// available 判断源站当前能否接收请求
+func (o *Origin) available(now time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.healthy && !now.Before(o.ejectedUntil)
+}
+
+func (o *Origin) status(now time.Time) OriginStatus {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	status := OriginStatus{
+		Addr:      o.Addr,
+		Healthy:   o.healthy,
+		Ejected:   now.Before(o.ejectedUntil),
+		Active:    o.active.Load(),
+		Fails:     o.fails,
+		LastError: o.lastError,
+	}
+	if status.Ejected {
+		status.EjectedAt = o.ejectedUntil
+	}
+	return status
+}
+
+// Pool 一个后端池
+type Pool struct {
+	Config  *config.BackendPool
+	Origins []*Origin
+
+	next atomic.Uint64 // 轮询计数
+	ring *hashRing
+}
+
+// NewPool 根据配置创建后端池，所有源站初始为健康
+func NewPool(cfg *config.BackendPool) *Pool {
+	pool := &Pool{Config: cfg}
+	for _, addr := range cfg.Origins {
+		sum := sha256.Sum256([]byte(cfg.Name + "/" + addr))
+		pool.Origins = append(pool.Origins, &Origin{
+			Addr:    addr,
+			ID:      hex.EncodeToString(sum[:8]),
+			healthy: true,
+		})
+	}
+	pool.ring = newHashRing(pool.Origins)
+	return pool
+}
+
+// Pick 为请求选择源站。启用会话保持时优先使用 Cookie 指定的源站，
+// setCookie 为 true 表示需要在响应中设置新的会话保持 Cookie
+func (p *Pool) Pick(r *http.Request) (origin *Origin, setCookie bool, err error) {
+	now := time.Now()
+	if name := p.Config.StickyCookie; name != "" {
+		if cookie, err := r.Cookie(name); err == nil {
+			for _, o := range p.Origins {
+				if o.ID == cookie.Value && o.available(now) {
+					return o, false, nil
+				}
+			}
+		}
+	}
+
+	candidates := make([]*Origin, 0, len(p.Origins))
+	for _, o := range p.Origins {
+		if o.available(now) {
+			candidates = append(candidates, o)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, false, ErrNoHealthyOrigin
+	}
+
+	switch p.Config.Balance {
+	case config.BalanceLeastConn:
+		origin = candidates[0]
+		for _, o := range candidates[1:] {
+			if o.active.Load() < origin.active.Load() {
+				origin = o
+			}
+		}
+	case config.BalanceConsistentHash:
+		origin = p.ring.lookup(p.hashKey(r), now)
+	default:
+		origin = candidates[int(p.next.Add(1)-1)%len(candidates)]
+	}
+	if origin == nil {
+		return nil, false, ErrNoHealthyOrigin
+	}
+	return origin, p.Config.StickyCookie != "", nil
+}
+
+// StickyCookie 返回把后续请求固定到该源站的 Cookie
+func (p *Pool) StickyCookie(origin *Origin) *http.Cookie {
+	return &http.Cookie{Name: p.Config.StickyCookie, Value: origin.ID, Path: "/", HttpOnly: true}
+}
+
+// URL 返回请求在源站上的地址
+func (p *Pool) URL(origin *Origin, requestURI string) string {
+	return p.Config.Scheme + "://" + origin.Addr + requestURI
+}
+
+// Acquire 记录源站开始处理一个请求，返回的函数在请求结束时调用，failed 表示源站出错
+func (p *Pool) Acquire(origin *Origin) func(failed bool, err error) {
+	origin.active.Add(1)
+	var once sync.Once
+	return func(failed bool, err error) {
+		once.Do(func() {
+			origin.active.Add(-1)
+			p.recordResult(origin, failed, err)
+		})
+	}
+}
+
+// recordResult 被动健康检查：连续失败达到 MaxFails 时摘除源站 EjectMs 毫秒
+func (p *Pool) recordResult(origin *Origin, failed bool, err error) {
+	origin.mu.Lock()
+	defer origin.mu.Unlock()
+	if !failed {
+		origin.fails = 0
+		return
+	}
+	origin.fails++
+	if err != nil {
+		origin.lastError = err.Error()
+	}
+	if p.Config.MaxFails > 0 && origin.fails >= p.Config.MaxFails {
+		origin.ejectedUntil = time.Now().Add(time.Duration(p.Config.EjectMs) * time.Millisecond)
+		origin.fails = 0
+	}
+}
+
+// hashKey 计算一致性哈希使用的键
+func (p *Pool) hashKey(r *http.Request) string {
+	key := p.Config.HashKey
+	switch {
+	case key == "path":
+		return r.URL.Path
+	case strings.HasPrefix(key, "header:"):
+		return r.Header.Get(strings.TrimPrefix(key, "header:"))
+	case strings.HasPrefix(key, "cookie:"):
+		if cookie, err := r.Cookie(strings.TrimPrefix(key, "cookie:")); err == nil {
+			return cookie.Value
+		}
+		return ""
+	}
+	return clientIP(r)
+}
+
+// Status 返回后端池的运行状态
+func (p *Pool) Status() PoolStatus {
+	now := time.Now()
+	status := PoolStatus{Name: p.Config.Name, Balance: p.Config.Balance}
+	for _, o := range p.Origins {
+		status.Origins = append(status.Origins, o.status(now))
+	}
+	return status
+}
+
+// clientIP 真实的客户端 IP：优先取入口设置的 X-Forwarded-For 中的第一项
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(first)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Set 出口节点的所有后端池
+type Set struct {
+	pools []*Pool
+}
+
+// NewSet 根据配置创建后端池集合
+func NewSet(cfgs []*config.BackendPool) *Set {
+	set := &Set{}
+	for _, cfg := range cfgs {
+		set.pools = append(set.pools, NewPool(cfg))
+	}
+	return set
+}
+
+// Match 返回目的主机对应的后端池，按配置顺序匹配，没有时返回 nil
+func (s *Set) Match(host string) *Pool {
+	if s == nil {
+		return nil
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, pool := range s.pools {
+		if matchHost(pool.Config.Hosts, host) {
+			return pool
+		}
+	}
+	return nil
+}
+
+// Run 对所有配置了主动健康检查的后端池执行检查，ctx 结束时返回
+func (s *Set) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, pool := range s.pools {
+		if pool.Config.HealthCheck == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(pool *Pool) {
+			defer wg.Done()
+			pool.runHealthChecks(ctx)
+		}(pool)
+	}
+	wg.Wait()
+}
+
+// Status 返回所有后端池的运行状态
+func (s *Set) Status() []PoolStatus {
+	if s == nil {
+		return nil
+	}
+	statuses := make([]PoolStatus, 0, len(s.pools))
+	for _, pool := range s.pools {
+		statuses = append(statuses, pool.Status())
+	}
+	return statuses
+}
+
+// matchHost 判断主机是否匹配任一模式，模式支持 "*" 和 "*.example.com"
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == host {
+			return true
+		}
+		if suffix, found := strings.CutPrefix(pattern, "*"); found && strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// discard 读完并关闭响应体，便于复用连接
+func discard(body io.ReadCloser) {
+	io.Copy(io.Discard, body)
+	body.Close()
+}
@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"demo1/proxy/config"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestPool(t *testing.T, cfg *config.BackendPool) *Pool {
+	t.Helper()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return NewPool(cfg)
+}
+
+func TestPickConsistentHashAndEject(t *testing.T) {
+	pool := newTestPool(t, &config.BackendPool{
+		Name:     "api",
+		Origins:  []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"},
+		Balance:  config.BalanceConsistentHash,
+		HashKey:  "path",
+		MaxFails: 2,
+	})
+
+	pick := func(path string) *Origin {
+		origin, _, err := pool.Pick(httptest.NewRequest("GET", path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return origin
+	}
+
+	// 同一个键总是落到同一个源站
+	first := pick("/users/1")
+	if pick("/users/1") != first {
+		t.Fatal("consistent hash picked different origins for the same key")
+	}
+
+	// 连续失败达到 MaxFails 后被摘除，请求迁移到其他源站
+	for i := 0; i < 2; i++ {
+		pool.Acquire(first)(true, errors.New("boom"))
+	}
+	if pick("/users/1") == first {
+		t.Fatal("ejected origin was picked")
+	}
+
+	for _, origin := range pool.Origins {
+		origin.ejectedUntil = time.Now().Add(time.Hour)
+	}
+	if _, _, err := pool.Pick(httptest.NewRequest("GET", "/", nil)); !errors.Is(err, ErrNoHealthyOrigin) {
+		t.Fatalf("expected ErrNoHealthyOrigin, got %v", err)
+	}
+}
+
+func TestPickStickyCookie(t *testing.T) {
+	pool := newTestPool(t, &config.BackendPool{
+		Name:         "web",
+		Origins:      []string{"10.0.0.1:80", "10.0.0.2:80"},
+		StickyCookie: "overlay_backend",
+	})
+
+	origin, setCookie, err := pool.Pick(httptest.NewRequest("GET", "/", nil))
+	if err != nil || !setCookie {
+		t.Fatalf("expected a new sticky cookie, got %v %v", setCookie, err)
+	}
+
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(pool.StickyCookie(origin))
+		got, setCookie, err := pool.Pick(req)
+		if err != nil || got != origin || setCookie {
+			t.Fatalf("sticky request went to %v (setCookie=%v, err=%v)", got.Addr, setCookie, err)
+		}
+	}
+}
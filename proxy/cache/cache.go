@@ -0,0 +1,539 @@
+// Package cache 实现入口节点的 HTTP 响应缓存：内存层和磁盘层都按 LRU 淘汰，
+// 内存层淘汰的条目降级到磁盘层，磁盘层命中的条目提升回内存层
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hopByHopHeaders 只对单个连接有效的头部，不保存到缓存中
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade", "Age",
+}
+
+// diskSubdir 磁盘层在 DiskDir 下使用的子目录，缓存只读写和清理其中以 diskSuffix 结尾的文件
+const (
+	diskSubdir = "overlay-cache"
+	diskSuffix = ".entry"
+)
+
+// errWriteFailed 磁盘层的条目没有写入成功
+var errWriteFailed = errors.New("cache entry was not written to disk")
+
+// Options 缓存的容量限制
+type Options struct {
+	MemoryBytes   int64  // 内存层容量
+	DiskDir       string // 磁盘层目录，条目保存在其中的 overlay-cache 子目录下，为空表示不启用磁盘层
+	DiskBytes     int64  // 磁盘层容量
+	MaxEntryBytes int64  // 单个响应体的大小上限，超过的响应不缓存
+}
+
+// Stats 缓存的统计信息
+type Stats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Revalidations uint64 `json:"revalidations"` // 过期后向源站确认仍然有效的次数
+	Stores        uint64 `json:"stores"`
+	Evictions     uint64 `json:"evictions"` // 因容量不足被彻底删除的条目数
+	MemoryEntries int    `json:"memory_entries"`
+	MemoryBytes   int64  `json:"memory_bytes"`
+	DiskEntries   int    `json:"disk_entries"`
+	DiskBytes     int64  `json:"disk_bytes"`
+}
+
+// Entry 缓存的一个响应
+type Entry struct {
+	Key        string
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Stored     time.Time     // 存入或最近一次确认有效的时间
+	InitialAge time.Duration // 存入时响应已经存在的时间
+	Lifetime   time.Duration // 新鲜期
+}
+
+// Age 返回条目当前的年龄
+func (e *Entry) Age(now time.Time) time.Duration {
+	return e.InitialAge + now.Sub(e.Stored)
+}
+
+// Fresh 判断条目是否仍在新鲜期内
+func (e *Entry) Fresh(now time.Time) bool {
+	return e.Age(now) < e.Lifetime
+}
+
+// HasValidator 判断条目能否通过条件请求重新验证
+func (e *Entry) HasValidator() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// Response 根据条目构造返回给客户端的响应，并设置 Age 头部
+func (e *Entry) Response(r *http.Request) *http.Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(e.Age(time.Now())/time.Second), 10))
+	return &http.Response{
+		StatusCode:    e.StatusCode,
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       r,
+	}
+}
+
+// size 估算条目占用的空间
+func (e *Entry) size() int64 {
+	size := int64(len(e.Key) + len(e.Body))
+	for name, values := range e.Header {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	return size
+}
+
+// Cache 入口节点的响应缓存。mu 只保护两层的索引，磁盘文件的读写在释放锁之后进行
+type Cache struct {
+	opts Options
+
+	mu     sync.Mutex
+	vary   map[string][]string // 主键 -> 响应的 Vary 头部
+	memory *memoryTier
+	disk   *diskTier
+
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	revalidations atomic.Uint64
+	stores        atomic.Uint64
+	evictions     atomic.Uint64
+}
+
+// New 创建缓存，启用磁盘层时会删除缓存子目录中上次运行留下的条目文件，目录中的其他文件不受影响
+func New(opts Options) (*Cache, error) {
+	c := &Cache{
+		opts:   opts,
+		vary:   make(map[string][]string),
+		memory: newMemoryTier(),
+	}
+	if opts.DiskDir != "" {
+		disk, err := newDiskTier(opts.DiskDir)
+		if err != nil {
+			return nil, err
+		}
+		c.disk = disk
+	}
+	return c, nil
+}
+
+// MaxEntryBytes 返回单个响应体的大小上限
+func (c *Cache) MaxEntryBytes() int64 {
+	return c.opts.MaxEntryBytes
+}
+
+// PrimaryKey 返回请求的缓存主键。只缓存 GET 请求，主键中不包含方法，
+// 这样其他方法的请求也能找到需要失效的条目
+func PrimaryKey(r *http.Request) string {
+	return r.Host + r.URL.RequestURI()
+}
+
+// variantKey 在主键后附加 Vary 头部列出的请求头部的值
+func variantKey(primary string, names []string, r *http.Request) string {
+	if len(names) == 0 {
+		return primary
+	}
+	var b strings.Builder
+	b.WriteString(primary)
+	for _, name := range names {
+		b.WriteString("\x00")
+		b.WriteString(name)
+		b.WriteString("=")
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// Lookup 查找与请求匹配的条目，没有时返回 nil
+func (c *Cache) Lookup(r *http.Request) *Entry {
+	c.mu.Lock()
+	primary := PrimaryKey(r)
+	key := variantKey(primary, c.vary[primary], r)
+	if entry := c.memory.get(key); entry != nil {
+		c.mu.Unlock()
+		return entry
+	}
+	var item *diskItem
+	if c.disk != nil {
+		item = c.disk.get(key)
+	}
+	c.mu.Unlock()
+	if item == nil {
+		return nil
+	}
+
+	// 在锁外读取磁盘文件，读完后把条目提升回内存层；期间条目被删除或替换时不再提升
+	entry, err := item.load()
+	var ops diskOps
+	c.mu.Lock()
+	if c.disk.current(item) {
+		c.disk.remove(key, &ops)
+		if err == nil {
+			c.putLocked(entry, &ops)
+		}
+	}
+	c.mu.Unlock()
+	c.apply(&ops)
+	if err != nil {
+		return nil
+	}
+	return entry
+}
+
+// Store 保存响应，body 为完整的响应体。返回是否保存成功
+func (c *Cache) Store(r *http.Request, resp *http.Response, body []byte) bool {
+	if !ResponseCacheable(r, resp) || int64(len(body)) > c.opts.MaxEntryBytes {
+		return false
+	}
+
+	now := time.Now()
+	header := resp.Header.Clone()
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+
+	var ops diskOps
+	defer c.apply(&ops)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	primary := PrimaryKey(r)
+	names := varyNames(resp.Header)
+	c.vary[primary] = names
+	entry := &Entry{
+		Key:        variantKey(primary, names, r),
+		StatusCode: resp.StatusCode,
+		Header:     header,
+		Body:       body,
+		Stored:     now,
+		InitialAge: initialAge(resp.Header),
+		Lifetime:   FreshnessLifetime(resp.Header, now),
+	}
+	c.putLocked(entry, &ops)
+	c.stores.Add(1)
+	return true
+}
+
+// Revalidate 用源站返回的 304 响应更新条目的头部和新鲜期
+func (c *Cache) Revalidate(entry *Entry, notModified *http.Response) *Entry {
+	now := time.Now()
+	header := entry.Header.Clone()
+	for name, values := range notModified.Header {
+		header[name] = values
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+
+	updated := *entry
+	updated.Header = header
+	updated.Stored = now
+	updated.InitialAge = initialAge(notModified.Header)
+	updated.Lifetime = FreshnessLifetime(header, now)
+
+	var ops diskOps
+	c.mu.Lock()
+	c.putLocked(&updated, &ops)
+	c.mu.Unlock()
+	c.apply(&ops)
+	c.revalidations.Add(1)
+	return &updated
+}
+
+// Remove 删除与请求匹配的条目，用于修改资源的请求成功后使缓存失效
+func (c *Cache) Remove(r *http.Request) {
+	var ops diskOps
+	defer c.apply(&ops)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	primary := PrimaryKey(r)
+	key := variantKey(primary, c.vary[primary], r)
+	c.memory.remove(key)
+	if c.disk != nil {
+		c.disk.remove(key, &ops)
+	}
+}
+
+// RecordHit 记录一次命中
+func (c *Cache) RecordHit() {
+	c.hits.Add(1)
+}
+
+// RecordMiss 记录一次未命中
+func (c *Cache) RecordMiss() {
+	c.misses.Add(1)
+}
+
+// Stats 返回缓存的统计信息
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := Stats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Revalidations: c.revalidations.Load(),
+		Stores:        c.stores.Load(),
+		Evictions:     c.evictions.Load(),
+		MemoryEntries: c.memory.lru.Len(),
+		MemoryBytes:   c.memory.bytes,
+	}
+	if c.disk != nil {
+		stats.DiskEntries = c.disk.lru.Len()
+		stats.DiskBytes = c.disk.bytes
+	}
+	return stats
+}
+
+// putLocked 把条目放入内存层，超出容量时把最久未使用的条目降级到磁盘层，
+// 需要的磁盘操作记录在 ops 中，调用方释放锁后执行
+func (c *Cache) putLocked(entry *Entry, ops *diskOps) {
+	c.memory.remove(entry.Key)
+	if c.disk != nil {
+		c.disk.remove(entry.Key, ops)
+	}
+
+	if entry.size() > c.opts.MemoryBytes {
+		c.demoteLocked(entry, ops)
+		return
+	}
+	c.memory.put(entry)
+	for c.memory.bytes > c.opts.MemoryBytes {
+		c.demoteLocked(c.memory.removeOldest(), ops)
+	}
+}
+
+// demoteLocked 把条目加入磁盘层的索引并等待写入，磁盘层超出容量时删除最久未使用的条目
+func (c *Cache) demoteLocked(entry *Entry, ops *diskOps) {
+	if c.disk == nil || entry.size() > c.opts.DiskBytes {
+		c.evictions.Add(1)
+		return
+	}
+	if err := c.disk.put(entry, ops); err != nil {
+		c.evictions.Add(1)
+		return
+	}
+	for c.disk.bytes > c.opts.DiskBytes {
+		c.disk.removeOldest(ops)
+		c.evictions.Add(1)
+	}
+}
+
+// apply 在锁外执行 ops 中的磁盘操作：先写入新的条目，再删除移出索引的文件。
+// 写入失败的条目从索引中删除，记为淘汰
+func (c *Cache) apply(ops *diskOps) {
+	for _, w := range ops.writes {
+		err := os.WriteFile(w.item.path, w.data, 0644)
+		w.item.failed = err != nil
+		close(w.item.written)
+		if err != nil {
+			c.mu.Lock()
+			if c.disk.current(w.item) {
+				c.disk.drop(w.item)
+				c.evictions.Add(1)
+			}
+			c.mu.Unlock()
+		}
+	}
+	for _, item := range ops.removes {
+		// 文件可能还在由其他调用方写入，写完后再删除，避免留下无人管理的文件
+		<-item.written
+		os.Remove(item.path)
+	}
+}
+
+// memoryTier 内存层，按 LRU 淘汰
+type memoryTier struct {
+	lru   *list.List // 最近使用的在前
+	items map[string]*list.Element
+	bytes int64
+}
+
+func newMemoryTier() *memoryTier {
+	return &memoryTier{lru: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (t *memoryTier) get(key string) *Entry {
+	elem, exists := t.items[key]
+	if !exists {
+		return nil
+	}
+	t.lru.MoveToFront(elem)
+	return elem.Value.(*Entry)
+}
+
+func (t *memoryTier) put(entry *Entry) {
+	t.items[entry.Key] = t.lru.PushFront(entry)
+	t.bytes += entry.size()
+}
+
+func (t *memoryTier) remove(key string) {
+	if elem, exists := t.items[key]; exists {
+		t.lru.Remove(elem)
+		delete(t.items, key)
+		t.bytes -= elem.Value.(*Entry).size()
+	}
+}
+
+func (t *memoryTier) removeOldest() *Entry {
+	entry := t.lru.Back().Value.(*Entry)
+	t.remove(entry.Key)
+	return entry
+}
+
+// diskItem 磁盘层索引中的一项。每次写入使用新的文件名，同一个键的新旧文件互不影响
+type diskItem struct {
+	key     string
+	path    string
+	size    int64
+	written chan struct{} // 文件写入结束（无论成功与否）后关闭
+	failed  bool          // 写入失败，在 written 关闭后读取
+}
+
+// load 等待文件写入结束后读取条目
+func (item *diskItem) load() (*Entry, error) {
+	<-item.written
+	if item.failed {
+		return nil, errWriteFailed
+	}
+	file, err := os.Open(item.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entry Entry
+	if err := gob.NewDecoder(file).Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// diskWrite 等待在锁外写入的文件
+type diskWrite struct {
+	item *diskItem
+	data []byte
+}
+
+// diskOps 持有锁期间记录的磁盘操作，由 Cache.apply 在释放锁之后执行
+type diskOps struct {
+	writes  []diskWrite
+	removes []*diskItem
+}
+
+// diskTier 磁盘层，每个条目一个 gob 文件，索引保存在内存中。方法只修改索引，文件的读写由调用方在锁外进行
+type diskTier struct {
+	dir   string
+	lru   *list.List
+	items map[string]*list.Element
+	bytes int64
+	seq   uint64 // 文件名序号
+}
+
+// newDiskTier 在 dir 下创建缓存子目录，并删除其中上次运行留下的条目文件
+func newDiskTier(dir string) (*diskTier, error) {
+	dir = filepath.Join(dir, diskSubdir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache dir %s: %w", dir, err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache dir %s: %w", dir, err)
+	}
+	for _, file := range files {
+		if file.Type().IsRegular() && strings.HasSuffix(file.Name(), diskSuffix) {
+			if err := os.Remove(filepath.Join(dir, file.Name())); err != nil {
+				return nil, fmt.Errorf("failed to clear cache dir %s: %w", dir, err)
+			}
+		}
+	}
+	return &diskTier{dir: dir, lru: list.New(), items: make(map[string]*list.Element)}, nil
+}
+
+// get 返回键对应的索引项，不存在时返回 nil
+func (t *diskTier) get(key string) *diskItem {
+	elem, exists := t.items[key]
+	if !exists {
+		return nil
+	}
+	t.lru.MoveToFront(elem)
+	return elem.Value.(*diskItem)
+}
+
+// current 判断索引项是否仍在索引中，没有被删除或者被同一个键的新条目替换
+func (t *diskTier) current(item *diskItem) bool {
+	if t == nil {
+		return false
+	}
+	elem, exists := t.items[item.key]
+	return exists && elem.Value.(*diskItem) == item
+}
+
+// put 编码条目并加入索引，文件的写入记录在 ops 中
+func (t *diskTier) put(entry *Entry, ops *diskOps) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256([]byte(entry.Key))
+	t.seq++
+	name := hex.EncodeToString(sum[:]) + "-" + strconv.FormatUint(t.seq, 10) + diskSuffix
+	item := &diskItem{
+		key:     entry.Key,
+		path:    filepath.Join(t.dir, name),
+		size:    int64(buf.Len()),
+		written: make(chan struct{}),
+	}
+	t.items[entry.Key] = t.lru.PushFront(item)
+	t.bytes += item.size
+	ops.writes = append(ops.writes, diskWrite{item: item, data: buf.Bytes()})
+	return nil
+}
+
+// remove 把键移出索引，文件的删除记录在 ops 中
+func (t *diskTier) remove(key string, ops *diskOps) {
+	if elem, exists := t.items[key]; exists {
+		item := elem.Value.(*diskItem)
+		t.drop(item)
+		ops.removes = append(ops.removes, item)
+	}
+}
+
+// drop 把索引项移出索引，不删除文件
+func (t *diskTier) drop(item *diskItem) {
+	t.lru.Remove(t.items[item.key])
+	delete(t.items, item.key)
+	t.bytes -= item.size
+}
+
+func (t *diskTier) removeOldest(ops *diskOps) {
+	t.remove(t.lru.Back().Value.(*diskItem).key, ops)
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 测试新鲜期的计算顺序：s-maxage、max-age、Expires
+func TestFreshnessLifetime(t *testing.T) {
+	now := time.Now()
+	header := http.Header{}
+	header.Set("Date", now.UTC().Format(http.TimeFormat))
+	header.Set("Expires", now.Add(time.Hour).UTC().Format(http.TimeFormat))
+	if got := FreshnessLifetime(header, now); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("Expires 新鲜期不正确: %v", got)
+	}
+
+	header.Set("Cache-Control", "public, max-age=60")
+	if got := FreshnessLifetime(header, now); got != time.Minute {
+		t.Errorf("max-age 新鲜期不正确: %v", got)
+	}
+
+	header.Set("Cache-Control", "max-age=60, s-maxage=10")
+	if got := FreshnessLifetime(header, now); got != 10*time.Second {
+		t.Errorf("s-maxage 新鲜期不正确: %v", got)
+	}
+}
+
+// 测试 Vary 区分变体，以及内存层淘汰的条目降级到磁盘层后仍能命中
+func TestCacheVaryAndDiskTier(t *testing.T) {
+	c, err := New(Options{MemoryBytes: 100, DiskDir: t.TempDir(), DiskBytes: 1 << 20, MaxEntryBytes: 1 << 10})
+	if err != nil {
+		t.Fatalf("创建缓存失败: %v", err)
+	}
+
+	request := func(lang string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/a", nil)
+		r.Header.Set("Accept-Language", lang)
+		return r
+	}
+	store := func(lang string) {
+		resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+		resp.Header.Set("Cache-Control", "max-age=60")
+		resp.Header.Set("Vary", "Accept-Language")
+		if !c.Store(request(lang), resp, []byte("body-"+lang)) {
+			t.Fatalf("保存 %s 失败", lang)
+		}
+	}
+	store("en")
+	store("fr")
+
+	for _, lang := range []string{"en", "fr"} {
+		entry := c.Lookup(request(lang))
+		if entry == nil {
+			t.Fatalf("%s 未命中", lang)
+		}
+		if string(entry.Body) != "body-"+lang {
+			t.Errorf("%s 命中了错误的变体: %s", lang, entry.Body)
+		}
+	}
+	if c.Lookup(request("de")) != nil {
+		t.Errorf("没有保存的变体不应命中")
+	}
+
+	stats := c.Stats()
+	if stats.DiskEntries == 0 {
+		t.Errorf("内存层容量不足时应降级到磁盘层: %+v", stats)
+	}
+}
+
+// 测试磁盘层只清理自己子目录中的条目文件，不删除磁盘目录中的其他文件
+func TestDiskTierKeepsForeignFiles(t *testing.T) {
+	dir := t.TempDir()
+	foreign := filepath.Join(dir, "keep.txt")
+	if err := os.WriteFile(foreign, []byte("operator data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, diskSubdir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	stale := filepath.Join(dir, diskSubdir, "old"+diskSuffix)
+	notes := filepath.Join(dir, diskSubdir, "notes.txt")
+	for _, path := range []string{stale, notes} {
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := New(Options{MemoryBytes: 100, DiskDir: dir, DiskBytes: 1 << 20, MaxEntryBytes: 1 << 10}); err != nil {
+		t.Fatalf("创建缓存失败: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("上次运行留下的条目文件应被删除: %v", err)
+	}
+	for _, path := range []string{foreign, notes} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("不属于缓存的文件 %s 不应被删除: %v", path, err)
+		}
+	}
+}
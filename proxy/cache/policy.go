@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// heuristicFraction 响应没有明确过期时间但带有 Last-Modified 时，按 (Date - Last-Modified) 的 10% 估算新鲜期
+const heuristicFraction = 10
+
+// maxHeuristic 启发式新鲜期的上限
+const maxHeuristic = 24 * time.Hour
+
+// cacheableStatus 默认可以缓存的状态码（RFC 9111 4.2.2）
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+	http.StatusPermanentRedirect:    true,
+}
+
+// CacheControl 解析后的 Cache-Control 指令，指令名统一为小写
+type CacheControl map[string]string
+
+// ParseCacheControl 解析 Cache-Control 头部，多个头部合并处理
+func ParseCacheControl(header http.Header) CacheControl {
+	cc := CacheControl{}
+	for _, line := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(line, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(directive, "=")
+			cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return cc
+}
+
+// Has 判断是否包含某个指令
+func (cc CacheControl) Has(name string) bool {
+	_, exists := cc[name]
+	return exists
+}
+
+// Seconds 返回以秒为单位的指令值，例如 max-age
+func (cc CacheControl) Seconds(name string) (time.Duration, bool) {
+	value, exists := cc[name]
+	if !exists {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// RequestCacheable 判断请求能否使用缓存，只缓存不带 no-store 的 GET 请求
+func RequestCacheable(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	return !ParseCacheControl(r.Header).Has("no-store")
+}
+
+// RequestNeedsRevalidation 请求带有 no-cache 或 max-age=0 时，即使缓存新鲜也要先向源站确认
+func RequestNeedsRevalidation(r *http.Request) bool {
+	cc := ParseCacheControl(r.Header)
+	if cc.Has("no-cache") {
+		return true
+	}
+	if maxAge, ok := cc.Seconds("max-age"); ok && maxAge == 0 {
+		return true
+	}
+	return r.Header.Get("Pragma") == "no-cache"
+}
+
+// ResponseCacheable 判断共享缓存能否保存该响应
+func ResponseCacheable(r *http.Request, resp *http.Response) bool {
+	if !cacheableStatus[resp.StatusCode] {
+		return false
+	}
+	cc := ParseCacheControl(resp.Header)
+	if cc.Has("no-store") || cc.Has("private") {
+		return false
+	}
+	// 带 Set-Cookie 的响应通常与具体用户相关
+	if resp.Header.Get("Set-Cookie") != "" {
+		return false
+	}
+	for _, name := range varyNames(resp.Header) {
+		if name == "*" {
+			return false
+		}
+	}
+	// 带认证的请求只有在源站明确允许时才能被共享缓存保存
+	if r.Header.Get("Authorization") != "" && !cc.Has("public") && !cc.Has("s-maxage") && !cc.Has("must-revalidate") {
+		return false
+	}
+	// 需要有明确的过期时间或者校验器，否则无法判断何时失效
+	_, hasMaxAge := cc.Seconds("max-age")
+	_, hasSMaxAge := cc.Seconds("s-maxage")
+	return hasMaxAge || hasSMaxAge || resp.Header.Get("Expires") != "" || cc.Has("public") ||
+		resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+// FreshnessLifetime 计算响应的新鲜期，优先级为 s-maxage、max-age、Expires、启发式
+func FreshnessLifetime(header http.Header, now time.Time) time.Duration {
+	cc := ParseCacheControl(header)
+	if cc.Has("no-cache") {
+		return 0
+	}
+	if sMaxAge, ok := cc.Seconds("s-maxage"); ok {
+		return sMaxAge
+	}
+	if maxAge, ok := cc.Seconds("max-age"); ok {
+		return maxAge
+	}
+
+	date := parseHTTPDate(header.Get("Date"), now)
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil || !t.After(date) {
+			return 0
+		}
+		return t.Sub(date)
+	}
+	if lastModified := header.Get("Last-Modified"); lastModified != "" {
+		t, err := http.ParseTime(lastModified)
+		if err != nil || !t.Before(date) {
+			return 0
+		}
+		heuristic := date.Sub(t) / heuristicFraction
+		if heuristic > maxHeuristic {
+			heuristic = maxHeuristic
+		}
+		return heuristic
+	}
+	return 0
+}
+
+// initialAge 响应进入缓存时已经存在的时间，取 Age 头部
+func initialAge(header http.Header) time.Duration {
+	age, err := strconv.ParseInt(header.Get("Age"), 10, 64)
+	if err != nil || age < 0 {
+		return 0
+	}
+	return time.Duration(age) * time.Second
+}
+
+// varyNames 返回响应 Vary 头部列出的请求头部，名称已规范化
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, line := range header.Values("Vary") {
+		for _, name := range strings.Split(line, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// parseHTTPDate 解析 HTTP 日期，失败时返回 fallback
+func parseHTTPDate(value string, fallback time.Time) time.Time {
+	if value == "" {
+		return fallback
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return fallback
+	}
+	return t
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// AccessRule 授权规则：允许某个身份访问的目的主机和路由
+type AccessRule struct {
+	Identity string   `json:"identity"` // 客户端身份，"*" 表示任意已认证身份
+	Hosts    []string `json:"hosts"`    // 允许访问的目的主机，支持 "*" 和 "*.example.com"，为空表示不限制
+	Routes   []string `json:"routes"`   // 允许使用的路由，为空表示不限制
+}
+
+// AuthConfig 入口认证和授权配置
+type AuthConfig struct {
+	Required     bool              `json:"required"`       // 为 true 时拒绝未认证的请求
+	APIKeyHeader string            `json:"api_key_header"` // 携带 API Key 的头部，默认 X-API-Key
+	APIKeys      map[string]string `json:"api_keys"`       // API Key -> 身份
+	HMACSecret   string            `json:"hmac_secret"`    // HMAC 签名令牌的密钥，为空表示不启用
+	TLSCert      string            `json:"tls_cert"`       // 入口 HTTPS 证书
+	TLSKey       string            `json:"tls_key"`        // 入口 HTTPS 私钥
+	ClientCA     string            `json:"client_ca"`      // 校验客户端证书的 CA，为空表示不启用 mTLS
+	Rules        []*AccessRule     `json:"rules"`          // 授权规则，为空表示已认证身份可访问任意目的地
+}
+
+// authFile 认证配置文件的 JSON 格式，可以与路由表写在同一个文件中
+type authFile struct {
+	Auth *AuthConfig `json:"auth"`
+}
+
+// LoadAuthConfig 从 JSON 文件加载认证配置，文件中没有 auth 段时返回 nil
+func LoadAuthConfig(filePath string) (*AuthConfig, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var file authFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse auth config %s: %w", filePath, err)
+	}
+	if file.Auth != nil {
+		if err := file.Auth.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid auth config %s: %w", filePath, err)
+		}
+	}
+	return file.Auth, nil
+}
+
+// Validate 检查证书配置。客户端 CA 只在入口使用 HTTPS 时生效，
+// 只配置 client_ca 会让入口以明文 HTTP 监听，mTLS 被悄悄关闭，因此视为错误
+func (c *AuthConfig) Validate() error {
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	if c.ClientCA != "" && c.TLSCert == "" {
+		return fmt.Errorf("client_ca requires tls_cert and tls_key")
+	}
+	return nil
+}
+
+// ServerTLSConfig 根据证书配置生成入口 HTTPS 的 TLS 配置，未配置证书时返回 nil。
+// 配置了客户端 CA 时会校验客户端证书，但不强制要求客户端提供证书
+func (c *AuthConfig) ServerTLSConfig() (*tls.Config, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if c.TLSCert == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if c.ClientCA != "" {
+		pem, err := os.ReadFile(c.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", c.ClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
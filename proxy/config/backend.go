@@ -0,0 +1,125 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 后端池的负载均衡算法
+const (
+	BalanceRoundRobin     = "round_robin"     // 轮询
+	BalanceLeastConn      = "least_conn"      // 选择活跃连接最少的源站
+	BalanceConsistentHash = "consistent_hash" // 按 HashKey 一致性哈希
+)
+
+// 健康检查方式
+const (
+	HealthCheckHTTP = "http" // 请求 Path，2xx 和 3xx 视为健康
+	HealthCheckTCP  = "tcp"  // 能建立 TCP 连接视为健康
+)
+
+// HealthCheck 源站的主动健康检查
+type HealthCheck struct {
+	Type               string `json:"type"`                // http 或 tcp
+	Path               string `json:"path"`                // HTTP 检查的路径，默认 "/"
+	IntervalMs         int    `json:"interval_ms"`         // 检查间隔，默认 5000
+	TimeoutMs          int    `json:"timeout_ms"`          // 单次检查超时，默认 2000
+	UnhealthyThreshold int    `json:"unhealthy_threshold"` // 连续失败多少次后摘除，默认 3
+	HealthyThreshold   int    `json:"healthy_threshold"`   // 摘除后连续成功多少次恢复，默认 2
+}
+
+// BackendPool 出口节点后面的一组源站，按请求的目的主机选择
+type BackendPool struct {
+	Name    string   `json:"name"`
+	Hosts   []string `json:"hosts"`   // 使用该池的目的主机，支持 "*" 和 "*.example.com"
+	Origins []string `json:"origins"` // 源站地址 host:port
+	Scheme  string   `json:"scheme"`  // 访问源站的协议，默认 http
+	Balance string   `json:"balance"` // 负载均衡算法，默认 round_robin
+	// HashKey 一致性哈希的键：client_ip（默认）、path、header:<名称> 或 cookie:<名称>
+	HashKey      string       `json:"hash_key"`
+	StickyCookie string       `json:"sticky_cookie"` // 会话保持使用的 Cookie 名称，为空表示不启用
+	HealthCheck  *HealthCheck `json:"health_check"`  // 为空表示不做主动健康检查
+	MaxFails     int          `json:"max_fails"`     // 请求连续失败多少次后被动摘除，0 表示不启用
+	EjectMs      int          `json:"eject_ms"`      // 被动摘除的时长，默认 30000
+}
+
+// backendFile 后端池配置文件的 JSON 格式，可以与路由表写在同一个文件中
+type backendFile struct {
+	Backends []*BackendPool `json:"backends"`
+}
+
+// Validate 检查后端池配置并补全默认值
+func (p *BackendPool) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("backend pool name is required")
+	}
+	if len(p.Origins) == 0 {
+		return fmt.Errorf("backend pool %s has no origins", p.Name)
+	}
+	if p.Scheme == "" {
+		p.Scheme = "http"
+	}
+	if p.Balance == "" {
+		p.Balance = BalanceRoundRobin
+	}
+	switch p.Balance {
+	case BalanceRoundRobin, BalanceLeastConn, BalanceConsistentHash:
+	default:
+		return fmt.Errorf("backend pool %s: invalid balance %q", p.Name, p.Balance)
+	}
+	if p.HashKey == "" {
+		p.HashKey = "client_ip"
+	}
+	if p.HashKey != "client_ip" && p.HashKey != "path" &&
+		!strings.HasPrefix(p.HashKey, "header:") && !strings.HasPrefix(p.HashKey, "cookie:") {
+		return fmt.Errorf("backend pool %s: invalid hash key %q", p.Name, p.HashKey)
+	}
+	if p.EjectMs <= 0 {
+		p.EjectMs = 30000
+	}
+
+	if check := p.HealthCheck; check != nil {
+		switch check.Type {
+		case HealthCheckHTTP, HealthCheckTCP:
+		default:
+			return fmt.Errorf("backend pool %s: invalid health check type %q", p.Name, check.Type)
+		}
+		if check.Path == "" {
+			check.Path = "/"
+		}
+		if check.IntervalMs <= 0 {
+			check.IntervalMs = 5000
+		}
+		if check.TimeoutMs <= 0 {
+			check.TimeoutMs = 2000
+		}
+		if check.UnhealthyThreshold <= 0 {
+			check.UnhealthyThreshold = 3
+		}
+		if check.HealthyThreshold <= 0 {
+			check.HealthyThreshold = 2
+		}
+	}
+	return nil
+}
+
+// LoadBackendPools 从 JSON 文件加载出口节点的后端池
+func LoadBackendPools(filePath string) ([]*BackendPool, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var file backendFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse backend pools %s: %w", filePath, err)
+	}
+	for _, pool := range file.Backends {
+		if err := pool.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return file.Backends, nil
+}
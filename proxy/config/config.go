@@ -4,9 +4,18 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
+	"sync/atomic"
+	"time"
 )
 
+// FixedHeaderLen 包头中固定大小字段的总长度（字节）
+const FixedHeaderLen = 19
+
+// DefaultTTL 新数据包的跳数限制，每经过一个中继减一
+const DefaultTTL = 16
+
 type Packet struct {
 	Length      uint16   // 完整数据包长度
 	HeaderLen   uint16   // 自定义包头信息长度
@@ -16,14 +25,36 @@ type Packet struct {
 	Property    uint16   // 流的时延或带宽需求
 	Priority    uint8    // 优先级
 	HopCounts   uint8    // 当前在第几跳
+	TTL         uint8    // 剩余跳数，中继每转发一次减一，收到 0 的数据包直接丢弃
 	PacketCount uint8    // 合并的请求数量
 	Offsets     []uint8  // 每个请求的偏移量
 	Padding     []uint8  // 填充
 	HopList     []uint32 // 完整转发路径 (每个 IP 地址以 uint32 表示)
 }
 
-// 将字符串形式的 IP 转换为 uint32
-func ipToUint32(ip string) (uint32, error) {
+// packetIDSeq 用于生成本节点内唯一的 PacketID
+var packetIDSeq uint32
+
+// NewPacket 根据转发路径创建一个新的数据包头，HeaderLen 按实际写入的字段计算
+func NewPacket(hopList []uint32) *Packet {
+	packet := &Packet{
+		Timestamp:  uint32(time.Now().Unix()),
+		PacketID:   atomic.AddUint32(&packetIDSeq, 1),
+		PacketType: 1,
+		HopCounts:  uint8(len(hopList)),
+		TTL:        DefaultTTL,
+		HopList:    hopList,
+	}
+	if len(hopList) > DefaultTTL {
+		packet.TTL = uint8(len(hopList))
+	}
+	packet.HeaderLen = uint16(FixedHeaderLen + len(packet.Offsets) + len(packet.Padding))
+	packet.Length = packet.HeaderLen + uint16(4*len(hopList))
+	return packet
+}
+
+// IPToUint32 将字符串形式的 IP 转换为 uint32
+func IPToUint32(ip string) (uint32, error) {
 	parsedIP := net.ParseIP(ip).To4()
 	if parsedIP == nil {
 		return 0, fmt.Errorf("无效的 IP 地址: %s", ip)
@@ -31,8 +62,17 @@ func ipToUint32(ip string) (uint32, error) {
 	return binary.BigEndian.Uint32(parsedIP), nil
 }
 
-// 将 uint32 转换为字符串形式的 IP
-func uint32ToIP(ipUint uint32) string {
+// HopIPs 返回字符串形式的完整转发路径
+func (p *Packet) HopIPs() []string {
+	hops := make([]string, len(p.HopList))
+	for i, hop := range p.HopList {
+		hops[i] = Uint32ToIP(hop)
+	}
+	return hops
+}
+
+// Uint32ToIP 将 uint32 转换为字符串形式的 IP
+func Uint32ToIP(ipUint uint32) string {
 	return fmt.Sprintf("%d.%d.%d.%d",
 		byte(ipUint>>24),
 		byte(ipUint>>16),
@@ -84,6 +124,11 @@ func SerializePacket(packet *Packet) ([]byte, error) {
 		return nil, err
 	}
 
+	err = binary.Write(buffer, binary.BigEndian, packet.TTL)
+	if err != nil {
+		return nil, err
+	}
+
 	err = binary.Write(buffer, binary.BigEndian, packet.PacketCount)
 	if err != nil {
 		return nil, err
@@ -116,8 +161,11 @@ func SerializePacket(packet *Packet) ([]byte, error) {
 	return buffer.Bytes(), nil
 }
 func DeserializePacket(data []byte) (*Packet, error) {
-	buffer := bytes.NewReader(data)
+	return ReadPacket(bytes.NewReader(data))
+}
 
+// ReadPacket 从数据流中读取一个完整的包头，读取结束后 reader 恰好停在包头之后
+func ReadPacket(buffer io.Reader) (*Packet, error) {
 	packet := &Packet{}
 
 	// 解析固定大小的字段
@@ -161,14 +209,15 @@ func DeserializePacket(data []byte) (*Packet, error) {
 		return nil, err
 	}
 
-	err = binary.Read(buffer, binary.BigEndian, &packet.PacketCount)
+	err = binary.Read(buffer, binary.BigEndian, &packet.TTL)
 	if err != nil {
 		return nil, err
 	}
 
-	// 打印调试信息
-	fmt.Printf("反序列化 HeaderLen: %d, Length: %d, HopCounts: %d, PacketCount: %d\n",
-		packet.HeaderLen, packet.Length, packet.HopCounts, packet.PacketCount)
+	err = binary.Read(buffer, binary.BigEndian, &packet.PacketCount)
+	if err != nil {
+		return nil, err
+	}
 
 	// 解析 Offsets
 	packet.Offsets = make([]uint8, packet.PacketCount)
@@ -182,16 +231,14 @@ func DeserializePacket(data []byte) (*Packet, error) {
 	}
 
 	// 计算 Padding 长度
-	fixedFieldSize := 12 + int(packet.PacketCount) // 固定字段 + Offsets 长度
+	fixedFieldSize := FixedHeaderLen + int(packet.PacketCount) // 固定字段 + Offsets 长度
 	paddingLength := int(packet.HeaderLen) - fixedFieldSize
 	if paddingLength > 0 {
 		packet.Padding = make([]uint8, paddingLength)
 		err := binary.Read(buffer, binary.BigEndian, packet.Padding)
 		if err != nil {
-			fmt.Printf("读取 Padding 时出错: %v\n", err)
 			return nil, err
 		}
-		fmt.Printf("反序列化 Padding: %v\n", packet.Padding)
 	}
 
 	// 解析 HopList
@@ -200,10 +247,8 @@ func DeserializePacket(data []byte) (*Packet, error) {
 		var hop uint32
 		err := binary.Read(buffer, binary.BigEndian, &hop)
 		if err != nil {
-			fmt.Printf("读取 HopList[%d] 时出错: %v\n", i, err)
 			return nil, err
 		}
-		fmt.Printf("反序列化 HopList[%d]: %d\n", i, hop)
 		packet.HopList[i] = hop
 	}
 
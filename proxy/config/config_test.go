@@ -10,13 +10,14 @@ func TestSerializeAndDeserializePacket(t *testing.T) {
 	// 创建一个示例 Packet 对象
 	originalPacket := &Packet{
 		Length:      64,
-		HeaderLen:   20,
+		HeaderLen:   24,
 		Timestamp:   1672531200,
 		PacketID:    12345678,
 		PacketType:  1,
 		Property:    256,
 		Priority:    5,
 		HopCounts:   2,
+		TTL:         16,
 		PacketCount: 1,
 		Offsets:     []uint8{10},
 		Padding:     []uint8{0, 0, 0, 0},
@@ -60,6 +61,9 @@ func TestSerializeAndDeserializePacket(t *testing.T) {
 	if originalPacket.HopCounts != deserializedPacket.HopCounts {
 		t.Errorf("HopCounts 不匹配: 原始值=%d, 反序列化值=%d", originalPacket.HopCounts, deserializedPacket.HopCounts)
 	}
+	if originalPacket.TTL != deserializedPacket.TTL {
+		t.Errorf("TTL 不匹配: 原始值=%d, 反序列化值=%d", originalPacket.TTL, deserializedPacket.TTL)
+	}
 	if originalPacket.PacketCount != deserializedPacket.PacketCount {
 		t.Errorf("PacketCount 不匹配: 原始值=%d, 反序列化值=%d", originalPacket.PacketCount, deserializedPacket.PacketCount)
 	}
@@ -82,3 +86,72 @@ func TestSerializeAndDeserializePacket(t *testing.T) {
 		}
 	}
 }
+
+func TestAuthConfigValidate(t *testing.T) {
+	// 没有入口证书时配置客户端 CA 会以明文监听，必须报错而不是悄悄关闭 mTLS
+	if err := (&AuthConfig{ClientCA: "ca.pem"}).Validate(); err == nil {
+		t.Error("expected client_ca without tls_cert to be rejected")
+	}
+	if _, err := (&AuthConfig{ClientCA: "ca.pem"}).ServerTLSConfig(); err == nil {
+		t.Error("expected ServerTLSConfig to fail for client_ca without tls_cert")
+	}
+	if err := (&AuthConfig{TLSCert: "cert.pem"}).Validate(); err == nil {
+		t.Error("expected tls_cert without tls_key to be rejected")
+	}
+	if err := (&AuthConfig{TLSCert: "cert.pem", TLSKey: "key.pem", ClientCA: "ca.pem"}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNodeConfigValidatePoolLimits(t *testing.T) {
+	cfg := DefaultNodeConfig()
+	cfg.Node.Roles = []string{RoleIngress}
+	cfg.Relay.Stripe.PeerLanes = map[string]int{"10.0.0.2": 4}
+	cfg.Pool.MaxActive = 4
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("limit covering every lane rejected: %v", err)
+	}
+
+	// 每个条带化会话需要同时取得所有通道的连接
+	cfg.Pool.MaxActive = 3
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected pool.max_active below the lane count to be rejected")
+	}
+	cfg.Pool.MaxActive, cfg.Pool.MaxTotal = 0, 2
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected pool.max_total below the lane count to be rejected")
+	}
+}
+
+func TestNodeConfigValidateTunnelToken(t *testing.T) {
+	cfg := DefaultNodeConfig()
+	cfg.Node.Roles, cfg.Node.IP = []string{RoleRelay}, "10.0.0.1"
+	cfg.Relay.Tunnel.Accept = true
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected relay.tunnel.accept without a token to be rejected")
+	}
+	cfg.Relay.Tunnel.Token = "secret"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("accept with a token rejected: %v", err)
+	}
+}
+
+func TestNodeConfigCacheOverrides(t *testing.T) {
+	cfg := DefaultNodeConfig()
+	env := map[string]string{
+		EnvName("cache.enabled"):      "true",
+		EnvName("cache.memory_bytes"): "1048576",
+	}
+	if err := cfg.ApplyEnv(func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Set("cache.disk_dir", "/var/cache/overlay"); err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Cache.Enabled || cfg.Cache.MemoryBytes != 1<<20 || cfg.Cache.DiskDir != "/var/cache/overlay" {
+		t.Errorf("cache overrides not applied: %+v", cfg.Cache)
+	}
+}
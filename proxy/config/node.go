@@ -0,0 +1,523 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 节点角色
+const (
+	RoleIngress = "ingress" // 入口：接收客户端 HTTP 请求并选择转发路径
+	RoleRelay   = "relay"   // 中继：接收上游节点的 SMUX 连接并转发给下一跳
+	RoleEgress  = "egress"  // 出口：作为路径的最后一跳访问目标服务器
+	RoleProbe   = "probe"   // 探测代理：执行控制器下发的 TCP 探测任务并上报结果
+	RoleInfo    = "info"    // 信息代理：定期上报主机信息
+)
+
+// EnvPrefix 覆盖配置项的环境变量前缀，例如 OVERLAY_INGRESS_LISTEN 覆盖 ingress.listen
+const EnvPrefix = "OVERLAY_"
+
+// NodeConfig 节点配置，所有角色共用一个 YAML 文件
+type NodeConfig struct {
+	Node       NodeSection       `yaml:"node"`
+	Controller ControllerSection `yaml:"controller"`
+	Ingress    IngressSection    `yaml:"ingress"`
+	Relay      RelaySection      `yaml:"relay"`
+	Pool       PoolSection       `yaml:"pool"`
+	Probe      ProbeSection      `yaml:"probe"`
+	Info       InfoSection       `yaml:"info"`
+	API        APISection        `yaml:"api"`
+	Cache      CacheSection      `yaml:"cache"`
+	AccessLog  AccessLogSection  `yaml:"access_log"`
+	Tracing    TracingSection    `yaml:"tracing"`
+}
+
+// NodeSection 节点身份和启用的角色
+type NodeSection struct {
+	ID    string   `yaml:"id"`    // 节点 ID，默认使用主机名
+	IP    string   `yaml:"ip"`    // 本节点在转发路径中的 IP
+	Roles []string `yaml:"roles"` // 启用的角色
+}
+
+// ControllerSection 控制器上报地址
+type ControllerSection struct {
+	InfoURL  string `yaml:"info_url"`  // 主机信息上报地址
+	ProbeURL string `yaml:"probe_url"` // 探测结果上报地址
+}
+
+// IngressSection 入口配置
+type IngressSection struct {
+	Listen       string        `yaml:"listen"`        // 客户端 HTTP 监听地址
+	RoutesFile   string        `yaml:"routes_file"`   // 路由表和认证配置（JSON）
+	DrainTimeout time.Duration `yaml:"drain_timeout"` // 关闭时等待进行中请求的最长时间
+	// TrustedProxies 可信的上游代理（IP 或 CIDR，"*" 表示全部），只保留来自这些地址的
+	// Forwarded 和 X-Forwarded-* 头部，其他客户端带来的转发头部会被删除
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// Direct 没有路由的请求是否由入口直接访问目的主机，关闭时返回 502
+	Direct bool `yaml:"direct"`
+	// RateLimits 入口限流规则，每个请求依次匹配所有规则，只能在配置文件中设置
+	RateLimits []*RateLimitRule `yaml:"rate_limits"`
+}
+
+// RelaySection 中继和出口配置，两个角色共用同一个 SMUX 监听端口
+type RelaySection struct {
+	Listen         string        `yaml:"listen"`          // 代理节点监听地址
+	DialTimeout    time.Duration `yaml:"dial_timeout"`    // 连接下一跳的超时时间
+	RequestTimeout time.Duration `yaml:"request_timeout"` // 等待下一跳响应头的超时时间
+	DrainTimeout   time.Duration `yaml:"drain_timeout"`   // 关闭时等待进行中流的最长时间
+	// 到每个下一跳保持的 SMUX 会话数，新的流打开在流数最少的会话上
+	SessionsPerHop int `yaml:"sessions_per_hop"`
+	// 每个会话上同时打开的流数上限，所有会话都达到上限时等待，0 表示不限
+	MaxStreamsPerSession int `yaml:"max_streams_per_session"`
+	// SMUX 与所有对端节点之间会话的参数
+	SMUX SMUXSection `yaml:"smux"`
+	// PeerSMUX 按对端节点 IP 覆盖 SMUX 参数，只能在配置文件中设置
+	PeerSMUX map[string]SMUXSection `yaml:"peer_smux"`
+	// Stripe 把到下一跳的 SMUX 会话分散到多条 TCP 连接上并行发送
+	Stripe StripeSection `yaml:"stripe"`
+	// Tunnel 反向隧道，用于位于 NAT 后面、无法接受入向连接的节点
+	Tunnel TunnelSection `yaml:"tunnel"`
+}
+
+// TunnelSection 反向隧道：NAT 后面的节点主动连接会合中继并注册自己的节点 ID 和 IP，
+// 会合中继在转发路径的下一跳为该节点时通过这条连接打开流。断开后自动重新连接
+type TunnelSection struct {
+	Rendezvous string        `yaml:"rendezvous"`  // 会合中继的地址，填写后本节点主动连接并注册
+	Accept     bool          `yaml:"accept"`      // 作为会合中继，接受其他节点的注册
+	Token      string        `yaml:"token"`       // 注册时校验的共享密钥，两端必须一致，accept 为真时必须设置
+	MaxBackoff time.Duration `yaml:"max_backoff"` // 重新连接的最长等待时间
+}
+
+// StripeSection 链路条带化：会话的数据切分为带序号的数据块，由多条 TCP 连接（通道）并行发送，
+// 对端按序号重新排列。接收端总是支持条带化，发送端按通道数决定是否使用
+type StripeSection struct {
+	Lanes         int            `yaml:"lanes"`          // 到每个下一跳的通道数，1 表示不使用条带化，最多 16
+	ChunkSize     int            `yaml:"chunk_size"`     // 数据块大小，字节
+	ReorderBuffer int            `yaml:"reorder_buffer"` // 接收端等待重排的数据上限，字节
+	PeerLanes     map[string]int `yaml:"peer_lanes"`     // 按对端节点 IP 设置通道数，只能在配置文件中设置
+}
+
+// SMUXSection SMUX 会话的流控和保活参数，0 表示使用 SMUX 的默认值
+type SMUXSection struct {
+	Version           int           `yaml:"version"`            // 协议版本 1 或 2，链路两端必须一致；版本 2 才按流控制窗口
+	MaxReceiveBuffer  int           `yaml:"max_receive_buffer"` // 整个会话的接收缓冲区，字节
+	MaxStreamBuffer   int           `yaml:"max_stream_buffer"`  // 单个流的接收窗口，字节
+	MaxFrameSize      int           `yaml:"max_frame_size"`     // 单个数据帧的大小上限，不超过 65535
+	KeepAliveInterval time.Duration `yaml:"keepalive_interval"` // 发送保活帧的间隔
+	KeepAliveTimeout  time.Duration `yaml:"keepalive_timeout"`  // 超过该时长没有收到数据时关闭会话
+	AutoTune          bool          `yaml:"auto_tune"`          // 按测得的 RTT 和吞吐量设置未配置的窗口和保活超时
+}
+
+// PoolSection 到下一跳的 SMUX 会话的底层连接数限制和会话的回收。连接池在第一次使用某个下一跳时创建，不预先建立连接
+type PoolSection struct {
+	MaxCap      int           `yaml:"max_cap"`       // 每个连接池最多保留的空闲连接数
+	MaxPools    int           `yaml:"max_pools"`     // 最多保留的连接池数，超过时关闭最久未使用的，0 表示不限
+	MaxTotal    int           `yaml:"max_total"`     // 所有下一跳的连接总数上限，0 表示不限
+	MaxActive   int           `yaml:"max_active"`    // 到每个下一跳同时打开的连接数上限，0 表示不限
+	WaitTimeout time.Duration `yaml:"wait_timeout"`  // 连接数达到上限时建立会话的最长等待时间
+	MaxIdleTime time.Duration `yaml:"max_idle_time"` // 没有流超过该时长的会话被关闭，0 表示不限
+	MaxLifetime time.Duration `yaml:"max_lifetime"`  // 建立超过该时长的会话不再使用，0 表示不限
+	PingOnGet   bool          `yaml:"ping_on_get"`   // 在空闲会话上打开流之前检查对端是否已经关闭
+}
+
+// ProbeSection 探测代理配置
+type ProbeSection struct {
+	Port           string        `yaml:"port"`            // 供其他节点探测的 TCP 端口
+	Interval       time.Duration `yaml:"interval"`        // 探测间隔
+	ReportInterval time.Duration `yaml:"report_interval"` // 上报平均延迟的间隔
+	Timeout        time.Duration `yaml:"timeout"`         // 单次探测的连接超时
+}
+
+// InfoSection 信息代理配置
+type InfoSection struct {
+	Interval  time.Duration `yaml:"interval"`  // 上报间隔
+	Interface string        `yaml:"interface"` // 统计流量的网卡
+}
+
+// APISection 探测任务和管理接口共用的 HTTP 服务
+type APISection struct {
+	Listen string `yaml:"listen"`
+	// 排空、关闭会话等管理操作要求的令牌，为空时禁用这些操作
+	AdminToken string `yaml:"admin_token"`
+}
+
+// CacheSection 入口响应缓存配置
+type CacheSection struct {
+	Enabled       bool   `yaml:"enabled"`
+	MemoryBytes   int64  `yaml:"memory_bytes"`    // 内存层容量
+	DiskDir       string `yaml:"disk_dir"`        // 磁盘层目录，条目保存在其中的 overlay-cache 子目录下，为空表示只使用内存
+	DiskBytes     int64  `yaml:"disk_bytes"`      // 磁盘层容量
+	MaxEntryBytes int64  `yaml:"max_entry_bytes"` // 单个响应体的大小上限
+}
+
+// AccessLogSection 入口和中继节点的访问日志，每个请求一行 JSON
+type AccessLogSection struct {
+	Path       string `yaml:"path"`        // 日志文件，"-" 表示标准输出，为空表示不记录
+	MaxBytes   int64  `yaml:"max_bytes"`   // 单个文件的大小上限，超过后轮转
+	MaxBackups int    `yaml:"max_backups"` // 保留的历史文件数量
+}
+
+// TracingSection 分布式追踪配置，OTLP 和文件导出可以同时启用
+type TracingSection struct {
+	Enabled      bool     `yaml:"enabled"`
+	OTLPEndpoint string   `yaml:"otlp_endpoint"` // OTLP/HTTP 收集器地址，例如 http://collector:4318/v1/traces
+	OTLPHeaders  []string `yaml:"otlp_headers"`  // 发送到收集器时附加的头部，格式为 Key=Value
+	File         string   `yaml:"file"`          // 离线导出文件，每批 span 一行 OTLP JSON
+	SampleRatio  float64  `yaml:"sample_ratio"`  // 入口开始新追踪时的采样比例
+}
+
+// DefaultNodeConfig 返回默认配置，与各模块原有的默认值一致
+func DefaultNodeConfig() *NodeConfig {
+	hostname, _ := os.Hostname()
+	return &NodeConfig{
+		Node: NodeSection{
+			ID:    hostname,
+			Roles: []string{RoleProbe, RoleInfo},
+		},
+		Controller: ControllerSection{
+			InfoURL:  "http://124.70.34.63:8080/fetch_and_save",
+			ProbeURL: "http://124.70.34.63:8080/fetch_detect",
+		},
+		Ingress: IngressSection{
+			Listen:       ":8081",
+			RoutesFile:   "routes.json",
+			DrainTimeout: 30 * time.Second,
+		},
+		Relay: RelaySection{
+			Listen:         ":" + DefaultProxyPort,
+			DialTimeout:    3 * time.Second,
+			RequestTimeout: 10 * time.Second,
+			DrainTimeout:   30 * time.Second,
+
+			SessionsPerHop:       2,
+			MaxStreamsPerSession: 256,
+			Stripe: StripeSection{
+				Lanes:         1,
+				ChunkSize:     64 << 10,
+				ReorderBuffer: 8 << 20,
+			},
+			Tunnel: TunnelSection{MaxBackoff: 30 * time.Second},
+		},
+		Pool: PoolSection{
+			MaxCap:      20,
+			MaxPools:    256,
+			MaxTotal:    1024,
+			MaxActive:   64,
+			WaitTimeout: 5 * time.Second,
+			MaxIdleTime: 90 * time.Second,
+			MaxLifetime: 30 * time.Minute,
+			PingOnGet:   true,
+		},
+		Probe: ProbeSection{
+			Port:           "50000",
+			Interval:       time.Second,
+			ReportInterval: 10 * time.Second,
+			Timeout:        5 * time.Second,
+		},
+		Info: InfoSection{
+			Interval:  30 * time.Second,
+			Interface: "eth0",
+		},
+		API: APISection{Listen: ":8080"},
+		Cache: CacheSection{
+			MemoryBytes:   64 << 20,
+			DiskBytes:     1 << 30,
+			MaxEntryBytes: 8 << 20,
+		},
+		AccessLog: AccessLogSection{
+			Path:       "-",
+			MaxBytes:   100 << 20,
+			MaxBackups: 5,
+		},
+		Tracing: TracingSection{SampleRatio: 1},
+	}
+}
+
+// LoadNodeConfig 在默认配置的基础上读取 YAML 文件，path 为空时只使用默认配置
+func LoadNodeConfig(path string) (*NodeConfig, error) {
+	cfg := DefaultNodeConfig()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse node config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// fields 返回可以通过环境变量和命令行覆盖的配置项，键与 YAML 路径一致
+func (c *NodeConfig) fields() map[string]interface{} {
+	return map[string]interface{}{
+		"node.id":                       &c.Node.ID,
+		"node.ip":                       &c.Node.IP,
+		"node.roles":                    &c.Node.Roles,
+		"controller.info_url":           &c.Controller.InfoURL,
+		"controller.probe_url":          &c.Controller.ProbeURL,
+		"ingress.listen":                &c.Ingress.Listen,
+		"ingress.routes_file":           &c.Ingress.RoutesFile,
+		"ingress.drain_timeout":         &c.Ingress.DrainTimeout,
+		"ingress.trusted_proxies":       &c.Ingress.TrustedProxies,
+		"ingress.direct":                &c.Ingress.Direct,
+		"relay.listen":                  &c.Relay.Listen,
+		"relay.dial_timeout":            &c.Relay.DialTimeout,
+		"relay.request_timeout":         &c.Relay.RequestTimeout,
+		"relay.drain_timeout":           &c.Relay.DrainTimeout,
+		"relay.sessions_per_hop":        &c.Relay.SessionsPerHop,
+		"relay.max_streams_per_session": &c.Relay.MaxStreamsPerSession,
+		"relay.smux.version":            &c.Relay.SMUX.Version,
+		"relay.smux.max_receive_buffer": &c.Relay.SMUX.MaxReceiveBuffer,
+		"relay.smux.max_stream_buffer":  &c.Relay.SMUX.MaxStreamBuffer,
+		"relay.smux.max_frame_size":     &c.Relay.SMUX.MaxFrameSize,
+		"relay.smux.keepalive_interval": &c.Relay.SMUX.KeepAliveInterval,
+		"relay.smux.keepalive_timeout":  &c.Relay.SMUX.KeepAliveTimeout,
+		"relay.smux.auto_tune":          &c.Relay.SMUX.AutoTune,
+		"relay.stripe.lanes":            &c.Relay.Stripe.Lanes,
+		"relay.stripe.chunk_size":       &c.Relay.Stripe.ChunkSize,
+		"relay.stripe.reorder_buffer":   &c.Relay.Stripe.ReorderBuffer,
+		"relay.tunnel.rendezvous":       &c.Relay.Tunnel.Rendezvous,
+		"relay.tunnel.accept":           &c.Relay.Tunnel.Accept,
+		"relay.tunnel.token":            &c.Relay.Tunnel.Token,
+		"relay.tunnel.max_backoff":      &c.Relay.Tunnel.MaxBackoff,
+		"pool.max_cap":                  &c.Pool.MaxCap,
+		"pool.max_pools":                &c.Pool.MaxPools,
+		"pool.max_total":                &c.Pool.MaxTotal,
+		"pool.max_active":               &c.Pool.MaxActive,
+		"pool.wait_timeout":             &c.Pool.WaitTimeout,
+		"pool.max_idle_time":            &c.Pool.MaxIdleTime,
+		"pool.max_lifetime":             &c.Pool.MaxLifetime,
+		"pool.ping_on_get":              &c.Pool.PingOnGet,
+		"probe.port":                    &c.Probe.Port,
+		"probe.interval":                &c.Probe.Interval,
+		"probe.report_interval":         &c.Probe.ReportInterval,
+		"probe.timeout":                 &c.Probe.Timeout,
+		"info.interval":                 &c.Info.Interval,
+		"info.interface":                &c.Info.Interface,
+		"api.listen":                    &c.API.Listen,
+		"api.admin_token":               &c.API.AdminToken,
+		"cache.enabled":                 &c.Cache.Enabled,
+		"cache.memory_bytes":            &c.Cache.MemoryBytes,
+		"cache.disk_dir":                &c.Cache.DiskDir,
+		"cache.disk_bytes":              &c.Cache.DiskBytes,
+		"cache.max_entry_bytes":         &c.Cache.MaxEntryBytes,
+		"access_log.path":               &c.AccessLog.Path,
+		"access_log.max_bytes":          &c.AccessLog.MaxBytes,
+		"access_log.max_backups":        &c.AccessLog.MaxBackups,
+		"tracing.enabled":               &c.Tracing.Enabled,
+		"tracing.otlp_endpoint":         &c.Tracing.OTLPEndpoint,
+		"tracing.otlp_headers":          &c.Tracing.OTLPHeaders,
+		"tracing.file":                  &c.Tracing.File,
+		"tracing.sample_ratio":          &c.Tracing.SampleRatio,
+	}
+}
+
+// Keys 返回所有可覆盖的配置项
+func (c *NodeConfig) Keys() []string {
+	fields := c.fields()
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Set 按 YAML 路径覆盖一个配置项，例如 Set("relay.listen", ":9001")；
+// 列表使用逗号分隔，时长使用 time.ParseDuration 的格式
+func (c *NodeConfig) Set(key, value string) error {
+	field, exists := c.fields()[key]
+	if !exists {
+		return fmt.Errorf("unknown config key %q", key)
+	}
+
+	switch p := field.(type) {
+	case *string:
+		*p = value
+	case *[]string:
+		*p = splitList(value)
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+		*p = n
+	case *int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+		*p = n
+	case *float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+		*p = f
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+		*p = b
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+		*p = d
+	}
+	return nil
+}
+
+// EnvName 返回覆盖配置项的环境变量名，例如 ingress.listen -> OVERLAY_INGRESS_LISTEN
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// ApplyEnv 用环境变量覆盖配置项，lookup 一般为 os.LookupEnv
+func (c *NodeConfig) ApplyEnv(lookup func(string) (string, bool)) error {
+	for _, key := range c.Keys() {
+		if value, ok := lookup(EnvName(key)); ok {
+			if err := c.Set(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// HasRole 判断节点是否启用了某个角色
+func (c *NodeConfig) HasRole(role string) bool {
+	for _, r := range c.Node.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate 检查角色和各角色依赖的配置
+func (c *NodeConfig) Validate() error {
+	if len(c.Node.Roles) == 0 {
+		return fmt.Errorf("no roles configured")
+	}
+	for _, role := range c.Node.Roles {
+		switch role {
+		case RoleIngress, RoleRelay, RoleEgress, RoleProbe, RoleInfo:
+		default:
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+	for i, rule := range c.Ingress.RateLimits {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("ingress.rate_limits[%d]: %w", i, err)
+		}
+	}
+	if (c.HasRole(RoleRelay) || c.HasRole(RoleEgress)) && c.Node.IP == "" {
+		return fmt.Errorf("node.ip is required for the relay and egress roles")
+	}
+	if c.Relay.SessionsPerHop <= 0 || c.Relay.MaxStreamsPerSession < 0 {
+		return fmt.Errorf("relay.sessions_per_hop must be positive and relay.max_streams_per_session not negative")
+	}
+	if err := c.Relay.SMUX.validate(); err != nil {
+		return fmt.Errorf("relay.smux: %w", err)
+	}
+	for peer, s := range c.Relay.PeerSMUX {
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("relay.peer_smux[%s]: %w", peer, err)
+		}
+	}
+	if err := c.Relay.Stripe.validate(); err != nil {
+		return fmt.Errorf("relay.stripe: %w", err)
+	}
+	if c.Relay.Tunnel.Rendezvous != "" && !c.HasRole(RoleRelay) && !c.HasRole(RoleEgress) {
+		return fmt.Errorf("relay.tunnel.rendezvous requires the relay or egress role")
+	}
+	if c.Relay.Tunnel.Accept && c.Relay.Tunnel.Token == "" {
+		return fmt.Errorf("relay.tunnel.accept requires relay.tunnel.token")
+	}
+	if c.Relay.Tunnel.MaxBackoff <= 0 {
+		return fmt.Errorf("relay.tunnel.max_backoff must be positive")
+	}
+	if c.Pool.MaxCap <= 0 {
+		return fmt.Errorf("invalid pool size %d", c.Pool.MaxCap)
+	}
+	if c.Pool.MaxActive < 0 || c.Pool.MaxPools < 0 || c.Pool.MaxTotal < 0 {
+		return fmt.Errorf("pool.max_active, pool.max_pools and pool.max_total must not be negative")
+	}
+	// 条带化的会话同时占用每条通道的连接，连接数上限小于通道数时无法建立会话
+	lanes := c.Relay.Stripe.Lanes
+	for _, n := range c.Relay.Stripe.PeerLanes {
+		lanes = max(lanes, n)
+	}
+	if (c.Pool.MaxActive > 0 && c.Pool.MaxActive < lanes) || (c.Pool.MaxTotal > 0 && c.Pool.MaxTotal < lanes) {
+		return fmt.Errorf("pool.max_active and pool.max_total must allow at least %d connections for the striped lanes", lanes)
+	}
+	if c.Pool.MaxIdleTime < 0 || c.Pool.MaxLifetime < 0 {
+		return fmt.Errorf("pool.max_idle_time and pool.max_lifetime must not be negative")
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("tracing.sample_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// validate 检查 SMUX 参数，未设置的字段不检查
+func (s SMUXSection) validate() error {
+	if s.Version != 0 && s.Version != 1 && s.Version != 2 {
+		return fmt.Errorf("unsupported version %d", s.Version)
+	}
+	if s.MaxReceiveBuffer < 0 || s.MaxStreamBuffer < 0 || s.MaxFrameSize < 0 || s.KeepAliveInterval < 0 || s.KeepAliveTimeout < 0 {
+		return fmt.Errorf("buffer sizes and keepalive durations must not be negative")
+	}
+	if s.MaxFrameSize > 65535 {
+		return fmt.Errorf("max_frame_size must not be larger than 65535")
+	}
+	if s.MaxReceiveBuffer > 0 && s.MaxStreamBuffer > s.MaxReceiveBuffer {
+		return fmt.Errorf("max_stream_buffer must not be larger than max_receive_buffer")
+	}
+	if s.KeepAliveInterval > 0 && s.KeepAliveTimeout > 0 && s.KeepAliveTimeout < s.KeepAliveInterval {
+		return fmt.Errorf("keepalive_timeout must not be shorter than keepalive_interval")
+	}
+	return nil
+}
+
+// maxStripeLanes 与 stripe.MaxLanes 一致
+const maxStripeLanes = 16
+
+// validate 检查通道数和缓冲区大小
+func (s StripeSection) validate() error {
+	if s.Lanes < 1 || s.Lanes > maxStripeLanes {
+		return fmt.Errorf("lanes must be between 1 and %d", maxStripeLanes)
+	}
+	for peer, lanes := range s.PeerLanes {
+		if lanes < 1 || lanes > maxStripeLanes {
+			return fmt.Errorf("peer_lanes[%s] must be between 1 and %d", peer, maxStripeLanes)
+		}
+	}
+	if s.ChunkSize <= 0 || s.ChunkSize > 1<<20 || s.ReorderBuffer <= 0 {
+		return fmt.Errorf("chunk_size must be between 1 and 1048576 and reorder_buffer positive")
+	}
+	return nil
+}
+
+// splitList 解析逗号分隔的列表，忽略空项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
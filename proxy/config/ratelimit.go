@@ -0,0 +1,33 @@
+package config
+
+import "fmt"
+
+// 限流规则的分组键
+const (
+	LimitByIP       = "ip"       // 按客户端 IP 分别限流
+	LimitByIdentity = "identity" // 按认证身份分别限流，未认证的请求按 IP
+	LimitByRoute    = "route"    // 按命中的路由分别限流
+)
+
+// RateLimitRule 入口限流规则：请求速率和请求/响应体带宽都使用令牌桶。规则写在节点配置的 ingress.rate_limits 中
+type RateLimitRule struct {
+	Key               string  `yaml:"key"`                 // 分组键：ip、identity 或 route
+	Match             string  `yaml:"match"`               // 只对该键值生效，为空表示对所有键值生效
+	RequestsPerSecond float64 `yaml:"requests_per_second"` // 每秒请求数，0 表示不限制
+	Burst             int     `yaml:"burst"`               // 请求突发量，默认取每秒请求数
+	BytesPerSecond    int64   `yaml:"bytes_per_second"`    // 每个方向每秒传输的字节数，0 表示不限制
+	BurstBytes        int64   `yaml:"burst_bytes"`         // 字节突发量，默认取每秒字节数
+}
+
+// Validate 检查规则是否合法
+func (r *RateLimitRule) Validate() error {
+	switch r.Key {
+	case LimitByIP, LimitByIdentity, LimitByRoute:
+	default:
+		return fmt.Errorf("invalid rate limit key %q", r.Key)
+	}
+	if r.RequestsPerSecond < 0 || r.BytesPerSecond < 0 || r.Burst < 0 || r.BurstBytes < 0 {
+		return fmt.Errorf("rate limit for %s must not be negative", r.Key)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+// DefaultProxyPort 中继节点默认的监听端口
+const DefaultProxyPort = "9000"
+
+// DefaultRoute 默认路由的目的地，未命中任何路由的请求使用该条目
+const DefaultRoute = "*"
+
+// Route 描述到达某个目的主机的转发路径
+type Route struct {
+	Destination string       `json:"destination"`     // 目的主机，"*" 表示默认路由
+	Primary     []string     `json:"primary"`         // 主路径，按顺序排列的中继节点 IP
+	Backups     [][]string   `json:"backups"`         // 备用路径，主路径失败时依次尝试
+	Hedge       *HedgePolicy `json:"hedge,omitempty"` // 对冲请求策略，为空表示不对冲
+}
+
+// HedgePolicy 对冲请求策略：高优先级的幂等请求在主路径迟迟没有响应时，
+// 再通过一条不相交的备用路径发送，取先到达的响应
+type HedgePolicy struct {
+	PriorityThreshold uint8   `json:"priority_threshold"` // Priority 高于该值的请求才会对冲
+	Percentile        float64 `json:"percentile"`         // 对冲延迟取主路径时延的分位数，默认 0.95
+	DefaultDelayMs    int     `json:"default_delay_ms"`   // 时延样本不足时使用的对冲延迟
+	MinDelayMs        int     `json:"min_delay_ms"`       // 对冲延迟的下限
+}
+
+// Paths 返回主路径和所有备用路径，主路径在前
+func (r *Route) Paths() [][]string {
+	paths := make([][]string, 0, 1+len(r.Backups))
+	if len(r.Primary) > 0 {
+		paths = append(paths, r.Primary)
+	}
+	for _, backup := range r.Backups {
+		if len(backup) > 0 {
+			paths = append(paths, backup)
+		}
+	}
+	return paths
+}
+
+// routeFile 路由表文件的 JSON 格式
+type routeFile struct {
+	Routes []*Route          `json:"routes"`
+	Peers  map[string]string `json:"peers"` // 中继节点 IP -> 拨号地址，未列出的节点使用 IP:9000
+}
+
+// RouteTable 路由表，保存目的主机到转发路径的映射以及中继节点的拨号地址
+type RouteTable struct {
+	mu     sync.RWMutex
+	routes map[string]*Route
+	peers  map[string]string
+}
+
+// NewRouteTable 创建一个空路由表
+func NewRouteTable() *RouteTable {
+	return &RouteTable{
+		routes: make(map[string]*Route),
+		peers:  make(map[string]string),
+	}
+}
+
+// LoadRouteTable 从 JSON 文件加载路由表
+func LoadRouteTable(filePath string) (*RouteTable, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var file routeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse route table %s: %w", filePath, err)
+	}
+
+	table := NewRouteTable()
+	for _, route := range file.Routes {
+		if err := table.SetRoute(route); err != nil {
+			return nil, err
+		}
+	}
+	for ip, addr := range file.Peers {
+		table.SetPeer(ip, addr)
+	}
+	return table, nil
+}
+
+// SetRoute 添加或替换一条路由，路径中的每个节点都必须是合法的 IPv4 地址
+func (t *RouteTable) SetRoute(route *Route) error {
+	for _, path := range route.Paths() {
+		for _, hop := range path {
+			if _, err := IPToUint32(hop); err != nil {
+				return fmt.Errorf("invalid hop in route %s: %w", route.Destination, err)
+			}
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.routes[route.Destination] = route
+	return nil
+}
+
+// SetPeer 设置中继节点的拨号地址
+func (t *RouteTable) SetPeer(ip, addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.peers[ip] = addr
+}
+
+// Lookup 查找目的主机对应的路由，host 可以带端口；未命中时返回默认路由，都没有则返回 nil
+func (t *RouteTable) Lookup(host string) *Route {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if route, exists := t.routes[host]; exists {
+		return route
+	}
+	return t.routes[DefaultRoute]
+}
+
+// Routes 返回路由表中的所有路由
+func (t *RouteTable) Routes() []*Route {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	routes := make([]*Route, 0, len(t.routes))
+	for _, route := range t.routes {
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// Peers 返回所有显式配置了拨号地址的中继节点
+func (t *RouteTable) Peers() map[string]string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	peers := make(map[string]string, len(t.peers))
+	for ip, addr := range t.peers {
+		peers[ip] = addr
+	}
+	return peers
+}
+
+// HopAddr 返回中继节点的拨号地址
+func (t *RouteTable) HopAddr(ip string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if addr, exists := t.peers[ip]; exists {
+		return addr
+	}
+	return net.JoinHostPort(ip, DefaultProxyPort)
+}
+
+// IsRelay 判断给定 IP 是否为路由表中出现过的中继节点
+func (t *RouteTable) IsRelay(ip string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if _, exists := t.peers[ip]; exists {
+		return true
+	}
+	for _, route := range t.routes {
+		for _, path := range route.Paths() {
+			for _, hop := range path {
+				if hop == ip {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -1,20 +1,73 @@
 package connection
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Options 连接池的参数，时长为零表示不限制
+type Options struct {
+	InitialCap int // 创建时预先建立的连接数
+	MaxCap     int // 池中最多保留的空闲连接数
+	// MaxActive 同时打开的连接数上限，包括空闲和正在使用的连接，0 表示不限。
+	// 达到上限时 GetContext 等待其他连接归还或关闭
+	MaxActive int
+
+	MaxIdleTime time.Duration // 连接在池中空闲超过该时长后丢弃
+	MaxLifetime time.Duration // 连接建立超过该时长后丢弃，无论是否空闲
+	// Ping 取出空闲连接时检查连接是否可用，返回错误的连接被丢弃，为空表示不检查。
+	// 可以使用 CheckConn 检查对端是否已经关闭连接
+	Ping func(net.Conn) error
+	// ReapInterval 后台清理空闲连接的间隔，为零时取 MaxIdleTime 和 MaxLifetime 中较小者的一半；
+	// 两者都为零时不启动后台清理
+	ReapInterval time.Duration
+}
+
+// idleConn 池中的空闲连接
+type idleConn struct {
+	conn      net.Conn
+	createdAt time.Time // 连接建立的时间
+	idleSince time.Time // 放回池中的时间
+}
+
 // channelPool 基于缓冲通道实现pool接口
 type channelPool struct {
 	// 存储连接
 	mu    sync.RWMutex
-	conns chan net.Conn
+	conns chan *idleConn
 
 	// 生成连接
 	factory Factory
+	opts    Options
+	done    chan struct{} // 关闭时通知后台清理和等待连接的调用方退出
+	// 每个打开的连接在 slots 和 shared 中各占用一个位置。slots 限制本连接池的连接数，
+	// MaxActive 为零时为空；shared 是 Manager 中所有连接池共用的总数限制
+	slots  *limiter
+	shared *limiter
+	// reclaim 连接总数达到上限时由 Manager 关闭其他连接池的空闲连接，空出位置时返回 true
+	reclaim func(*channelPool) bool
+	// held 设置了回收函数的独占连接，池中没有空闲连接时 Manager 通过它们请求使用方关闭空闲的连接
+	heldMu sync.Mutex
+	held   map[*PoolConn]struct{}
+
+	// 统计信息
+	gets          atomic.Uint64
+	misses        atomic.Uint64
+	factoryErrors atomic.Uint64
+	created       atomic.Uint64
+	reused        atomic.Uint64
+	discarded     atomic.Uint64
+	closed        atomic.Uint64
+	closedFull    atomic.Uint64
+	inUse         atomic.Int64
+	waits         atomic.Uint64
+	waitTimeouts  atomic.Uint64
+	waitDuration  atomic.Int64
 }
 
 // Factory 生成连接的函数
@@ -24,29 +77,57 @@ type Factory func() (net.Conn, error)
 // Factory函数在初始容量大于零时填充连接池。
 // Get()时，如果没有新连接在池中可用，将通过Factory函数创建一个新连接
 func NewChannelPool(initialCap, maxCap int, factory Factory) (Pool, error) {
-	if initialCap < 0 || maxCap <= 0 || initialCap > maxCap {
+	return NewPool(factory, Options{InitialCap: initialCap, MaxCap: maxCap})
+}
+
+// NewPool 按参数创建基于缓冲通道的连接池，设置了空闲时间或生存时间时在后台定期清理过期的空闲连接
+func NewPool(factory Factory, opts Options) (Pool, error) {
+	c, err := newChannelPool(factory, opts, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// newChannelPool 创建连接池，shared 和 reclaim 由 Manager 提供，单独使用时为空
+func newChannelPool(factory Factory, opts Options, shared *limiter, reclaim func(*channelPool) bool) (*channelPool, error) {
+	if opts.InitialCap < 0 || opts.MaxCap <= 0 || opts.InitialCap > opts.MaxCap {
 		return nil, errors.New("invalid capacity settings")
 	}
+	if opts.MaxActive < 0 || (opts.MaxActive > 0 && opts.MaxActive < opts.InitialCap) {
+		return nil, errors.New("invalid max active connections")
+	}
 
 	c := &channelPool{
-		conns:   make(chan net.Conn, maxCap),
+		conns:   make(chan *idleConn, opts.MaxCap),
 		factory: factory,
+		opts:    opts,
+		done:    make(chan struct{}),
+		slots:   newLimiter(opts.MaxActive),
+		shared:  shared,
+		reclaim: reclaim,
 	}
 
-	// 创建初始容量的连接
-	for i := 0; i < initialCap; i++ {
+	// 创建初始容量的连接，连接总数达到上限时不再创建
+	for i := 0; i < opts.InitialCap && c.tryAcquire(); i++ {
 		conn, err := factory()
 		if err != nil {
 			c.Close()
 			return nil, fmt.Errorf("factory is not able to fill the pool: %s", err)
 		}
-		c.conns <- conn
+		c.created.Add(1)
+		now := time.Now()
+		c.conns <- &idleConn{conn: conn, createdAt: now, idleSince: now}
+	}
+
+	if interval := c.reapInterval(); interval > 0 {
+		go c.reap(interval)
 	}
 	return c, nil
 }
 
 // getConnsAndFactory 用于安全地获取连接通道和工厂函数，采用读锁保护数据的并发访问
-func (c *channelPool) getConnsAndFactory() (chan net.Conn, Factory) {
+func (c *channelPool) getConnsAndFactory() (chan *idleConn, Factory) {
 	c.mu.RLock()
 	conns := c.conns
 	factory := c.factory
@@ -54,33 +135,201 @@ func (c *channelPool) getConnsAndFactory() (chan net.Conn, Factory) {
 	return conns, factory
 }
 
-// Get 实现 Pool 接口 Get() 方法.
-// 如果连接池中没有可用连接，调用factory函数创建新连接
+// Get 实现 Pool 接口 Get() 方法，连接数达到上限时一直等待
 func (c *channelPool) Get() (net.Conn, error) {
+	return c.GetContext(context.Background())
+}
+
+// GetContext 实现 Pool 接口 GetContext() 方法.
+// 优先取出池中可用的空闲连接，过期或检查失败的连接直接丢弃；
+// 如果连接池中没有可用连接，调用factory函数创建新连接；
+// 连接数达到上限时等待空闲连接归还或其他连接关闭，ctx 先结束时返回 ErrWaitTimeout
+func (c *channelPool) GetContext(ctx context.Context) (net.Conn, error) {
 	conns, factory := c.getConnsAndFactory()
 	if conns == nil {
 		return nil, ErrClosed
 	}
-	// 返回 wrapConn(conn)，即封装后的连接，以便调用 Close() 时可以返回池中。
-	select {
-	case conn := <-conns:
-		if conn == nil {
+	c.gets.Add(1)
+	missed := false
+	for {
+		// 返回 wrapConn(conn)，即封装后的连接，以便调用 Close() 时可以返回池中。
+		select {
+		case ic, ok := <-conns:
+			if !ok {
+				return nil, ErrClosed
+			}
+			if conn, ok := c.checkout(ic); ok {
+				return conn, nil
+			}
+			continue
+		default:
+		}
+
+		if !missed {
+			missed = true
+			c.misses.Add(1)
+		}
+		slotFreed, sharedFreed := c.slots.wait(), c.shared.wait()
+		if c.tryAcquire() {
+			return c.dial(factory)
+		}
+		if c.reclaim != nil && c.reclaim(c) {
+			continue
+		}
+
+		// 连接数达到上限，等待空闲连接归还或者有连接关闭空出位置
+		c.waits.Add(1)
+		start := time.Now()
+		select {
+		case ic, ok := <-conns:
+			c.waitDuration.Add(int64(time.Since(start)))
+			if !ok {
+				return nil, ErrClosed
+			}
+			if conn, ok := c.checkout(ic); ok {
+				return conn, nil
+			}
+		case <-slotFreed:
+			c.waitDuration.Add(int64(time.Since(start)))
+		case <-sharedFreed:
+			c.waitDuration.Add(int64(time.Since(start)))
+		case <-c.done:
+			c.waitDuration.Add(int64(time.Since(start)))
 			return nil, ErrClosed
+		case <-ctx.Done():
+			c.waitDuration.Add(int64(time.Since(start)))
+			c.waitTimeouts.Add(1)
+			return nil, fmt.Errorf("%w: %w", ErrWaitTimeout, ctx.Err())
 		}
+	}
+}
+
+// checkout 检查取出的空闲连接，可用时封装后返回，不可用时关闭并释放它占用的位置
+func (c *channelPool) checkout(ic *idleConn) (net.Conn, bool) {
+	if !c.usable(ic, time.Now(), true) {
+		c.discarded.Add(1)
+		c.discard(ic.conn)
+		return nil, false
+	}
+	c.reused.Add(1)
+	return c.wrapConn(ic.conn, ic.createdAt), true
+}
+
+// dial 在已经占用位置之后新建连接，失败时释放位置
+func (c *channelPool) dial(factory Factory) (net.Conn, error) {
+	conn, err := factory()
+	if err != nil {
+		c.release()
+		c.factoryErrors.Add(1)
+		return nil, err
+	}
+	c.created.Add(1)
+	return c.wrapConn(conn, time.Now()), nil
+}
+
+// tryAcquire 不等待地为新连接在本连接池和连接总数中各占用一个位置，没有上限时总是成功
+func (c *channelPool) tryAcquire() bool {
+	if !c.slots.tryAcquire() {
+		return false
+	}
+	if !c.shared.tryAcquire() {
+		c.slots.release()
+		return false
+	}
+	return true
+}
+
+// release 连接关闭后释放它占用的位置
+func (c *channelPool) release() {
+	c.slots.release()
+	c.shared.release()
+}
 
-		return c.wrapConn(conn), nil
+// closeIdle 关闭一个空闲连接，为其他连接池空出位置，池中没有空闲连接时返回 false
+func (c *channelPool) closeIdle() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.conns == nil {
+		return false
+	}
+	select {
+	case ic := <-c.conns:
+		c.discard(ic.conn)
+		return true
 	default:
-		conn, err := factory()
-		if err != nil {
-			return nil, err
+		return false
+	}
+}
+
+// hold 登记设置了回收函数的独占连接
+func (c *channelPool) hold(p *PoolConn) {
+	c.heldMu.Lock()
+	defer c.heldMu.Unlock()
+	if c.held == nil {
+		c.held = make(map[*PoolConn]struct{})
+	}
+	c.held[p] = struct{}{}
+}
+
+// unhold 独占连接关闭时注销
+func (c *channelPool) unhold(p *PoolConn) {
+	c.heldMu.Lock()
+	defer c.heldMu.Unlock()
+	delete(c.held, p)
+}
+
+// reclaimHeld 依次请求独占连接的使用方关闭空闲的连接，有一个连接被关闭时返回 true。
+// 调用回收函数时不持有任何锁，回收函数关闭连接时会注销它
+func (c *channelPool) reclaimHeld() bool {
+	c.heldMu.Lock()
+	held := make([]*PoolConn, 0, len(c.held))
+	for p := range c.held {
+		held = append(held, p)
+	}
+	c.heldMu.Unlock()
+
+	for _, p := range held {
+		p.mu.RLock()
+		reclaim, closed := p.reclaim, p.closed
+		p.mu.RUnlock()
+		if !closed && reclaim() {
+			return true
 		}
+	}
+	return false
+}
 
-		return c.wrapConn(conn), nil
+// drain 关闭所有空闲连接，并请求独占连接的使用方关闭空闲的连接，没有连接仍在使用时返回 true
+func (c *channelPool) drain() bool {
+	for c.closeIdle() {
 	}
+	for c.inUse.Load() > 0 && c.reclaimHeld() {
+	}
+	return c.inUse.Load() == 0
+}
+
+// discard 关闭一个不再放回池中的连接并释放位置
+func (c *channelPool) discard(conn net.Conn) error {
+	c.release()
+	return conn.Close()
 }
 
-// put 将连接放回连接池. 连接池满了或者关闭了则彻底关闭连接,
-func (c *channelPool) put(conn net.Conn) error {
+// usable 判断空闲连接是否仍然可用，ping 为 true 时同时执行 Ping 检查
+func (c *channelPool) usable(ic *idleConn, now time.Time, ping bool) bool {
+	if c.opts.MaxLifetime > 0 && now.Sub(ic.createdAt) >= c.opts.MaxLifetime {
+		return false
+	}
+	if c.opts.MaxIdleTime > 0 && now.Sub(ic.idleSince) >= c.opts.MaxIdleTime {
+		return false
+	}
+	if ping && c.opts.Ping != nil && c.opts.Ping(ic.conn) != nil {
+		return false
+	}
+	return true
+}
+
+// put 将连接放回连接池. 连接池满了、关闭了或者连接超过生存时间则彻底关闭连接
+func (c *channelPool) put(conn net.Conn, createdAt time.Time) error {
 	if conn == nil {
 		return errors.New("connection is nil. rejecting")
 	}
@@ -90,15 +339,79 @@ func (c *channelPool) put(conn net.Conn) error {
 
 	// 连接池如果关闭则彻底关闭连接
 	if c.conns == nil {
-		return conn.Close()
+		return c.discard(conn)
+	}
+
+	now := time.Now()
+	if c.opts.MaxLifetime > 0 && now.Sub(createdAt) >= c.opts.MaxLifetime {
+		c.discarded.Add(1)
+		return c.discard(conn)
 	}
 
 	// 若池未满，将连接放回池中；若池已满，则直接关闭连接。
 	select {
-	case c.conns <- conn:
+	case c.conns <- &idleConn{conn: conn, createdAt: createdAt, idleSince: now}:
 		return nil
 	default:
-		return conn.Close()
+		c.closedFull.Add(1)
+		return c.discard(conn)
+	}
+}
+
+// reapInterval 返回后台清理的间隔，不需要清理时返回 0
+func (c *channelPool) reapInterval() time.Duration {
+	if c.opts.ReapInterval > 0 {
+		return c.opts.ReapInterval
+	}
+	limit := c.opts.MaxIdleTime
+	if limit == 0 || (c.opts.MaxLifetime > 0 && c.opts.MaxLifetime < limit) {
+		limit = c.opts.MaxLifetime
+	}
+	return limit / 2
+}
+
+// reap 定期丢弃过期的空闲连接，连接池关闭时退出
+func (c *channelPool) reap(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.reapOnce()
+		}
+	}
+}
+
+// reapOnce 检查池中当前的每个空闲连接，仍然可用的放回池中。
+// 持有读锁，保证检查期间连接池不会被关闭
+func (c *channelPool) reapOnce() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.conns == nil {
+		return
+	}
+
+	now := time.Now()
+	for n := len(c.conns); n > 0; n-- {
+		var ic *idleConn
+		select {
+		case ic = <-c.conns:
+		default:
+			return
+		}
+		if !c.usable(ic, now, true) {
+			c.discarded.Add(1)
+			c.discard(ic.conn)
+			continue
+		}
+		select {
+		case c.conns <- ic:
+		default:
+			c.closedFull.Add(1)
+			c.discard(ic.conn)
+		}
 	}
 }
 
@@ -113,9 +426,10 @@ func (c *channelPool) Close() {
 		return
 	}
 
+	close(c.done)
 	close(conns)
-	for conn := range conns {
-		conn.Close()
+	for ic := range conns {
+		c.discard(ic.conn)
 	}
 }
 
@@ -123,3 +437,24 @@ func (c *channelPool) Len() int {
 	conns, _ := c.getConnsAndFactory()
 	return len(conns)
 }
+
+// Stats 实现 Pool 接口 Stats() 方法
+func (c *channelPool) Stats() Stats {
+	idle, inUse := c.Len(), int(c.inUse.Load())
+	return Stats{
+		Idle:          idle,
+		InUse:         inUse,
+		Active:        idle + inUse,
+		Gets:          c.gets.Load(),
+		Misses:        c.misses.Load(),
+		FactoryErrors: c.factoryErrors.Load(),
+		Created:       c.created.Load(),
+		Reused:        c.reused.Load(),
+		Discarded:     c.discarded.Load(),
+		Closed:        c.closed.Load(),
+		ClosedFull:    c.closedFull.Load(),
+		Waits:         c.waits.Load(),
+		WaitTimeouts:  c.waitTimeouts.Load(),
+		WaitDuration:  time.Duration(c.waitDuration.Load()),
+	}
+}
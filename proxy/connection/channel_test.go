@@ -0,0 +1,164 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// listen 启动一个接受连接的本地服务器，返回接受到的连接和拨号用的 Factory
+func listen(t *testing.T) (<-chan net.Conn, Factory) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	accepted := make(chan net.Conn, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			accepted <- conn
+		}
+	}()
+	return accepted, func() (net.Conn, error) { return net.Dial("tcp", listener.Addr().String()) }
+}
+
+func TestGetDiscardsDeadConn(t *testing.T) {
+	accepted, factory := listen(t)
+	pool, err := NewPool(factory, Options{InitialCap: 1, MaxCap: 2, Ping: CheckConn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	// 对端关闭池中的空闲连接，Get 应该丢弃它并新建连接
+	(<-accepted).Close()
+	time.Sleep(20 * time.Millisecond)
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if stats := pool.Stats(); stats.Misses != 1 {
+		t.Errorf("expected a new connection after discarding the dead one, got %+v", stats)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Errorf("write on fresh connection: %v", err)
+	}
+}
+
+func TestReaperDropsIdleConns(t *testing.T) {
+	_, factory := listen(t)
+	pool, err := NewPool(factory, Options{InitialCap: 2, MaxCap: 2, MaxIdleTime: 30 * time.Millisecond, ReapInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	for deadline := time.Now().Add(time.Second); pool.Len() > 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("idle connections were not reaped, %d left", pool.Len())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 超过生存时间的连接不会放回池中
+	lifetime, err := NewPool(factory, Options{MaxCap: 1, MaxLifetime: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lifetime.Close()
+	conn, err := lifetime.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	conn.Close()
+	if lifetime.Len() != 0 {
+		t.Error("expired connection was returned to the pool")
+	}
+}
+
+func TestGetContextWaitsAtMaxActive(t *testing.T) {
+	_, factory := listen(t)
+	pool, err := NewPool(factory, Options{MaxCap: 1, MaxActive: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 连接数达到上限，等待超时
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.GetContext(ctx); !errors.Is(err, ErrWaitTimeout) {
+		t.Fatalf("expected ErrWaitTimeout, got %v", err)
+	}
+
+	// 连接归还后等待的调用方取得同一个连接
+	got := make(chan net.Conn)
+	go func() {
+		c, err := pool.GetContext(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		got <- c
+	}()
+	time.Sleep(10 * time.Millisecond)
+	underlying := conn.(*PoolConn).Conn
+	conn.Close()
+	if c := <-got; c == nil || c.(*PoolConn).Conn != underlying {
+		t.Error("waiter did not receive the returned connection")
+	}
+
+	stats := pool.Stats()
+	if stats.Active != 1 || stats.Waits != 2 || stats.WaitTimeouts != 1 || stats.WaitDuration <= 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestStats(t *testing.T) {
+	_, factory := listen(t)
+	pool, err := NewPool(factory, Options{InitialCap: 1, MaxCap: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	first, _ := pool.Get()  // 复用初始连接
+	second, _ := pool.Get() // 新建连接
+	if s := pool.Stats(); s.InUse != 2 || s.Idle != 0 || s.Created != 2 || s.Reused != 1 {
+		t.Errorf("unexpected stats with two connections in use %+v", s)
+	}
+	first.Close()
+	second.Close() // 池中已有一个空闲连接
+	third, _ := pool.Get()
+	third.(*PoolConn).MarkUnusable()
+	third.Close()
+	// 独占使用的连接关闭时不放回池中，也不算作丢弃
+	fourth, _ := pool.Get()
+	fourth.(*PoolConn).MarkExclusive()
+	fourth.Close()
+
+	want := Stats{Created: 3, Reused: 2, Misses: 2, Gets: 4, ClosedFull: 1, Discarded: 1, Closed: 1}
+	if s := pool.Stats(); s != want {
+		t.Errorf("got %+v, want %+v", s, want)
+	}
+	var total Stats
+	total.Add(want)
+	total.Add(want)
+	if total.Created != 6 || total.Discarded != 2 || total.Closed != 2 {
+		t.Errorf("unexpected aggregate %+v", total)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package connection
+
+import "net"
+
+// CheckConn 当前平台无法非阻塞地查看接收缓冲区，总是视为可用
+func CheckConn(conn net.Conn) error {
+	return nil
+}
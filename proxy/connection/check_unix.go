@@ -0,0 +1,42 @@
+//go:build unix
+
+package connection
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// CheckConn 检查空闲连接是否仍然可用，可以作为 Options.Ping。
+// 用 MSG_PEEK 非阻塞地查看接收缓冲区，不会读走数据：对端已关闭时返回 io.EOF，
+// 连接出错时返回对应的错误；没有数据或有未读数据都视为可用。无法取得文件描述符的连接总是视为可用
+func CheckConn(conn net.Conn) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	var checkErr error
+	err = raw.Control(func(fd uintptr) {
+		var buf [1]byte
+		n, _, err := unix.Recvfrom(int(fd), buf[:], unix.MSG_PEEK|unix.MSG_DONTWAIT)
+		switch {
+		case n == 0 && err == nil:
+			checkErr = io.EOF
+		case err != nil && !errors.Is(err, unix.EAGAIN) && !errors.Is(err, unix.EWOULDBLOCK) && !errors.Is(err, unix.EINTR):
+			checkErr = err
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return checkErr
+}
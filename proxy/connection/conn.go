@@ -1,30 +1,50 @@
 package connection
 
 import (
+	"errors"
 	"net"
 	"sync"
+	"syscall"
+	"time"
 )
 
 // PoolConn 对tcp连接的封装
 type PoolConn struct {
 	net.Conn
-	mu       sync.RWMutex
-	c        *channelPool
-	unusable bool
+	mu        sync.RWMutex
+	c         *channelPool
+	unusable  bool
+	exclusive bool        // 由调用方独占使用直到关闭，关闭时不放回池中
+	reclaim   func() bool // 请求独占连接的使用方关闭空闲的连接，见 SetReclaim
+	closed    bool        // 已经放回池中或关闭，防止重复归还
+	created   time.Time   // 底层连接建立的时间，用于判断生存时间
 }
 
-// Close() 将tcp连接放回池中而非彻底关闭
+// Close() 将tcp连接放回池中而非彻底关闭，重复调用时不做任何事
 func (p *PoolConn) Close() error {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	p.c.inUse.Add(-1)
+	if p.reclaim != nil {
+		p.c.unhold(p)
+	}
 	if p.unusable {
 		if p.Conn != nil {
-			return p.Conn.Close()
+			p.c.discarded.Add(1)
+			return p.c.discard(p.Conn)
 		}
 		return nil
 	}
-	return p.c.put(p.Conn)
+	if p.exclusive {
+		p.c.closed.Add(1)
+		return p.c.discard(p.Conn)
+	}
+	return p.c.put(p.Conn, p.created)
 }
 
 // MarkUnusable() 用于将连接标记为不可用。这样连接不会被放回池中，而是在 Close() 调用时直接关闭。
@@ -34,9 +54,39 @@ func (p *PoolConn) MarkUnusable() {
 	p.mu.Unlock()
 }
 
+// MarkExclusive 标记连接由调用方独占使用直到关闭，例如作为 SMUX 会话的底层连接。
+// Close 时直接关闭而不放回池中，计入 Closed；之前或之后调用了 MarkUnusable 的仍计入 Discarded
+func (p *PoolConn) MarkExclusive() {
+	p.mu.Lock()
+	p.exclusive = true
+	p.mu.Unlock()
+}
+
+// SetReclaim 为独占使用的连接设置回收函数。连接总数达到上限或者连接池被 Manager 回收时，
+// 调用它请求使用方关闭仍然空闲的连接，使用方关闭了连接时返回 true。
+// 回收函数在 Manager 持有锁时调用，不能再使用同一个 Manager
+func (p *PoolConn) SetReclaim(reclaim func() bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.reclaim = reclaim
+	p.c.hold(p)
+}
+
+// SyscallConn 返回底层连接，用于 CheckConn 和读取 TCP_INFO 等内核统计
+func (p *PoolConn) SyscallConn() (syscall.RawConn, error) {
+	if sc, ok := p.Conn.(syscall.Conn); ok {
+		return sc.SyscallConn()
+	}
+	return nil, errors.New("pooled connection does not expose a syscall connection")
+}
+
 // 将一个标准 net.Conn 封装为 PoolConn
-func (c *channelPool) wrapConn(conn net.Conn) net.Conn {
-	p := &PoolConn{c: c}
+func (c *channelPool) wrapConn(conn net.Conn, created time.Time) net.Conn {
+	c.inUse.Add(1)
+	p := &PoolConn{c: c, created: created}
 	p.Conn = conn
 	return p
 }
@@ -0,0 +1,57 @@
+package connection
+
+import "sync"
+
+// limiter 计数信号量，限制打开的连接数。可以被多个连接池共用，限制它们打开的连接总数。
+// 释放位置时唤醒所有等待者，由它们重新尝试占用；nil 表示不限制
+type limiter struct {
+	mu    sync.Mutex
+	max   int
+	n     int
+	freed chan struct{} // 释放位置时关闭并替换
+}
+
+// newLimiter 创建上限为 max 的信号量，max 不大于零时返回 nil
+func newLimiter(max int) *limiter {
+	if max <= 0 {
+		return nil
+	}
+	return &limiter{max: max, freed: make(chan struct{})}
+}
+
+// tryAcquire 不等待地占用一个位置
+func (l *limiter) tryAcquire() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.n >= l.max {
+		return false
+	}
+	l.n++
+	return true
+}
+
+// release 释放一个位置并唤醒等待者
+func (l *limiter) release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.n--
+	close(l.freed)
+	l.freed = make(chan struct{})
+	l.mu.Unlock()
+}
+
+// wait 返回下一次释放位置时关闭的通道。需要在 tryAcquire 之前取得，避免错过两者之间的释放；
+// 不限制时返回 nil，永远不会就绪
+func (l *limiter) wait() <-chan struct{} {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.freed
+}
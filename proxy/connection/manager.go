@@ -0,0 +1,177 @@
+package connection
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// ManagerOptions 连接池管理器的参数
+type ManagerOptions struct {
+	// Pool 每个连接池的参数。连接池在第一次使用时创建，InitialCap 被忽略，不预先建立连接
+	Pool Options
+	// MaxPools 最多同时保留的连接池数，超过时关闭最久未使用且没有连接在使用的连接池，0 表示不限
+	MaxPools int
+	// MaxTotal 所有连接池打开的连接总数上限，0 表示不限。达到上限时先关闭最久未使用的
+	// 连接池中的空闲连接，再请求独占连接的使用方关闭空闲的连接（见 PoolConn.SetReclaim），
+	// 都没有可以关闭的连接时等待其他连接关闭
+	MaxTotal int
+}
+
+// Manager 按目的地址管理连接池，第一次使用某个目的地址时才创建连接池，
+// 连接池数或连接总数达到上限时按最近最少使用的顺序回收
+type Manager struct {
+	mu      sync.Mutex
+	factory func(key string) Factory
+	opts    ManagerOptions
+	shared  *limiter
+	lru     *list.List // 元素为 *managedPool，最近使用的在前
+	pools   map[string]*list.Element
+	closed  bool
+}
+
+// managedPool 管理器中的一个连接池
+type managedPool struct {
+	key  string
+	pool *channelPool
+}
+
+// NewManager 创建连接池管理器，factory 返回连接到某个目的地址的 Factory
+func NewManager(factory func(key string) Factory, opts ManagerOptions) (*Manager, error) {
+	opts.Pool.InitialCap = 0
+	if opts.Pool.MaxCap <= 0 || opts.Pool.MaxActive < 0 {
+		return nil, errors.New("invalid capacity settings")
+	}
+	if opts.MaxPools < 0 || opts.MaxTotal < 0 {
+		return nil, errors.New("invalid manager limits")
+	}
+	return &Manager{
+		factory: factory,
+		opts:    opts,
+		shared:  newLimiter(opts.MaxTotal),
+		lru:     list.New(),
+		pools:   make(map[string]*list.Element),
+	}, nil
+}
+
+// Pool 返回到 key 的连接池，不存在时创建。连接池可能在之后被回收，
+// 此时它的 Get 返回 ErrClosed，再次调用 Pool 会创建新的连接池
+func (m *Manager) Pool(key string) (Pool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	if elem, exists := m.pools[key]; exists {
+		m.lru.MoveToFront(elem)
+		return elem.Value.(*managedPool).pool, nil
+	}
+
+	pool, err := newChannelPool(m.factory(key), m.opts.Pool, m.shared, m.reclaim)
+	if err != nil {
+		return nil, err
+	}
+	m.pools[key] = m.lru.PushFront(&managedPool{key: key, pool: pool})
+
+	// 连接池数超过上限时从最久未使用的开始关闭连接池。仍有连接在使用的连接池保留，
+	// 避免这些连接脱离目的地址的 MaxActive 限制，此时连接池数可以暂时超过上限
+	for elem := m.lru.Back(); elem != nil && m.opts.MaxPools > 0 && m.lru.Len() > m.opts.MaxPools; {
+		prev := elem.Prev()
+		if mp := elem.Value.(*managedPool); mp.pool != pool && mp.pool.drain() {
+			m.remove(elem)
+		}
+		elem = prev
+	}
+	return pool, nil
+}
+
+// GetContext 从到 key 的连接池取出一个连接，连接池恰好被回收时在新的连接池中重试
+func (m *Manager) GetContext(ctx context.Context, key string) (net.Conn, error) {
+	for {
+		pool, err := m.Pool(key)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := pool.GetContext(ctx)
+		if errors.Is(err, ErrClosed) && !m.isClosed() {
+			continue
+		}
+		return conn, err
+	}
+}
+
+// Remove 关闭并移除到 key 的连接池
+func (m *Manager) Remove(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	elem, exists := m.pools[key]
+	if exists {
+		m.remove(elem)
+	}
+	return exists
+}
+
+// remove 关闭并移除一个连接池，调用方持有锁
+func (m *Manager) remove(elem *list.Element) {
+	mp := m.lru.Remove(elem).(*managedPool)
+	delete(m.pools, mp.key)
+	mp.pool.Close()
+}
+
+// reclaim 连接总数达到上限时，从最久未使用的连接池开始关闭一个空闲连接；
+// 都没有空闲连接时，再按同样的顺序请求独占连接的使用方关闭一个空闲的连接。
+// 关闭连接后不再有任何连接的连接池一并移除
+func (m *Manager) reclaim(requester *channelPool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, free := range []func(*channelPool) bool{(*channelPool).closeIdle, (*channelPool).reclaimHeld} {
+		for elem := m.lru.Back(); elem != nil; elem = elem.Prev() {
+			mp := elem.Value.(*managedPool)
+			if mp.pool == requester || !free(mp.pool) {
+				continue
+			}
+			if stats := mp.pool.Stats(); stats.Active == 0 {
+				m.remove(elem)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Stats 返回每个连接池的统计信息
+func (m *Manager) Stats() map[string]Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make(map[string]Stats, len(m.pools))
+	for key, elem := range m.pools {
+		stats[key] = elem.Value.(*managedPool).pool.Stats()
+	}
+	return stats
+}
+
+// Len 返回连接池数
+func (m *Manager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.pools)
+}
+
+// Close 关闭所有连接池，之后的 Pool 和 GetContext 返回 ErrClosed
+func (m *Manager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for m.lru.Len() > 0 {
+		m.remove(m.lru.Back())
+	}
+}
+
+func (m *Manager) isClosed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed
+}
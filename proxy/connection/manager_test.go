@@ -0,0 +1,110 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestManagerEvictsLeastRecentlyUsed(t *testing.T) {
+	_, factory := listen(t)
+	m, err := NewManager(func(string) Factory { return factory }, ManagerOptions{
+		Pool:     Options{MaxCap: 2},
+		MaxPools: 2,
+		MaxTotal: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a 和 b 各有一个空闲连接，连接总数达到上限
+	for _, key := range []string{"a", "b"} {
+		conn, err := m.GetContext(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+
+	// 再次使用 a，b 成为最久未使用的连接池。c 需要新连接时关闭 b 的空闲连接并移除 b
+	if _, err := m.Pool("a"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := m.GetContext(context.Background(), "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if stats := m.Stats(); len(stats) != 2 || stats["a"].Idle != 1 || stats["c"].InUse != 1 {
+		t.Errorf("unexpected pools after reclaim %+v", stats)
+	}
+
+	// 没有空闲连接可以关闭时等待
+	a, _ := m.Pool("a")
+	held, err := a.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := m.GetContext(ctx, "d"); !errors.Is(err, ErrWaitTimeout) {
+		t.Errorf("expected ErrWaitTimeout at max total, got %v", err)
+	}
+
+	m.Close()
+	if _, err := m.Pool("a"); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestManagerReclaimsHeldConns(t *testing.T) {
+	_, factory := listen(t)
+	m, err := NewManager(func(string) Factory { return factory }, ManagerOptions{
+		Pool:     Options{MaxCap: 2},
+		MaxPools: 1,
+		MaxTotal: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// a 的连接由使用方独占，使用方还在使用时拒绝关闭
+	conn, err := m.GetContext(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	held := conn.(*PoolConn)
+	held.MarkExclusive()
+	busy := true
+	held.SetReclaim(func() bool {
+		if busy {
+			return false
+		}
+		held.Close()
+		return true
+	})
+
+	// 仍有连接在使用的连接池不会因为连接池数超过上限被关闭，连接总数达到上限时等待
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := m.GetContext(ctx, "b"); !errors.Is(err, ErrWaitTimeout) {
+		t.Fatalf("expected ErrWaitTimeout while the held connection is busy, got %v", err)
+	}
+	if stats := m.Stats(); stats["a"].InUse != 1 {
+		t.Fatalf("expected a to keep its connection in use, got %+v", stats)
+	}
+
+	// 使用方空闲后，连接总数达到上限时请求它关闭连接，为 b 空出位置
+	busy = false
+	other, err := m.GetContext(context.Background(), "b")
+	if err != nil {
+		t.Fatalf("expected the idle held connection to be reclaimed, got %v", err)
+	}
+	defer other.Close()
+	if stats := m.Stats(); len(stats) != 1 || stats["b"].InUse != 1 {
+		t.Errorf("expected only b to remain, got %+v", stats)
+	}
+}
@@ -1,13 +1,17 @@
 package connection
 
 import (
+	"context"
 	"errors"
 	"net"
+	"time"
 )
 
 var (
 	// ErrClosed 连接池关闭时调用pool.Close()错误
 	ErrClosed = errors.New("pool is closed")
+	// ErrWaitTimeout 连接数达到上限，等待其他连接归还时 context 先结束
+	ErrWaitTimeout = errors.New("timed out waiting for a pooled connection")
 )
 
 // Pool 接口
@@ -15,9 +19,53 @@ type Pool interface {
 	// Get 返回从连接池获取的一个tcp连接
 	Get() (net.Conn, error)
 
+	// GetContext 与 Get 相同，连接数达到上限时等待其他连接归还，直到 ctx 结束
+	GetContext(ctx context.Context) (net.Conn, error)
+
 	// Close 关闭连接池以及所有连接
 	Close()
 
 	// Len 返回连接池中连接数量
 	Len() int
+
+	// Stats 返回连接池的统计信息
+	Stats() Stats
+}
+
+// Stats 连接池的统计信息，计数从连接池创建时开始累计
+type Stats struct {
+	Idle          int    `json:"idle"`           // 池中空闲连接数
+	InUse         int    `json:"in_use"`         // 已经取出、尚未归还或关闭的连接数
+	Active        int    `json:"active"`         // 当前打开的连接数，即空闲和正在使用的连接之和
+	Gets          uint64 `json:"gets"`           // Get 调用次数
+	Misses        uint64 `json:"misses"`         // 池中没有空闲连接、需要新建连接的次数
+	FactoryErrors uint64 `json:"factory_errors"` // 新建连接失败的次数
+
+	Created    uint64 `json:"created"`     // 成功新建的连接数，包括初始连接
+	Reused     uint64 `json:"reused"`      // 取出空闲连接复用的次数
+	Discarded  uint64 `json:"discarded"`   // 因标记为不可用、检查失败或过期而关闭的连接数
+	Closed     uint64 `json:"closed"`      // 独占使用的连接（见 PoolConn.MarkExclusive）正常关闭的次数
+	ClosedFull uint64 `json:"closed_full"` // 归还时池中空闲连接已满而关闭的连接数
+
+	Waits        uint64        `json:"waits"`         // 连接数达到上限、需要等待的次数
+	WaitTimeouts uint64        `json:"wait_timeouts"` // 等待超时或被取消的次数
+	WaitDuration time.Duration `json:"wait_duration"` // 累计的等待时间，单位为纳秒
+}
+
+// Add 累加另一个连接池的统计信息，用于汇总到所有下一跳的连接池
+func (s *Stats) Add(other Stats) {
+	s.Idle += other.Idle
+	s.InUse += other.InUse
+	s.Active += other.Active
+	s.Gets += other.Gets
+	s.Misses += other.Misses
+	s.FactoryErrors += other.FactoryErrors
+	s.Created += other.Created
+	s.Reused += other.Reused
+	s.Discarded += other.Discarded
+	s.Closed += other.Closed
+	s.ClosedFull += other.ClosedFull
+	s.Waits += other.Waits
+	s.WaitTimeouts += other.WaitTimeouts
+	s.WaitDuration += other.WaitDuration
 }
@@ -0,0 +1,302 @@
+// Package faultnet 模拟会出故障的链路：包装 net.Conn 和 net.Listener，按运行时可调整的参数
+// 注入时延、抖动、带宽限制、随机重置和部分写入，用于在一台机器上测试故障切换、选路和熔断。
+//
+// Latency 是数据从本端发出的单向时延，连接建立时等待一个往返。链路只作用于被它包装的一端：
+// 包装拨号时影响握手和本端发出的请求，包装监听器时影响对端收到的响应，两端都包装即可模拟完整的链路。
+// 每次写入都会同步等待时延，适合请求响应式的测试，不适合测量长肥管道的吞吐
+package faultnet
+
+import (
+	"context"
+	"demo1/proxy/connection"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrReset 连接被注入的故障重置
+	ErrReset = errors.New("faultnet: connection reset by fault injection")
+	// ErrPartialWrite 注入的部分写入，只有一部分数据被发出
+	ErrPartialWrite = errors.New("faultnet: partial write")
+)
+
+// Faults 链路的故障参数，零值表示正常的链路
+type Faults struct {
+	Latency          time.Duration // 单向时延
+	Jitter           time.Duration // 时延在 ±Jitter 之间均匀波动
+	Bandwidth        int64         // 每秒可以发出的字节数，0 表示不限
+	ResetRate        float64       // 每次读写时连接被重置的概率
+	PartialWriteRate float64       // 每次写入只发出一部分数据并返回 ErrPartialWrite 的概率
+}
+
+// Stats 链路的统计信息
+type Stats struct {
+	Conns         int    // 当前经过链路的连接数
+	Resets        uint64 // 注入的重置次数，包括 ResetAll
+	PartialWrites uint64 // 注入的部分写入次数
+	BytesWritten  uint64 // 经过链路发出的字节数
+}
+
+// DialFunc 与 net.Dialer.DialContext 签名相同的拨号函数
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Link 一条模拟链路，故障参数可以在运行时通过 Set 修改，对已经建立的连接立即生效
+type Link struct {
+	mu     sync.Mutex
+	faults Faults
+	conns  map[*Conn]struct{}
+	// 带宽整形：拨号和接受的连接分别计算下一次可以开始发送的时间
+	dialedFree, acceptedFree time.Time
+
+	resets        atomic.Uint64
+	partialWrites atomic.Uint64
+	bytesWritten  atomic.Uint64
+}
+
+// NewLink 创建链路
+func NewLink(faults Faults) *Link {
+	return &Link{faults: faults, conns: make(map[*Conn]struct{})}
+}
+
+// Set 替换链路的故障参数
+func (l *Link) Set(faults Faults) {
+	l.mu.Lock()
+	l.faults = faults
+	l.mu.Unlock()
+}
+
+// Update 在锁内修改部分故障参数
+func (l *Link) Update(fn func(*Faults)) {
+	l.mu.Lock()
+	fn(&l.faults)
+	l.mu.Unlock()
+}
+
+// Faults 返回当前的故障参数
+func (l *Link) Faults() Faults {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.faults
+}
+
+// Stats 返回链路的统计信息
+func (l *Link) Stats() Stats {
+	l.mu.Lock()
+	conns := len(l.conns)
+	l.mu.Unlock()
+	return Stats{
+		Conns:         conns,
+		Resets:        l.resets.Load(),
+		PartialWrites: l.partialWrites.Load(),
+		BytesWritten:  l.bytesWritten.Load(),
+	}
+}
+
+// ResetAll 立即重置所有经过链路的连接，模拟链路闪断
+func (l *Link) ResetAll() {
+	l.mu.Lock()
+	conns := make([]*Conn, 0, len(l.conns))
+	for c := range l.conns {
+		conns = append(conns, c)
+	}
+	l.mu.Unlock()
+	for _, c := range conns {
+		c.reset()
+	}
+}
+
+// Wrap 让一个已经建立的连接经过链路，连接按拨号方计算带宽
+func (l *Link) Wrap(conn net.Conn) net.Conn {
+	return l.wrap(conn, false)
+}
+
+func (l *Link) wrap(conn net.Conn, accepted bool) *Conn {
+	c := &Conn{Conn: conn, link: l, accepted: accepted}
+	l.mu.Lock()
+	l.conns[c] = struct{}{}
+	l.mu.Unlock()
+	return c
+}
+
+// Dial 返回经过链路的拨号函数，dial 为空时使用 net.Dialer。建立连接前等待一个往返时延，
+// 随机重置按 ResetRate 作用于拨号本身
+func (l *Link) Dial(dial DialFunc) DialFunc {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		faults := l.Faults()
+		if roll(faults.ResetRate) {
+			l.resets.Add(1)
+			return nil, &net.OpError{Op: "dial", Net: network, Err: ErrReset}
+		}
+		if err := sleep(ctx, delay(faults)+delay(faults)); err != nil {
+			return nil, err
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return l.wrap(conn, false), nil
+	}
+}
+
+// Factory 返回经过链路的连接池工厂函数
+func (l *Link) Factory(factory connection.Factory) connection.Factory {
+	return func() (net.Conn, error) {
+		faults := l.Faults()
+		if roll(faults.ResetRate) {
+			l.resets.Add(1)
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: ErrReset}
+		}
+		time.Sleep(delay(faults) + delay(faults))
+		conn, err := factory()
+		if err != nil {
+			return nil, err
+		}
+		return l.wrap(conn, false), nil
+	}
+}
+
+// Listener 返回接受的连接都经过链路的监听器，例如中继节点的代理监听器
+func (l *Link) Listener(listener net.Listener) net.Listener {
+	return &Listener{Listener: listener, link: l}
+}
+
+// pace 按带宽预留发送 n 字节的时间，返回需要等待的时长
+func (l *Link) pace(accepted bool, n int, bandwidth int64) time.Duration {
+	if bandwidth <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	free := &l.dialedFree
+	if accepted {
+		free = &l.acceptedFree
+	}
+	now := time.Now()
+	start := *free
+	if start.Before(now) {
+		start = now
+	}
+	*free = start.Add(time.Duration(int64(n) * int64(time.Second) / bandwidth))
+	return free.Sub(now)
+}
+
+// remove 连接关闭后不再由链路跟踪
+func (l *Link) remove(c *Conn) {
+	l.mu.Lock()
+	delete(l.conns, c)
+	l.mu.Unlock()
+}
+
+// Listener 接受的连接经过链路的监听器
+type Listener struct {
+	net.Listener
+	link *Link
+}
+
+// Accept 接受连接并包装
+func (ln *Listener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return ln.link.wrap(conn, true), nil
+}
+
+// Conn 经过链路的连接
+type Conn struct {
+	net.Conn
+	link     *Link
+	accepted bool
+
+	resetOnce sync.Once
+	closeOnce sync.Once
+}
+
+// Read 读取数据，按 ResetRate 随机重置连接
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && roll(c.link.Faults().ResetRate) {
+		c.reset()
+		return 0, &net.OpError{Op: "read", Net: "tcp", Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: ErrReset}
+	}
+	return n, err
+}
+
+// Write 等待时延和带宽后发出数据，按概率随机重置连接或只发出一部分数据
+func (c *Conn) Write(b []byte) (int, error) {
+	faults := c.link.Faults()
+	if roll(faults.ResetRate) {
+		c.reset()
+		return 0, &net.OpError{Op: "write", Net: "tcp", Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: ErrReset}
+	}
+	time.Sleep(delay(faults) + c.link.pace(c.accepted, len(b), faults.Bandwidth))
+
+	if len(b) > 1 && roll(faults.PartialWriteRate) {
+		c.link.partialWrites.Add(1)
+		n, err := c.Conn.Write(b[:1+rand.IntN(len(b)-1)])
+		c.link.bytesWritten.Add(uint64(n))
+		if err == nil {
+			err = &net.OpError{Op: "write", Net: "tcp", Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: ErrPartialWrite}
+		}
+		return n, err
+	}
+	n, err := c.Conn.Write(b)
+	c.link.bytesWritten.Add(uint64(n))
+	return n, err
+}
+
+// Close 关闭连接
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() { c.link.remove(c) })
+	return c.Conn.Close()
+}
+
+// reset 丢弃未发送的数据并关闭连接，TCP 连接会向对端发送 RST
+func (c *Conn) reset() {
+	c.resetOnce.Do(func() {
+		c.link.resets.Add(1)
+		if tcp, ok := c.Conn.(interface{ SetLinger(int) error }); ok {
+			tcp.SetLinger(0)
+		}
+		c.Close()
+	})
+}
+
+// delay 返回一次单向时延，抖动在 ±Jitter 之间均匀分布
+func delay(faults Faults) time.Duration {
+	d := faults.Latency
+	if faults.Jitter > 0 {
+		d += time.Duration(rand.Int64N(int64(2*faults.Jitter)+1)) - faults.Jitter
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// roll 以概率 p 返回 true
+func roll(p float64) bool {
+	return p > 0 && rand.Float64() < p
+}
+
+// sleep 等待 d，ctx 先结束时返回它的错误
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
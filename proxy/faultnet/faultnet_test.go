@@ -0,0 +1,84 @@
+package faultnet
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// pipe 返回一对经过回环 TCP 相连的连接，accepted 端经过链路
+func pipe(t *testing.T, link *Link) (dialed net.Conn, accepted net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	ln := link.Listener(listener)
+
+	done := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		done <- conn
+	}()
+	dialed, err = net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted = <-done
+	t.Cleanup(func() {
+		dialed.Close()
+		accepted.Close()
+	})
+	return dialed, accepted
+}
+
+func TestLatencyAndBandwidth(t *testing.T) {
+	link := NewLink(Faults{Latency: 30 * time.Millisecond})
+	dialed, accepted := pipe(t, link)
+
+	start := time.Now()
+	go accepted.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(dialed, buf); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("write arrived after %v, want at least the link latency", elapsed)
+	}
+
+	// 运行时修改参数：10 KB/s 发送 2 KB 至少需要 200ms
+	link.Set(Faults{Bandwidth: 10 << 10})
+	start = time.Now()
+	go accepted.Write(make([]byte, 2<<10))
+	if _, err := io.ReadFull(dialed, make([]byte, 2<<10)); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond {
+		t.Errorf("2 KB at 10 KB/s arrived after %v", elapsed)
+	}
+}
+
+func TestPartialWriteAndReset(t *testing.T) {
+	link := NewLink(Faults{PartialWriteRate: 1})
+	dialed, accepted := pipe(t, link)
+
+	n, err := accepted.Write([]byte("hello world"))
+	if !errors.Is(err, ErrPartialWrite) || n <= 0 || n >= len("hello world") {
+		t.Fatalf("partial write returned n=%d err=%v", n, err)
+	}
+	if _, err := io.ReadFull(dialed, make([]byte, n)); err != nil {
+		t.Fatal(err)
+	}
+
+	link.Set(Faults{})
+	link.ResetAll()
+	if _, err := dialed.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the peer to see the reset")
+	}
+	if stats := link.Stats(); stats.Resets != 1 || stats.PartialWrites != 1 || stats.Conns != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"context"
+	"demo1/proxy/accesslog"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// requestLogKey 入口在请求 context 中保存访问记录状态使用的键
+type requestLogKey struct{}
+
+// pathAttempt 一次通过覆盖网络发出的请求使用的数据包和转发路径
+type pathAttempt struct {
+	packetID uint32
+	path     []string
+}
+
+// requestLog 入口处理一个请求时收集的转发信息。
+// 失败重试和对冲请求会发出多次，最终只记录返回给客户端的那一次
+type requestLog struct {
+	mu       sync.Mutex
+	attempts map[*http.Response]pathAttempt
+	packetID uint32
+	route    string // 命中的路由名称，用于请求指标
+	hops     []string
+	timings  []accesslog.HopTiming
+}
+
+// withRequestLog 返回携带访问记录状态的请求
+func withRequestLog(r *http.Request) (*http.Request, *requestLog) {
+	state := &requestLog{attempts: make(map[*http.Response]pathAttempt)}
+	return r.WithContext(context.WithValue(r.Context(), requestLogKey{}, state)), state
+}
+
+// recordAttempt 记录某个响应对应的数据包和转发路径
+func recordAttempt(ctx context.Context, resp *http.Response, packetID uint32, path []string) {
+	state, _ := ctx.Value(requestLogKey{}).(*requestLog)
+	if state == nil {
+		return
+	}
+	state.mu.Lock()
+	state.attempts[resp] = pathAttempt{packetID: packetID, path: path}
+	state.mu.Unlock()
+}
+
+// finishRequestLog 记录最终响应的转发路径和各跳耗时，并从响应中删除各跳耗时头部
+func finishRequestLog(ctx context.Context, resp *http.Response) {
+	timings := accesslog.ParseHopTimings(resp.Header)
+	resp.Header.Del(accesslog.HopTimingHeader)
+
+	state, _ := ctx.Value(requestLogKey{}).(*requestLog)
+	if state == nil {
+		return
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	attempt := state.attempts[resp]
+	state.packetID, state.hops, state.timings = attempt.packetID, attempt.path, timings
+}
+
+// ensureRequestID 沿用客户端带来的合法请求 ID，否则生成新的 ID
+func ensureRequestID(r *http.Request) string {
+	id := r.Header.Get(accesslog.RequestIDHeader)
+	if !accesslog.ValidRequestID(id) {
+		id = accesslog.NewRequestID()
+		r.Header.Set(accesslog.RequestIDHeader, id)
+	}
+	return id
+}
+
+// countingReader 统计读取的字节数
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// accessWriter 记录写回客户端的状态码和字节数
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (a *accessWriter) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *accessWriter) Write(p []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	n, err := a.ResponseWriter.Write(p)
+	a.n += int64(n)
+	return n, err
+}
+
+func (a *accessWriter) Flush() {
+	if flusher, ok := a.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// logClientAccess 写入入口的访问记录
+func (api *Module1API) logClientAccess(r *http.Request, requestID string, start time.Time,
+	body *countingReader, w *accessWriter, state *requestLog) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	nextHop := "direct"
+	if len(state.hops) > 0 {
+		nextHop = state.hops[0]
+	}
+	observeRequest(accesslog.RoleIngress, state.route, nextHop, w.status, time.Since(start))
+	api.AccessLog.Log(&accesslog.Record{
+		Time:       start,
+		Node:       api.NodeID,
+		Role:       accesslog.RoleIngress,
+		RequestID:  requestID,
+		PacketID:   state.packetID,
+		Client:     clientIP(r),
+		Method:     r.Method,
+		Host:       r.Host,
+		Path:       r.URL.Path,
+		Status:     w.status,
+		BytesIn:    body.n,
+		BytesOut:   w.n,
+		DurationMs: accesslog.Milliseconds(time.Since(start)),
+		Hops:       state.hops,
+		HopTimings: state.timings,
+		Cache:      w.Header().Get(CacheStatusHeader),
+	})
+}
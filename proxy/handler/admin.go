@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"demo1/metrics"
+	"demo1/proxy/connection"
+	smux2 "demo1/proxy/smux_usage"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PoolInfo 到一个下一跳的连接池和会话池状态
+type PoolInfo struct {
+	NextHop string `json:"next_hop,omitempty"`
+	connection.Stats
+	Sessions smux2.PoolStats `json:"sessions"`
+}
+
+// AdminAPI 节点管理接口，用于查看和操作运行中节点的连接池、会话、流和路由表
+type AdminAPI struct {
+	ClientServerAPI *Module1API
+	ProxyNodeAPI    *Module2API
+	// Token 排空、关闭会话等修改节点状态的接口要求 Authorization: Bearer <Token>，为空时这些接口不可用
+	Token string
+}
+
+// NewAdminAPI 创建管理接口
+func NewAdminAPI(clientServerAPI *Module1API, proxyNodeAPI *Module2API) *AdminAPI {
+	return &AdminAPI{ClientServerAPI: clientServerAPI, ProxyNodeAPI: proxyNodeAPI}
+}
+
+// Register 在 gin 路由上挂载管理接口，所有接口位于 /admin 下，Prometheus 指标位于 /metrics
+func (a *AdminAPI) Register(router gin.IRouter) {
+	metricsNode.Store(a)
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	admin := router.Group("/admin")
+	admin.GET("/pools", a.listPools)
+	admin.GET("/sessions", a.listSessions)
+	admin.POST("/sessions/:id/drain", a.requireToken, a.drainSession)
+	admin.POST("/sessions/:id/close", a.requireToken, a.closeSession)
+	admin.GET("/streams", a.listStreams)
+	admin.GET("/routes", a.listRoutes)
+	admin.GET("/breakers", a.listBreakers)
+	admin.GET("/links", a.listLinks)
+	admin.GET("/tunnels", a.listTunnels)
+	admin.GET("/cache", a.cacheStats)
+	admin.GET("/backends", a.listBackends)
+}
+
+// requireToken 校验修改节点状态的请求携带的管理令牌。管理接口与探测任务共用监听地址，
+// 未配置令牌时拒绝所有修改操作
+func (a *AdminAPI) requireToken(c *gin.Context) {
+	if a.Token == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin token is not configured"})
+		return
+	}
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found || subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+		return
+	}
+	c.Next()
+}
+
+// listPools 列出到各个下一跳的连接池和会话池的统计信息，total 为所有下一跳的汇总
+func (a *AdminAPI) listPools(c *gin.Context) {
+	stats, total := a.ProxyNodeAPI.PoolStats()
+	pools := make([]PoolInfo, 0, len(stats))
+	for _, info := range stats {
+		pools = append(pools, info)
+	}
+
+	sort.Slice(pools, func(i, j int) bool { return pools[i].NextHop < pools[j].NextHop })
+	c.JSON(http.StatusOK, gin.H{"pools": pools, "total": total})
+}
+
+// listSessions 列出所有 SMUX 会话及其流数量和 RTT
+func (a *AdminAPI) listSessions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"sessions": a.ProxyNodeAPI.Sessions.Sessions()})
+}
+
+// listStreams 列出所有正在处理的流
+func (a *AdminAPI) listStreams(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"streams": a.ProxyNodeAPI.Sessions.Streams()})
+}
+
+// drainSession 排空指定会话
+func (a *AdminAPI) drainSession(c *gin.Context) {
+	a.sessionAction(c, a.ProxyNodeAPI.DrainSession, "draining")
+}
+
+// closeSession 立即关闭指定会话
+func (a *AdminAPI) closeSession(c *gin.Context) {
+	a.sessionAction(c, a.ProxyNodeAPI.Sessions.CloseSession, "closed")
+}
+
+// sessionAction 解析会话 ID 并执行操作
+func (a *AdminAPI) sessionAction(c *gin.Context, action func(id uint64) error, status string) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session id"})
+		return
+	}
+
+	err = action(id)
+	if errors.Is(err, ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "status": status})
+}
+
+// listRoutes 列出路由表、中继节点地址和当前故障的节点
+func (a *AdminAPI) listRoutes(c *gin.Context) {
+	routes := a.ClientServerAPI.Routes.Routes()
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Destination < routes[j].Destination })
+	c.JSON(http.StatusOK, gin.H{
+		"routes":    routes,
+		"peers":     a.ClientServerAPI.Routes.Peers(),
+		"down_hops": a.ClientServerAPI.Health.DownHops(),
+	})
+}
+
+// listBreakers 列出每个下一跳的熔断器状态及最近的状态变化
+func (a *AdminAPI) listBreakers(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"breakers": a.ProxyNodeAPI.Breakers.Snapshot(),
+		"events":   a.ProxyNodeAPI.Breakers.Events(),
+	})
+}
+
+// listLinks 列出每条链路测得的 RTT、吞吐量和新会话使用的 SMUX 参数
+func (a *AdminAPI) listLinks(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"links": a.ProxyNodeAPI.Links.Links()})
+}
+
+// listTunnels 列出注册到本节点的反向隧道
+func (a *AdminAPI) listTunnels(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"tunnels": a.ProxyNodeAPI.Tunnels()})
+}
+
+// cacheStats 返回入口缓存的命中、未命中次数和各层的占用
+func (a *AdminAPI) cacheStats(c *gin.Context) {
+	stats, enabled := a.ClientServerAPI.CacheStats()
+	c.JSON(http.StatusOK, gin.H{"enabled": enabled, "stats": stats})
+}
+
+// listBackends 列出出口节点的后端池及每个源站的健康状态和活跃请求数
+func (a *AdminAPI) listBackends(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"backends": a.ProxyNodeAPI.Backends.Status()})
+}
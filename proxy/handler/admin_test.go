@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"demo1/schedule"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminTokenProtectsSessionActions(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	post := func(token, auth string) int {
+		admin := NewAdminAPI(nil, NewModule2API(nil))
+		admin.Token = token
+		router := gin.New()
+		admin.Register(router)
+		r := httptest.NewRequest(http.MethodPost, "/admin/sessions/42/close", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// 未配置令牌时修改操作不可用，令牌错误时拒绝，令牌正确时才执行操作
+	if code := post("", "Bearer anything"); code != http.StatusForbidden {
+		t.Errorf("without a configured token: got %d", code)
+	}
+	if code := post("secret", ""); code != http.StatusUnauthorized {
+		t.Errorf("without credentials: got %d", code)
+	}
+	if code := post("secret", "Bearer wrong"); code != http.StatusUnauthorized {
+		t.Errorf("with a wrong token: got %d", code)
+	}
+	if code := post("secret", "Bearer secret"); code != http.StatusNotFound {
+		t.Errorf("with the right token: got %d, want 404 for an unknown session", code)
+	}
+}
+
+func TestMetricsIncludeSchedule(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	NewAdminAPI(NewModule1API(nil), NewModule2API(nil)).Register(router)
+	(&schedule.Evaluate{}).Record("10.0.0.1")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range []string{
+		`overlay_schedule_delay{node="10.0.0.1"} 0`,
+		`overlay_schedule_normalized_cpu{node="10.0.0.1",stat="mean"} 0`,
+		`overlay_schedule_virtual_queue{node="10.0.0.1",stat="var"} 0`,
+		`overlay_schedule_drift_plus_penalty{node="10.0.0.1"} 0`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("missing %q in /metrics", line)
+		}
+	}
+}
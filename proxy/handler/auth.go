@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"demo1/proxy/config"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoCredentials 请求没有携带该认证方式的凭据
+var ErrNoCredentials = errors.New("no credentials")
+
+// AnonymousIdentity 未认证请求在授权规则中使用的身份，规则中的 "*" 不匹配匿名请求
+const AnonymousIdentity = "anonymous"
+
+// Authenticator 认证器，识别请求的客户端身份
+type Authenticator interface {
+	// Authenticate 返回客户端身份；请求没有携带该方式的凭据时返回 ErrNoCredentials
+	Authenticate(r *http.Request) (string, error)
+}
+
+// APIKeyAuthenticator 通过静态 API Key 认证
+type APIKeyAuthenticator struct {
+	Header string            // 携带 API Key 的头部
+	Keys   map[string]string // API Key -> 身份
+}
+
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (string, error) {
+	key := r.Header.Get(a.Header)
+	if key == "" {
+		return "", ErrNoCredentials
+	}
+	for candidate, identity := range a.Keys {
+		if hmac.Equal([]byte(candidate), []byte(key)) {
+			r.Header.Del(a.Header)
+			return identity, nil
+		}
+	}
+	return "", errors.New("unknown API key")
+}
+
+// HMACTokenAuthenticator 通过 HMAC 签名令牌认证，令牌放在 Authorization: Bearer 头部，
+// 格式为 <身份>.<过期时间 Unix 秒>.<HMAC-SHA256 十六进制签名>
+type HMACTokenAuthenticator struct {
+	Secret []byte
+}
+
+// SignToken 为身份签发一个在 expiry 过期的令牌
+func SignToken(secret []byte, identity string, expiry time.Time) string {
+	payload := identity + "." + strconv.FormatInt(expiry.Unix(), 10)
+	return payload + "." + tokenSignature(secret, payload)
+}
+
+// tokenSignature 计算令牌载荷的签名
+func tokenSignature(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *HMACTokenAuthenticator) Authenticate(r *http.Request) (string, error) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return "", ErrNoCredentials
+	}
+
+	sigAt := strings.LastIndex(token, ".")
+	if sigAt < 0 {
+		return "", errors.New("malformed token")
+	}
+	payload, signature := token[:sigAt], token[sigAt+1:]
+	if !hmac.Equal([]byte(signature), []byte(tokenSignature(a.Secret, payload))) {
+		return "", errors.New("invalid token signature")
+	}
+
+	expiryAt := strings.LastIndex(payload, ".")
+	if expiryAt < 0 {
+		return "", errors.New("malformed token")
+	}
+	expiry, err := strconv.ParseInt(payload[expiryAt+1:], 10, 64)
+	if err != nil {
+		return "", errors.New("malformed token expiry")
+	}
+	if time.Now().Unix() > expiry {
+		return "", errors.New("token expired")
+	}
+
+	r.Header.Del("Authorization")
+	return payload[:expiryAt], nil
+}
+
+// ClientCertAuthenticator 通过 mTLS 客户端证书认证，身份为证书的 Common Name。
+// 证书链由 TLS 握手时的 ClientCAs 校验
+type ClientCertAuthenticator struct{}
+
+func (a *ClientCertAuthenticator) Authenticate(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", ErrNoCredentials
+	}
+	identity := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if identity == "" {
+		return "", errors.New("client certificate has no common name")
+	}
+	return identity, nil
+}
+
+// AccessError 访问被拒绝，Status 为返回给客户端的状态码
+type AccessError struct {
+	Status int
+	Reason string
+}
+
+func (e *AccessError) Error() string {
+	return e.Reason
+}
+
+// AccessControl 入口访问控制：依次尝试各个认证器，再按授权规则检查目的地
+type AccessControl struct {
+	Authenticators []Authenticator
+	Rules          []*config.AccessRule
+	Required       bool // 为 true 时拒绝未认证的请求
+}
+
+// NewAccessControl 根据认证配置创建访问控制
+func NewAccessControl(cfg *config.AuthConfig) *AccessControl {
+	ac := &AccessControl{Rules: cfg.Rules, Required: cfg.Required}
+	if cfg.ClientCA != "" {
+		ac.Authenticators = append(ac.Authenticators, &ClientCertAuthenticator{})
+	}
+	if len(cfg.APIKeys) > 0 {
+		header := cfg.APIKeyHeader
+		if header == "" {
+			header = "X-API-Key"
+		}
+		ac.Authenticators = append(ac.Authenticators, &APIKeyAuthenticator{Header: header, Keys: cfg.APIKeys})
+	}
+	if cfg.HMACSecret != "" {
+		ac.Authenticators = append(ac.Authenticators, &HMACTokenAuthenticator{Secret: []byte(cfg.HMACSecret)})
+	}
+	return ac
+}
+
+// Check 认证并授权请求，返回客户端身份；被拒绝时返回 *AccessError
+func (a *AccessControl) Check(r *http.Request, route string) (string, error) {
+	identity := ""
+	for _, authenticator := range a.Authenticators {
+		id, err := authenticator.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return "", &AccessError{Status: http.StatusUnauthorized, Reason: err.Error()}
+		}
+		identity = id
+		break
+	}
+
+	if identity == "" {
+		if a.Required {
+			return "", &AccessError{Status: http.StatusUnauthorized, Reason: "no credentials"}
+		}
+		// 未认证的请求按匿名身份授权，但不作为身份向后传递
+		return "", a.authorize(AnonymousIdentity, r.Host, route)
+	}
+
+	if err := a.authorize(identity, r.Host, route); err != nil {
+		return identity, err
+	}
+	return identity, nil
+}
+
+// authorize 检查身份能否访问目的主机和路由
+func (a *AccessControl) authorize(identity, host, route string) error {
+	if len(a.Rules) == 0 {
+		return nil
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	for _, rule := range a.Rules {
+		if rule.Identity != identity && (rule.Identity != "*" || identity == AnonymousIdentity) {
+			continue
+		}
+		if len(rule.Hosts) > 0 && !matchAny(rule.Hosts, host) {
+			continue
+		}
+		if len(rule.Routes) > 0 && !matchAny(rule.Routes, route) {
+			continue
+		}
+		return nil
+	}
+	return &AccessError{
+		Status: http.StatusForbidden,
+		Reason: fmt.Sprintf("identity %s may not reach host %s via route %s", identity, host, route),
+	}
+}
+
+// matchAny 判断值是否匹配任一模式，模式支持 "*" 和 "*.example.com"
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == value {
+			return true
+		}
+		if suffix, found := strings.CutPrefix(pattern, "*"); found && strings.HasSuffix(value, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"demo1/proxy/config"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAuthenticators(t *testing.T) {
+	request := func(header, value string) *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, "http://api.example.com/", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		return r
+	}
+
+	// API Key：匹配时返回身份并删除头部，不向后传递密钥
+	keys := &APIKeyAuthenticator{Header: "X-API-Key", Keys: map[string]string{"secret": "alice"}}
+	r := request("X-API-Key", "secret")
+	if identity, err := keys.Authenticate(r); err != nil || identity != "alice" || r.Header.Get("X-API-Key") != "" {
+		t.Errorf("API key: got %q, %v, header %q", identity, err, r.Header.Get("X-API-Key"))
+	}
+	if _, err := keys.Authenticate(request("X-API-Key", "wrong")); err == nil || errors.Is(err, ErrNoCredentials) {
+		t.Errorf("expected an unknown API key to fail, got %v", err)
+	}
+	if _, err := keys.Authenticate(request("", "")); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("expected ErrNoCredentials without a key, got %v", err)
+	}
+
+	// HMAC 令牌：签名错误和过期的令牌被拒绝
+	secret := []byte("hmac-secret")
+	tokens := &HMACTokenAuthenticator{Secret: secret}
+	valid := SignToken(secret, "bob", time.Now().Add(time.Minute))
+	if identity, err := tokens.Authenticate(request("Authorization", "Bearer "+valid)); err != nil || identity != "bob" {
+		t.Errorf("HMAC token: got %q, %v", identity, err)
+	}
+	forged := SignToken([]byte("other"), "bob", time.Now().Add(time.Minute))
+	if _, err := tokens.Authenticate(request("Authorization", "Bearer "+forged)); err == nil {
+		t.Error("expected a token signed with another secret to fail")
+	}
+	expired := SignToken(secret, "bob", time.Now().Add(-time.Minute))
+	if _, err := tokens.Authenticate(request("Authorization", "Bearer "+expired)); err == nil {
+		t.Error("expected an expired token to fail")
+	}
+
+	// 客户端证书：身份为已校验证书链的 Common Name
+	certs := &ClientCertAuthenticator{}
+	r = request("", "")
+	if _, err := certs.Authenticate(r); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("expected ErrNoCredentials without TLS, got %v", err)
+	}
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "carol"}}}}}
+	if identity, err := certs.Authenticate(r); err != nil || identity != "carol" {
+		t.Errorf("client certificate: got %q, %v", identity, err)
+	}
+}
+
+func TestAccessControl(t *testing.T) {
+	ac := NewAccessControl(&config.AuthConfig{
+		APIKeys: map[string]string{"k1": "alice"},
+		Rules: []*config.AccessRule{
+			{Identity: "alice", Hosts: []string{"*.example.com"}},
+			{Identity: AnonymousIdentity, Routes: []string{"public"}},
+		},
+	})
+	request := func(host, key string) *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		return r
+	}
+
+	if identity, err := ac.Check(request("api.example.com", "k1"), "default"); err != nil || identity != "alice" {
+		t.Errorf("expected alice to reach api.example.com, got %q, %v", identity, err)
+	}
+	var accessErr *AccessError
+	if _, err := ac.Check(request("other.org", "k1"), "default"); !errors.As(err, &accessErr) || accessErr.Status != http.StatusForbidden {
+		t.Errorf("expected 403 for a host outside the rules, got %v", err)
+	}
+	if _, err := ac.Check(request("other.org", "bad"), "default"); !errors.As(err, &accessErr) || accessErr.Status != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a bad key, got %v", err)
+	}
+	if identity, err := ac.Check(request("other.org", ""), "public"); err != nil || identity != "" {
+		t.Errorf("expected anonymous access to the public route, got %q, %v", identity, err)
+	}
+
+	ac.Required = true
+	if _, err := ac.Check(request("other.org", ""), "public"); !errors.As(err, &accessErr) || accessErr.Status != http.StatusUnauthorized {
+		t.Errorf("expected 401 without credentials when authentication is required, got %v", err)
+	}
+}
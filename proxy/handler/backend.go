@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"demo1/proxy/backend"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// forwardToBackend: 出口节点把请求转发到后端池中选出的源站，保留原始的 Host 头部。
+// 连接失败或源站返回 5xx 计为一次失败，用于被动摘除；启用会话保持时在响应中设置 Cookie
+func forwardToBackend(pool *backend.Pool, r *http.Request) (*http.Response, error) {
+	origin, setCookie, err := pool.Pick(r)
+	if err != nil {
+		return nil, fmt.Errorf("backend %s: %w", pool.Config.Name, err)
+	}
+
+	req, err := http.NewRequest(r.Method, pool.URL(origin, r.RequestURI), r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = r.Header
+	req.Host = r.Host
+	req.ContentLength = r.ContentLength
+
+	done := pool.Acquire(origin)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		done(true, err)
+		return nil, fmt.Errorf("backend %s: origin %s: %w", pool.Config.Name, origin.Addr, err)
+	}
+	var failure error
+	if resp.StatusCode >= http.StatusInternalServerError {
+		failure = fmt.Errorf("origin returned %s", resp.Status)
+	}
+	if setCookie {
+		resp.Header.Add("Set-Cookie", pool.StickyCookie(origin).String())
+	}
+	// 响应体读完关闭后源站才算处理完请求，最少连接算法依赖这个计数
+	resp.Body = &releaseBody{ReadCloser: resp.Body, done: func() { done(failure != nil, failure) }}
+	return resp, nil
+}
+
+// releaseBody 在响应体关闭时释放源站的活跃计数
+type releaseBody struct {
+	io.ReadCloser
+	done func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen 下一跳的熔断器处于打开状态，请求被直接拒绝
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // 关闭：正常放行请求
+	BreakerOpen                         // 打开：直接拒绝请求，等待冷却
+	BreakerHalfOpen                     // 半开：放行一个试探请求
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// MarshalText 以字符串形式输出状态，便于在 JSON 中查看
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// BreakerSettings 熔断器参数
+type BreakerSettings struct {
+	ErrorRate   float64       // 统计窗口内错误率达到该值时打开熔断器
+	MinRequests int           // 统计窗口内请求数少于该值时不做判断
+	Window      time.Duration // 统计窗口长度
+	CoolDown    time.Duration // 打开后经过该时间进入半开状态
+}
+
+// DefaultBreakerSettings 默认熔断器参数
+var DefaultBreakerSettings = BreakerSettings{
+	ErrorRate:   0.5,
+	MinRequests: 10,
+	Window:      10 * time.Second,
+	CoolDown:    5 * time.Second,
+}
+
+// BreakerEvent 熔断器状态变化事件
+type BreakerEvent struct {
+	Hop  string       `json:"hop"`
+	From BreakerState `json:"from"`
+	To   BreakerState `json:"to"`
+	Time time.Time    `json:"time"`
+}
+
+// BreakerStatus 熔断器当前状态
+type BreakerStatus struct {
+	Hop       string       `json:"hop"`
+	State     BreakerState `json:"state"`
+	Requests  int          `json:"requests"` // 当前统计窗口内的请求数
+	Failures  int          `json:"failures"` // 当前统计窗口内的失败数
+	ChangedAt time.Time    `json:"changed_at"`
+}
+
+// circuitBreaker 单个下一跳的熔断器
+type circuitBreaker struct {
+	state       BreakerState
+	requests    int
+	failures    int
+	windowStart time.Time
+	changedAt   time.Time
+	trial       bool // 半开状态下是否已有试探请求在进行
+}
+
+// maxBreakerEvents 保留的最近状态变化事件数
+const maxBreakerEvents = 100
+
+// BreakerSet 按下一跳地址维护的熔断器集合
+type BreakerSet struct {
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+	events   []BreakerEvent
+	settings BreakerSettings
+
+	// OnStateChange 熔断器状态变化时调用，可为空
+	OnStateChange func(BreakerEvent)
+}
+
+// NewBreakerSet 创建熔断器集合
+func NewBreakerSet(settings BreakerSettings) *BreakerSet {
+	return &BreakerSet{
+		breakers: make(map[string]*circuitBreaker),
+		settings: settings,
+	}
+}
+
+// get 返回下一跳的熔断器，不存在则创建，调用方需持有锁
+func (s *BreakerSet) get(hop string) *circuitBreaker {
+	b, exists := s.breakers[hop]
+	if !exists {
+		now := time.Now()
+		b = &circuitBreaker{windowStart: now, changedAt: now}
+		s.breakers[hop] = b
+	}
+	return b
+}
+
+// Allow 判断是否放行发往下一跳的请求，放行后调用方必须调用 Record 上报结果
+func (s *BreakerSet) Allow(hop string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.get(hop)
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.changedAt) < s.settings.CoolDown {
+			return ErrBreakerOpen
+		}
+		s.transition(hop, b, BreakerHalfOpen)
+		b.trial = true
+		return nil
+	case BreakerHalfOpen:
+		if b.trial {
+			return ErrBreakerOpen
+		}
+		b.trial = true
+		return nil
+	}
+	return nil
+}
+
+// Record 上报一次请求结果
+func (s *BreakerSet) Record(hop string, success bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.get(hop)
+	switch b.state {
+	case BreakerHalfOpen:
+		b.trial = false
+		if success {
+			s.transition(hop, b, BreakerClosed)
+		} else {
+			s.transition(hop, b, BreakerOpen)
+		}
+	case BreakerClosed:
+		if time.Since(b.windowStart) > s.settings.Window {
+			b.requests, b.failures, b.windowStart = 0, 0, time.Now()
+		}
+		b.requests++
+		if !success {
+			b.failures++
+		}
+		if b.requests >= s.settings.MinRequests &&
+			float64(b.failures)/float64(b.requests) >= s.settings.ErrorRate {
+			s.transition(hop, b, BreakerOpen)
+		}
+	}
+}
+
+// Release 放弃一次已放行请求的结果（例如请求被调用方取消），不计入统计
+func (s *BreakerSet) Release(hop string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get(hop).trial = false
+}
+
+// transition 切换熔断器状态并记录事件，调用方需持有锁
+func (s *BreakerSet) transition(hop string, b *circuitBreaker, to BreakerState) {
+	event := BreakerEvent{Hop: hop, From: b.state, To: to, Time: time.Now()}
+	b.state = to
+	b.changedAt = event.Time
+	b.requests, b.failures, b.windowStart = 0, 0, event.Time
+
+	s.events = append(s.events, event)
+	if len(s.events) > maxBreakerEvents {
+		s.events = s.events[len(s.events)-maxBreakerEvents:]
+	}
+	log.Printf("Circuit breaker for %s: %s -> %s", hop, event.From, event.To)
+	if s.OnStateChange != nil {
+		go s.OnStateChange(event)
+	}
+}
+
+// Snapshot 返回所有熔断器的当前状态
+func (s *BreakerSet) Snapshot() []BreakerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]BreakerStatus, 0, len(s.breakers))
+	for hop, b := range s.breakers {
+		statuses = append(statuses, BreakerStatus{
+			Hop:       hop,
+			State:     b.state,
+			Requests:  b.requests,
+			Failures:  b.failures,
+			ChangedAt: b.changedAt,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Hop < statuses[j].Hop })
+	return statuses
+}
+
+// Events 返回最近的状态变化事件
+func (s *BreakerSet) Events() []BreakerEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]BreakerEvent(nil), s.events...)
+}
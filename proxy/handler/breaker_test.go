@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerStateChanges(t *testing.T) {
+	const hop = "10.0.0.1"
+	s := NewBreakerSet(BreakerSettings{ErrorRate: 0.5, MinRequests: 4, Window: time.Minute, CoolDown: 20 * time.Millisecond})
+	state := func() BreakerState {
+		return s.Snapshot()[0].State
+	}
+
+	// 请求数不足时不打开，错误率达到阈值后打开并拒绝请求
+	for _, success := range []bool{true, false, false} {
+		if err := s.Allow(hop); err != nil {
+			t.Fatal(err)
+		}
+		s.Record(hop, success)
+	}
+	if state() != BreakerClosed {
+		t.Fatalf("expected closed below MinRequests, got %s", state())
+	}
+	s.Allow(hop)
+	s.Record(hop, false)
+	if state() != BreakerOpen {
+		t.Fatalf("expected open at the error rate, got %s", state())
+	}
+	if err := s.Allow(hop); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("expected ErrBreakerOpen while cooling down, got %v", err)
+	}
+
+	// 冷却后放行一个试探请求，试探进行中拒绝其他请求，失败后重新打开
+	time.Sleep(30 * time.Millisecond)
+	if err := s.Allow(hop); err != nil {
+		t.Fatalf("expected a trial request after the cool down, got %v", err)
+	}
+	if state() != BreakerHalfOpen {
+		t.Fatalf("expected half-open, got %s", state())
+	}
+	if err := s.Allow(hop); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("expected a second request to be rejected during the trial, got %v", err)
+	}
+	s.Record(hop, false)
+	if state() != BreakerOpen {
+		t.Fatalf("expected a failed trial to reopen, got %s", state())
+	}
+
+	// 被取消的试探不计入结果，下一个请求可以重新试探，成功后关闭
+	time.Sleep(30 * time.Millisecond)
+	s.Allow(hop)
+	s.Release(hop)
+	if err := s.Allow(hop); err != nil {
+		t.Fatalf("expected a new trial after a released one, got %v", err)
+	}
+	s.Record(hop, true)
+	if state() != BreakerClosed {
+		t.Fatalf("expected a successful trial to close, got %s", state())
+	}
+
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	events := s.Events()
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), events)
+	}
+	for i, event := range events {
+		if event.To != want[i] {
+			t.Errorf("event %d went to %s, want %s", i, event.To, want[i])
+		}
+	}
+}
+
+func TestModule2APIsHaveOwnBreakers(t *testing.T) {
+	const hop = "10.0.0.1"
+	a, b := NewModule2API(nil), NewModule2API(nil)
+	if a.Links == b.Links {
+		t.Error("expected each node to tune its own links")
+	}
+
+	// 同一进程中的一个节点熔断下一跳，不影响其他节点
+	for i := 0; i < DefaultBreakerSettings.MinRequests; i++ {
+		a.Breakers.Allow(hop)
+		a.Breakers.Record(hop, false)
+	}
+	if err := a.Breakers.Allow(hop); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("expected the failing node's breaker to open, got %v", err)
+	}
+	if err := b.Breakers.Allow(hop); err != nil {
+		t.Errorf("expected another node's breaker to stay closed, got %v", err)
+	}
+}
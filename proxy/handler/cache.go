@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"bytes"
+	"demo1/proxy/cache"
+	"demo1/proxy/config"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CacheStatusHeader 入口在响应中用该头部标明缓存结果：HIT、REVALIDATED 或 MISS
+const CacheStatusHeader = "X-Overlay-Cache"
+
+// serveWithCache: 新鲜的缓存直接返回；过期但带校验器的缓存通过覆盖网络发送条件请求重新验证；
+// 未命中时转发请求，并在把响应写回客户端的同时保存可缓存的响应
+func (api *Module1API) serveWithCache(w http.ResponseWriter, r *http.Request, route *config.Route) {
+	entry := api.Cache.Lookup(r)
+	if entry != nil && entry.Fresh(time.Now()) && !cache.RequestNeedsRevalidation(r) {
+		api.Cache.RecordHit()
+		api.writeCached(w, r, entry, "HIT")
+		return
+	}
+
+	// 客户端没有自己的校验器时，用缓存的校验器向源站确认
+	outbound := r
+	if entry != nil && entry.HasValidator() && !hasConditional(r) {
+		outbound = r.Clone(r.Context())
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			outbound.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			outbound.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := api.fetch(route, outbound)
+	if err != nil {
+		fetchFailed(w, route, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if outbound != r && resp.StatusCode == http.StatusNotModified {
+		api.Cache.RecordHit()
+		api.writeCached(w, r, api.Cache.Revalidate(entry, resp), "REVALIDATED")
+		return
+	}
+	api.Cache.RecordMiss()
+
+	// 保存的是源站的原始头部，不包含入口追加的头部
+	origin := *resp
+	origin.Header = resp.Header.Clone()
+
+	addVia(resp.Header, resp.ProtoMajor, resp.ProtoMinor, api.NodeID)
+	resp.Header.Set(CacheStatusHeader, "MISS")
+	capture := &captureReader{Reader: resp.Body, limit: api.Cache.MaxEntryBytes()}
+	resp.Body = io.NopCloser(capture)
+	writeResponse(w, resp)
+
+	if resp.StatusCode == http.StatusNotModified {
+		// 客户端自己的条件请求得到 304，说明客户端的副本仍然有效；
+		// 客户端的校验器与缓存的条目一致时，条目同样有效，用 304 的头部刷新它
+		if entry != nil && validatesEntry(r, entry) {
+			api.Cache.Revalidate(entry, &origin)
+		}
+		return
+	}
+
+	// 源站返回了不可缓存的新响应时，旧的条目不再有效
+	stored := capture.complete() && api.Cache.Store(r, &origin, capture.buf.Bytes())
+	if !stored && entry != nil {
+		api.Cache.Remove(r)
+	}
+}
+
+// validatesEntry 判断请求中客户端自己的校验器是否与缓存的条目一致
+func validatesEntry(r *http.Request, entry *cache.Entry) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, entry.Header.Get("ETag"))
+	}
+	lastModified := entry.Header.Get("Last-Modified")
+	return lastModified != "" && r.Header.Get("If-Modified-Since") == lastModified
+}
+
+// writeCached: 把缓存的响应写回客户端，客户端的校验器与缓存匹配时返回 304
+func (api *Module1API) writeCached(w http.ResponseWriter, r *http.Request, entry *cache.Entry, status string) {
+	resp := entry.Response(r)
+	defer resp.Body.Close()
+
+	addVia(resp.Header, resp.ProtoMajor, resp.ProtoMinor, api.NodeID)
+	resp.Header.Set(CacheStatusHeader, status)
+	if etagMatches(r.Header.Get("If-None-Match"), resp.Header.Get("ETag")) {
+		for key, values := range resp.Header {
+			w.Header()[key] = values
+		}
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeResponse(w, resp)
+}
+
+// hasConditional 判断请求是否带有客户端自己的校验器
+func hasConditional(r *http.Request) bool {
+	return r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != ""
+}
+
+// etagMatches 按弱比较判断 If-None-Match 是否匹配 ETag
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// captureReader 在转发响应体的同时保存一份副本，超过 limit 后停止保存
+type captureReader struct {
+	io.Reader
+	buf      bytes.Buffer
+	limit    int64
+	overflow bool
+	eof      bool
+}
+
+func (c *captureReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	if n > 0 && !c.overflow {
+		if int64(c.buf.Len()+n) > c.limit {
+			c.overflow = true
+			c.buf = bytes.Buffer{}
+		} else {
+			c.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		c.eof = true
+	}
+	return n, err
+}
+
+// complete 判断响应体是否完整读完且没有超过大小上限
+func (c *captureReader) complete() bool {
+	return c.eof && !c.overflow
+}
+
+// CacheStats 返回入口缓存的统计信息，未启用缓存时返回 false
+func (api *Module1API) CacheStats() (cache.Stats, bool) {
+	if api.Cache == nil {
+		return cache.Stats{}, false
+	}
+	return api.Cache.Stats(), true
+}
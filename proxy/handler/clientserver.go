@@ -1,9 +1,20 @@
 package handler
 
 import (
+	"context"
+	"crypto/tls"
+	"demo1/proxy/accesslog"
+	"demo1/proxy/cache"
+	"demo1/proxy/config"
+	"demo1/proxy/connection"
+	"demo1/proxy/tracing"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -20,84 +31,318 @@ var httpClient = &http.Client{
 // Module1API: 模块1的对外接口
 type Module1API struct {
 	ProxyNodeAPI *Module2API // 模块 2 的接口实例
+	NodeID       string      // 本节点 ID，写入 Via 头部
+
+	Routes  *config.RouteTable // 目的主机到转发路径的路由表
+	Health  *HopHealth         // 中继节点健康状态
+	Limiter *RateLimiter       // 入口限流器，为空表示不限流
+	Auth    *AccessControl     // 入口认证和授权，为空表示不做访问控制
+
+	Forwarded *ForwardedPolicy  // 客户端转发头部的信任策略，为空表示删除所有客户端带来的转发头部
+	Cache     *cache.Cache      // 入口响应缓存，为空表示不缓存
+	AccessLog *accesslog.Logger // 访问日志，为空表示不记录
+	Tracer    *tracing.Tracer   // 分布式追踪，为空表示不创建 span
+
+	TLSConfig    *tls.Config   // 不为空时入口使用 HTTPS，配置了 ClientCAs 时支持 mTLS
+	DrainTimeout time.Duration // 关闭时等待进行中的请求结束的最长时间
+	// DirectFetch 没有路由的请求是否由入口直接访问目的主机。默认关闭，这类请求返回 502，
+	// 否则任何客户端都可以把入口当作开放代理访问任意主机
+	DirectFetch bool
+
+	latency *latencyTracker // 每条路径的响应时延，用于计算对冲延迟
 }
 
 // NewModule1API: 创建模块1实例
 func NewModule1API(proxyNodeAPI *Module2API) *Module1API {
-	return &Module1API{ProxyNodeAPI: proxyNodeAPI}
+	api := &Module1API{
+		ProxyNodeAPI: proxyNodeAPI,
+		Routes:       config.NewRouteTable(),
+		DrainTimeout: 30 * time.Second,
+		latency:      newLatencyTracker(),
+	}
+	api.Health = NewHopHealth(dialProbe(func(hop string) string {
+		return api.Routes.HopAddr(hop)
+	}, 3*time.Second))
+	return api
+}
+
+// StartClientServer: 启动HTTP服务器监听客户端请求，ctx 结束时优雅关闭
+func (api *Module1API) StartClientServer(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start client server: %w", err)
+	}
+	return api.ServeClient(ctx, listener)
 }
 
-// StartClientServer: 启动HTTP服务器监听客户端请求
-func (api *Module1API) StartClientServer(addr string) error {
-	http.HandleFunc("/", api.handleClientRequest)
-	fmt.Printf("ClientServer: Listening on %s\n", addr)
-	return http.ListenAndServe(addr, nil)
+// ServeClient: 在给定的监听器上处理客户端请求。ctx 结束后停止接受新的请求，
+// 等待进行中的请求结束（最长 DrainTimeout）后返回
+func (api *Module1API) ServeClient(ctx context.Context, listener net.Listener) error {
+	// 对故障节点做健康检查
+	go api.Health.Run(ctx)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", api.handleClientRequest)
+	server := &http.Server{Handler: mux, TLSConfig: api.TLSConfig}
+
+	errCh := make(chan error, 1)
+	go func() {
+		fmt.Printf("ClientServer: Listening on %s\n", listener.Addr())
+		if api.TLSConfig != nil {
+			errCh <- server.ServeTLS(listener, "", "")
+		} else {
+			errCh <- server.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	fmt.Println("ClientServer: Draining requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), api.DrainTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		fmt.Println("ClientServer: Drain timeout, closing remaining requests")
+		server.Close()
+	}
+	fmt.Println("ClientServer: Stopped")
+	return nil
 }
 
 // handleClientRequest: 处理来自客户端的HTTP请求
 func (api *Module1API) handleClientRequest(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("Received request: %s %s\n", r.Method, r.URL.String())
+	// 每个请求写一条访问记录，请求 ID 随请求传给中继节点和源站
+	start := time.Now()
+	requestID := ensureRequestID(r)
+	w.Header().Set(accesslog.RequestIDHeader, requestID)
+	body := &countingReader{ReadCloser: r.Body}
+	r.Body = body
+	access := &accessWriter{ResponseWriter: w}
+	w = access
+	r, state := withRequestLog(r)
+	defer api.logClientAccess(r, requestID, start, body, access, state)
 
-	// 确定下一跳
-	nextHop, isProxy := api.determineNextHop(r)
-	if nextHop == "" {
-		http.Error(w, "No next hop found", http.StatusBadRequest)
-		return
-	}
+	// 入口 span，沿用客户端带来的追踪上下文
+	ctx, span := api.Tracer.Start(tracing.Extract(r.Context(), r.Header), "ingress", tracing.KindServer)
+	span.SetAttribute("overlay.node", api.NodeID)
+	span.SetAttribute("overlay.request_id", requestID)
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.host", r.Host)
+	span.SetAttribute("http.target", r.URL.RequestURI())
+	r = r.WithContext(ctx)
+	defer func() { finishServerSpan(span, access.status) }()
 
-	if isProxy {
-		// 如果下一跳是代理节点，交给模块2处理
-		responseBytes, err := api.forwardToProxy(nextHop, r)
+	// 确定转发路径
+	route := api.determineNextHop(r)
+	state.route = api.routeName(route)
+
+	// 入口认证和授权，拒绝的请求记录原因
+	if api.Auth != nil {
+		identity, err := api.Auth.Check(r, api.routeName(route))
 		if err != nil {
-			http.Error(w, "Failed to forward request to proxy", http.StatusInternalServerError)
+			status := http.StatusForbidden
+			var accessErr *AccessError
+			if errors.As(err, &accessErr) {
+				status = accessErr.Status
+			}
+			log.Printf("Access denied: client=%s identity=%q host=%s route=%s reason=%v",
+				clientIP(r), identity, r.Host, api.routeName(route), err)
+			http.Error(w, http.StatusText(status), status)
 			return
 		}
+		if identity != "" {
+			r = WithIdentity(r, identity)
+		}
+	}
 
-		// 返回模块2的响应给客户端
-		w.Write(responseBytes)
-	} else {
-		// 如果下一跳是目标服务器，直接处理
-		resp, err := api.forwardToServer(r, nextHop)
-		if err != nil {
-			http.Error(w, "Failed to forward request to server", http.StatusInternalServerError)
+	// 入口限流：超过请求速率返回 429，请求体和响应体按带宽限制整形
+	if api.Limiter != nil {
+		upload, download, retryAfter, ok := api.Limiter.Admit(r, api.routeName(route))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
 			return
 		}
-		defer resp.Body.Close() // 确保响应体关闭以释放资源
+		if len(upload) > 0 {
+			r.Body = &shapedReader{ReadCloser: r.Body, ctx: r.Context(), buckets: upload}
+			w = &shapedWriter{ResponseWriter: w, ctx: r.Context(), buckets: download}
+		}
+	}
+
+	// 记录真实的客户端，并在 Via 中追加本节点
+	api.Forwarded.setForwardedHeaders(r)
+	addVia(r.Header, r.ProtoMajor, r.ProtoMinor, api.NodeID)
+
+	// 可缓存的请求交给入口缓存处理
+	if api.Cache != nil && cache.RequestCacheable(r) {
+		api.serveWithCache(w, r, route)
+		return
+	}
+
+	resp, err := api.fetch(route, r)
+	if err != nil {
+		fetchFailed(w, route, err)
+		return
+	}
+	defer resp.Body.Close() // 确保响应体关闭以释放资源
+
+	// 修改资源的请求成功后，使该资源的缓存失效
+	if api.Cache != nil && !isSafeMethod(r.Method) && resp.StatusCode < http.StatusBadRequest {
+		api.Cache.Remove(r)
+	}
+
+	// 返回响应给客户端
+	addVia(resp.Header, resp.ProtoMajor, resp.ProtoMinor, api.NodeID)
+	writeResponse(w, resp)
+}
 
-		// 设置状态码
-		w.WriteHeader(resp.StatusCode)
+// errNoRoute 请求的目的主机没有路由，并且入口不允许直接访问
+var errNoRoute = errors.New("no route to destination")
 
-		// 将响应体数据写入客户端响应
-		_, copyErr := io.Copy(w, resp.Body)
-		if copyErr != nil {
-			fmt.Printf("Failed to copy response body: %v\n", copyErr)
+// fetch: 交给模块2通过覆盖网络转发；没有路由时只有开启 DirectFetch 才直接请求目标服务器
+func (api *Module1API) fetch(route *config.Route, r *http.Request) (*http.Response, error) {
+	if route == nil || len(route.Paths()) == 0 {
+		if !api.DirectFetch {
+			return nil, errNoRoute
 		}
+		target := "http://" + r.Host + r.URL.RequestURI()
+		return traceOrigin(r.Context(), r.Header, target, func() (*http.Response, error) {
+			return api.forwardToServer(r, target)
+		})
 	}
+	resp, err := api.forwardToProxy(route, r)
+	if err == nil {
+		finishRequestLog(r.Context(), resp)
+	}
+	return resp, err
 }
 
-// determineNextHop: 确定下一跳（代理节点或目标服务器）
-func (api *Module1API) determineNextHop(r *http.Request) (string, bool) {
-	// 示例实现：根据路径判断下一跳
-	if r.URL.Path == "/proxy" {
-		return "localhost:9000", true // 返回代理节点地址
+// fetchFailed: 转发失败时返回错误响应
+func fetchFailed(w http.ResponseWriter, route *config.Route, err error) {
+	if errors.Is(err, errNoRoute) {
+		http.Error(w, "No route to destination", http.StatusBadGateway)
+		return
 	}
-	return "http://example.com", false // 返回目标服务器地址
+	if route == nil || len(route.Paths()) == 0 {
+		http.Error(w, "Failed to forward request to server", http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Failed to forward request to proxy: %v\n", err)
+	if errors.Is(err, connection.ErrWaitTimeout) {
+		http.Error(w, "Timed out waiting for a connection to next hop", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, "Failed to forward request to proxy", http.StatusBadGateway)
 }
 
-// forwardToProxy: 将请求转发到代理节点（模块2）
-func (api *Module1API) forwardToProxy(proxyAddr string, r *http.Request) ([]byte, error) {
-	// 将请求转换为适合模块2的格式
-	headers := make(map[string][]string)
-	for key, values := range r.Header {
-		headers[key] = values
+// writeResponse: 将响应头、状态码和响应体写回客户端
+func writeResponse(w http.ResponseWriter, resp *http.Response) {
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
 	}
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read request body: %w", err)
+
+	// 设置状态码
+	w.WriteHeader(resp.StatusCode)
+
+	// 将响应体数据写入客户端响应
+	_, copyErr := io.Copy(w, resp.Body)
+	if copyErr != nil {
+		fmt.Printf("Failed to copy response body: %v\n", copyErr)
 	}
+}
+
+// determineNextHop: 根据请求的目的主机查找路由表，返回 nil 表示没有路由
+func (api *Module1API) determineNextHop(r *http.Request) *config.Route {
+	return api.Routes.Lookup(r.Host)
+}
+
+// routeName: 返回请求命中的路由在路由表中的名称，没有路由时返回固定的名称。
+// 名称用于指标标签、授权和限流，不能取自客户端发来的 Host，否则标签和限流桶的数量不受控制
+func (api *Module1API) routeName(route *config.Route) string {
+	if route != nil {
+		return route.Destination
+	}
+	if api.DirectFetch {
+		return routeDirect
+	}
+	return routeUnrouted
+}
+
+// forwardToProxy: 将请求转发到代理节点（模块2）。
+// 节点故障时将其标记为不可用，安全方法的请求会在备用路径上重试
+func (api *Module1API) forwardToProxy(route *config.Route, r *http.Request) (*http.Response, error) {
+	retriable := isRetriable(r)
+
+	paths := api.Health.orderPaths(route.Paths())
+	tried := make(map[string]bool, len(paths))
+	var lastErr error
+	for i, path := range paths {
+		if tried[pathKey(path)] {
+			continue
+		}
+		tried[pathKey(path)] = true
+
+		var resp *http.Response
+		var err error
+		if alternate := disjointPath(path, paths[i+1:]); i == 0 && alternate != nil && shouldHedge(route, r) {
+			// 高优先级的幂等请求同时使用两条不相交的路径
+			tried[pathKey(alternate)] = true
+			resp, err = api.sendHedged(route, path, alternate, r)
+		} else {
+			resp, err = api.sendOnPath(r.Context(), path, r)
+		}
+		if err == nil {
+			if failedHop(resp) == "" {
+				return resp, nil
+			}
+			// 下游节点报告了故障节点
+			resp.Body.Close()
+			err = reportedHopError(resp)
+		}
+
+		var hopErr *HopError
+		if !errors.As(err, &hopErr) {
+			return nil, err
+		}
+		api.Health.reportHopFailure(err)
+		lastErr = err
+
+		// 熔断器拒绝的请求尚未发出，任何方法都可以换路径重试
+		if (!retriable && !errors.Is(err, ErrBreakerOpen)) || r.Context().Err() != nil {
+			break
+		}
+		fmt.Printf("Retrying %s %s on backup path: %v\n", r.Method, r.URL.String(), err)
+	}
+	return nil, lastErr
+}
+
+// sendOnPath: 构造包头，通过模块2把请求发往路径上的第一跳，并记录该路径的响应时延
+func (api *Module1API) sendOnPath(ctx context.Context, path []string, r *http.Request) (*http.Response, error) {
+	hopList := make([]uint32, 0, len(path))
+	for _, hop := range path {
+		ip, err := config.IPToUint32(hop)
+		if err != nil {
+			return nil, err
+		}
+		hopList = append(hopList, ip)
+	}
+
+	packet := config.NewPacket(hopList)
+	packet.Priority = requestPriority(r)
 
 	// 调用模块2的接口
-	return api.ProxyNodeAPI.SendRequestToProxy(proxyAddr, r.Method, r.URL.String(), headers, body)
+	start := time.Now()
+	resp, err := api.ProxyNodeAPI.SendRequestToProxy(ctx, path[0], packet, r)
+	if err == nil {
+		api.latency.observe(pathKey(path), time.Since(start))
+		recordAttempt(ctx, resp, packet.PacketID, path)
+	}
+	return resp, err
 }
 
 // forwardToServer: 转发HTTP请求到目标服务器
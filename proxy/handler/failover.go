@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FailedHopHeader 中继节点无法到达下一跳时，在 502 响应中用该头部告知上游是哪个节点故障；
+// 排空中的节点拒绝新的流时，在 503 响应中用该头部标明自己
+const FailedHopHeader = "X-Overlay-Failed-Hop"
+
+// ErrHopDraining 下游节点正在排空，拒绝了新的流
+var ErrHopDraining = errors.New("hop is draining")
+
+// HopError 表示到达某个中继节点失败（拨号失败、SMUX 会话断开或超时）
+type HopError struct {
+	Hop string // 故障节点 IP
+	Err error
+}
+
+func (e *HopError) Error() string {
+	return fmt.Sprintf("hop %s failed: %v", e.Hop, e.Err)
+}
+
+func (e *HopError) Unwrap() error {
+	return e.Err
+}
+
+// HopHealth 记录中继节点的健康状态，被标记为故障的节点在健康检查成功之前不参与选路
+type HopHealth struct {
+	mu   sync.RWMutex
+	down map[string]time.Time // 节点 IP -> 被标记为故障的时间
+
+	// Probe 健康检查函数，返回 nil 表示节点恢复
+	Probe func(hop string) error
+	// Interval 健康检查的间隔
+	Interval time.Duration
+}
+
+// NewHopHealth 创建节点健康状态表
+func NewHopHealth(probe func(hop string) error) *HopHealth {
+	return &HopHealth{
+		down:     make(map[string]time.Time),
+		Probe:    probe,
+		Interval: 5 * time.Second,
+	}
+}
+
+// MarkDown 将节点标记为故障
+func (h *HopHealth) MarkDown(hop string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, exists := h.down[hop]; !exists {
+		h.down[hop] = time.Now()
+		log.Printf("Hop %s marked down", hop)
+	}
+}
+
+// MarkUp 将节点恢复为可用
+func (h *HopHealth) MarkUp(hop string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, exists := h.down[hop]; exists {
+		delete(h.down, hop)
+		log.Printf("Hop %s marked up", hop)
+	}
+}
+
+// IsDown 判断节点是否处于故障状态
+func (h *HopHealth) IsDown(hop string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, exists := h.down[hop]
+	return exists
+}
+
+// PathUp 判断路径上的所有节点是否都可用
+func (h *HopHealth) PathUp(path []string) bool {
+	for _, hop := range path {
+		if h.IsDown(hop) {
+			return false
+		}
+	}
+	return true
+}
+
+// DownHops 返回当前所有故障节点
+func (h *HopHealth) DownHops() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	hops := make([]string, 0, len(h.down))
+	for hop := range h.down {
+		hops = append(hops, hop)
+	}
+	sort.Strings(hops)
+	return hops
+}
+
+// Run 周期性地对故障节点做健康检查，检查成功的节点重新参与选路，ctx 结束时返回
+func (h *HopHealth) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.checkDownHops()
+		}
+	}
+}
+
+// checkDownHops 对所有故障节点执行一次健康检查
+func (h *HopHealth) checkDownHops() {
+	if h.Probe == nil {
+		return
+	}
+	for _, hop := range h.DownHops() {
+		if err := h.Probe(hop); err != nil {
+			continue
+		}
+		h.MarkUp(hop)
+	}
+}
+
+// orderPaths 返回按优先级排列的候选路径：健康路径在前，全部故障时仍按原顺序尝试
+func (h *HopHealth) orderPaths(paths [][]string) [][]string {
+	healthy := make([][]string, 0, len(paths))
+	for _, path := range paths {
+		if h.PathUp(path) {
+			healthy = append(healthy, path)
+		}
+	}
+	if len(healthy) == 0 {
+		return paths
+	}
+	return healthy
+}
+
+// dialProbe 返回一个通过 TCP 拨号检查节点是否可达的健康检查函数
+func dialProbe(addrOf func(hop string) string, timeout time.Duration) func(hop string) error {
+	return func(hop string) error {
+		conn, err := net.DialTimeout("tcp", addrOf(hop), timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// reportHopFailure 将出错的节点标记为故障；熔断器打开导致的拒绝不影响节点健康状态
+func (h *HopHealth) reportHopFailure(err error) {
+	var hopErr *HopError
+	if errors.As(err, &hopErr) && !errors.Is(err, ErrBreakerOpen) {
+		h.MarkDown(hopErr.Hop)
+	}
+}
+
+// isSafeMethod 判断请求方法是否为安全方法，只有安全方法的请求会在备用路径上重试
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// isRetriable 判断请求失败后能否在另一条路径上重放
+func isRetriable(r *http.Request) bool {
+	return isSafeMethod(r.Method) && (r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0)
+}
+
+// failedHop 从下游返回的响应中取出故障节点，没有故障节点时返回空字符串
+func failedHop(resp *http.Response) string {
+	if resp.StatusCode != http.StatusBadGateway && resp.StatusCode != http.StatusServiceUnavailable {
+		return ""
+	}
+	return resp.Header.Get(FailedHopHeader)
+}
+
+// reportedHopError 把下游报告的故障节点转换为 *HopError，排空中的节点返回的 503 包装 ErrHopDraining
+func reportedHopError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		return &HopError{Hop: failedHop(resp), Err: ErrHopDraining}
+	}
+	return &HopError{Hop: failedHop(resp), Err: fmt.Errorf("reported by upstream relay")}
+}
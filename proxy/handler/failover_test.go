@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsRetriable(t *testing.T) {
+	cases := []struct {
+		method string
+		body   string
+		want   bool
+	}{
+		{http.MethodGet, "", true},
+		{http.MethodHead, "", true},
+		{http.MethodGet, "payload", false},
+		{http.MethodPost, "", false},
+		{http.MethodPut, "", false},
+	}
+	for _, c := range cases {
+		r, _ := http.NewRequest(c.method, "http://example.com/", strings.NewReader(c.body))
+		if got := isRetriable(r); got != c.want {
+			t.Errorf("isRetriable(%s with body %q) = %v, want %v", c.method, c.body, got, c.want)
+		}
+	}
+}
+
+func TestOrderPaths(t *testing.T) {
+	h := NewHopHealth(nil)
+	paths := [][]string{{"10.0.0.1", "10.0.0.9"}, {"10.0.0.2", "10.0.0.9"}, {"10.0.0.3"}}
+
+	if got := h.orderPaths(paths); !reflect.DeepEqual(got, paths) {
+		t.Errorf("expected all paths while every hop is up, got %v", got)
+	}
+
+	// 故障节点所在的路径不参与选路
+	h.MarkDown("10.0.0.1")
+	if got := h.orderPaths(paths); !reflect.DeepEqual(got, paths[1:]) {
+		t.Errorf("expected the paths avoiding the down hop, got %v", got)
+	}
+
+	// 所有路径都有故障节点时仍按原顺序尝试
+	h.MarkDown("10.0.0.9")
+	h.MarkDown("10.0.0.3")
+	if got := h.orderPaths(paths); !reflect.DeepEqual(got, paths) {
+		t.Errorf("expected the original order when every path is down, got %v", got)
+	}
+
+	h.MarkUp("10.0.0.1")
+	h.MarkUp("10.0.0.9")
+	if got := h.orderPaths(paths); !reflect.DeepEqual(got, paths[:2]) {
+		t.Errorf("expected recovered paths to be used again, got %v", got)
+	}
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// 入口设置的转发头部
+const (
+	headerForwarded       = "Forwarded"
+	headerXForwardedFor   = "X-Forwarded-For"
+	headerXForwardedProto = "X-Forwarded-Proto"
+	headerXForwardedHost  = "X-Forwarded-Host"
+	headerXRealIP         = "X-Real-IP"
+	headerVia             = "Via"
+)
+
+// ForwardedPolicy 入口对客户端带来的转发头部的处理策略：
+// 来自可信代理的请求保留原有的转发头部并在后面追加，其他请求的转发头部全部删除，防止客户端伪造来源 IP
+type ForwardedPolicy struct {
+	TrustedProxies []*net.IPNet
+}
+
+// NewForwardedPolicy 根据可信代理列表创建策略，列表项可以是 IP 或 CIDR，"*" 表示信任所有客户端
+func NewForwardedPolicy(trusted []string) (*ForwardedPolicy, error) {
+	policy := &ForwardedPolicy{}
+	for _, entry := range trusted {
+		if entry == "*" {
+			_, all4, _ := net.ParseCIDR("0.0.0.0/0")
+			_, all6, _ := net.ParseCIDR("::/0")
+			policy.TrustedProxies = append(policy.TrustedProxies, all4, all6)
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			policy.TrustedProxies = append(policy.TrustedProxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		policy.TrustedProxies = append(policy.TrustedProxies, network)
+	}
+	return policy, nil
+}
+
+// Trusted 判断直接连接入口的地址是否为可信代理，策略为空时不信任任何地址
+func (p *ForwardedPolicy) Trusted(ip string) bool {
+	if p == nil {
+		return false
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range p.TrustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// setForwardedHeaders 入口记录真实的客户端：按策略保留或删除已有的转发头部，
+// 然后追加 Forwarded 和 X-Forwarded-For，设置 X-Forwarded-Proto
+func (p *ForwardedPolicy) setForwardedHeaders(r *http.Request) {
+	client := clientIP(r)
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	if !p.Trusted(client) {
+		for _, name := range []string{headerForwarded, headerXForwardedFor, headerXForwardedProto, headerXForwardedHost, headerXRealIP} {
+			r.Header.Del(name)
+		}
+	}
+
+	r.Header.Add(headerForwarded, fmt.Sprintf("for=%s;host=%q;proto=%s", forwardedNode(client), r.Host, proto))
+	chain := append(r.Header.Values(headerXForwardedFor), client)
+	r.Header.Set(headerXForwardedFor, strings.Join(chain, ", "))
+	if r.Header.Get(headerXForwardedProto) == "" {
+		r.Header.Set(headerXForwardedProto, proto)
+	}
+}
+
+// forwardedNode 按 RFC 7239 格式化 Forwarded 中的节点，IPv6 地址需要加方括号和引号
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return fmt.Sprintf("\"[%s]\"", ip)
+	}
+	return ip
+}
+
+// addVia 在请求或响应头中追加本节点的 Via 记录，经过的每个节点依次追加，形成完整的转发轨迹
+func addVia(header http.Header, protoMajor, protoMinor int, nodeID string) {
+	if nodeID == "" {
+		return
+	}
+	header.Add(headerVia, fmt.Sprintf("%d.%d %s", protoMajor, protoMinor, nodeID))
+}
@@ -0,0 +1,233 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"demo1/proxy/config"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PriorityHeader 客户端通过该头部指定请求优先级，对应包头中的 Priority 字段
+const PriorityHeader = "X-Overlay-Priority"
+
+const (
+	// latencyWindow 每条路径保留的最近响应时延样本数
+	latencyWindow = 200
+	// minLatencySamples 样本数少于该值时使用路由配置的默认对冲延迟
+	minLatencySamples = 20
+	// maxHedgeBody 对冲请求需要在内存中缓存请求体，超过该大小的请求不做对冲
+	maxHedgeBody = 1 << 20
+)
+
+// latencyTracker 记录每条路径最近的响应时延（从发出请求到收到响应头）
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples map[string][]time.Duration
+	next    map[string]int
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{
+		samples: make(map[string][]time.Duration),
+		next:    make(map[string]int),
+	}
+}
+
+// observe 记录一次时延样本，超过窗口大小时覆盖最旧的样本
+func (t *latencyTracker) observe(key string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	samples := t.samples[key]
+	if len(samples) < latencyWindow {
+		t.samples[key] = append(samples, d)
+		return
+	}
+	samples[t.next[key]] = d
+	t.next[key] = (t.next[key] + 1) % latencyWindow
+}
+
+// percentile 返回路径时延的分位数，样本不足时第二个返回值为 false
+func (t *latencyTracker) percentile(key string, p float64) (time.Duration, bool) {
+	t.mu.Lock()
+	samples := append([]time.Duration(nil), t.samples[key]...)
+	t.mu.Unlock()
+
+	if len(samples) < minLatencySamples {
+		return 0, false
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	index := int(p * float64(len(samples)-1))
+	return samples[index], true
+}
+
+// pathKey 路径在时延统计中的键
+func pathKey(path []string) string {
+	return strings.Join(path, ",")
+}
+
+// requestPriority 读取客户端指定的优先级，缺省为 0
+func requestPriority(r *http.Request) uint8 {
+	priority, err := strconv.ParseUint(r.Header.Get(PriorityHeader), 10, 8)
+	if err != nil {
+		return 0
+	}
+	return uint8(priority)
+}
+
+// isIdempotent 判断请求方法是否幂等，只有幂等请求可以同时在两条路径上发送
+func isIdempotent(method string) bool {
+	return isSafeMethod(method) || method == http.MethodPut || method == http.MethodDelete
+}
+
+// disjointPath 返回与 primary 没有公共节点的第一条候选路径，没有则返回 nil
+func disjointPath(primary []string, candidates [][]string) []string {
+	used := make(map[string]bool, len(primary))
+	for _, hop := range primary {
+		used[hop] = true
+	}
+next:
+	for _, path := range candidates {
+		for _, hop := range path {
+			if used[hop] {
+				continue next
+			}
+		}
+		return path
+	}
+	return nil
+}
+
+// hedgeDelay 计算发出对冲请求前等待的时间：主路径时延的分位数，样本不足时使用默认值
+func (api *Module1API) hedgeDelay(policy *config.HedgePolicy, primary []string) time.Duration {
+	percentile := policy.Percentile
+	if percentile <= 0 || percentile >= 1 {
+		percentile = 0.95
+	}
+	delay, ok := api.latency.percentile(pathKey(primary), percentile)
+	if !ok {
+		delay = time.Duration(policy.DefaultDelayMs) * time.Millisecond
+	}
+	if min := time.Duration(policy.MinDelayMs) * time.Millisecond; delay < min {
+		delay = min
+	}
+	return delay
+}
+
+// shouldHedge 判断请求是否满足路由的对冲条件
+func shouldHedge(route *config.Route, r *http.Request) bool {
+	if route.Hedge == nil || !isIdempotent(r.Method) {
+		return false
+	}
+	if r.ContentLength < 0 || r.ContentLength > maxHedgeBody {
+		return false
+	}
+	return requestPriority(r) > route.Hedge.PriorityThreshold
+}
+
+// hedgeResult 一次路径尝试的结果
+type hedgeResult struct {
+	index int // 0 为主路径，1 为对冲路径
+	resp  *http.Response
+	err   error
+}
+
+// sendHedged: 先在主路径上发送请求，若在对冲延迟内没有响应，再在不相交的备用路径上发送同一请求。
+// 返回先到达的成功响应并取消另一条路径上的请求；两条路径都失败时优先返回主路径的错误
+func (api *Module1API) sendHedged(route *config.Route, primary, alternate []string, r *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	paths := [][]string{primary, alternate}
+	cancels := make([]context.CancelFunc, 0, len(paths))
+	results := make(chan hedgeResult, len(paths))
+	launch := func() {
+		index := len(cancels)
+		ctx, cancel := context.WithCancel(r.Context())
+		cancels = append(cancels, cancel)
+		req := r.Clone(ctx)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		go func() {
+			resp, err := api.sendOnPath(ctx, paths[index], req)
+			results <- hedgeResult{index: index, resp: resp, err: err}
+		}()
+	}
+
+	launch()
+	timer := time.NewTimer(api.hedgeDelay(route.Hedge, primary))
+	defer timer.Stop()
+
+	errs := make([]error, len(paths))
+	for pending := 1; pending > 0; {
+		select {
+		case <-timer.C:
+			if len(cancels) < len(paths) {
+				launch()
+				pending++
+			}
+
+		case res := <-results:
+			pending--
+			if res.err == nil && failedHop(res.resp) == "" {
+				// 取消仍在进行中的另一条路径，其结果到达后直接丢弃
+				for i, cancel := range cancels {
+					if i != res.index {
+						cancel()
+					}
+				}
+				go discardHedgeResults(results, pending)
+				res.resp.Body = &cancelBody{ReadCloser: res.resp.Body, cancel: cancels[res.index]}
+				return res.resp, nil
+			}
+
+			if res.err == nil {
+				res.resp.Body.Close()
+				res.err = reportedHopError(res.resp)
+			}
+			cancels[res.index]()
+			api.Health.reportHopFailure(res.err)
+			errs[res.index] = res.err
+
+			// 任一路径失败后立即启用另一条路径，不再等待对冲延迟
+			if len(cancels) < len(paths) {
+				launch()
+				pending++
+			}
+		}
+	}
+
+	if errs[0] != nil {
+		return nil, errs[0]
+	}
+	return nil, errs[1]
+}
+
+// discardHedgeResults 释放落后路径的结果
+func discardHedgeResults(results <-chan hedgeResult, pending int) {
+	for ; pending > 0; pending-- {
+		res := <-results
+		if res.resp != nil {
+			res.resp.Body.Close()
+		}
+	}
+}
+
+// cancelBody 关闭响应体时同时取消对应路径的 context
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"demo1/proxy/config"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShouldHedge(t *testing.T) {
+	route := &config.Route{Hedge: &config.HedgePolicy{PriorityThreshold: 3}}
+	request := func(method, priority, body string) *http.Request {
+		r, _ := http.NewRequest(method, "http://example.com/", strings.NewReader(body))
+		if priority != "" {
+			r.Header.Set(PriorityHeader, priority)
+		}
+		return r
+	}
+
+	cases := []struct {
+		name string
+		r    *http.Request
+		want bool
+	}{
+		{"high priority GET", request(http.MethodGet, "5", ""), true},
+		{"high priority PUT", request(http.MethodPut, "5", "x"), true},
+		{"priority at the threshold", request(http.MethodGet, "3", ""), false},
+		{"no priority", request(http.MethodGet, "", ""), false},
+		{"non-idempotent POST", request(http.MethodPost, "5", "x"), false},
+	}
+	for _, c := range cases {
+		if got := shouldHedge(route, c.r); got != c.want {
+			t.Errorf("%s: shouldHedge = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	// 请求体过大或长度未知时需要缓存的请求体不确定，不做对冲
+	large := request(http.MethodPut, "5", "x")
+	large.ContentLength = maxHedgeBody + 1
+	unknown := request(http.MethodPut, "5", "x")
+	unknown.ContentLength = -1
+	if shouldHedge(route, large) || shouldHedge(route, unknown) {
+		t.Error("expected no hedging for large or unknown-length bodies")
+	}
+	if shouldHedge(&config.Route{}, request(http.MethodGet, "9", "")) {
+		t.Error("expected no hedging without a hedge policy")
+	}
+}
+
+func TestDisjointPath(t *testing.T) {
+	primary := []string{"10.0.0.1", "10.0.0.2"}
+	candidates := [][]string{
+		{"10.0.0.3", "10.0.0.2"},
+		{"10.0.0.4", "10.0.0.5"},
+		{"10.0.0.6"},
+	}
+	if got := disjointPath(primary, candidates); !reflect.DeepEqual(got, candidates[1]) {
+		t.Errorf("expected the first path sharing no hop with the primary, got %v", got)
+	}
+	if got := disjointPath(primary, candidates[:1]); got != nil {
+		t.Errorf("expected nil when every candidate shares a hop, got %v", got)
+	}
+}
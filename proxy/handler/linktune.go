@@ -0,0 +1,164 @@
+package handler
+
+import (
+	smux2 "demo1/proxy/smux_usage"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xtaci/smux"
+)
+
+// 吞吐量估计的参数
+const (
+	// minThroughputSample 小于该大小的传输主要反映延迟而不是带宽，不计入吞吐量
+	minThroughputSample = 256 << 10
+	// linkEstimateWeight 新样本在 RTT 和吞吐量滑动平均中的权重
+	linkEstimateWeight = 0.3
+)
+
+// LinkInfo 一条链路的 SMUX 参数和测得的状态
+type LinkInfo struct {
+	Peer       string           `json:"peer"`
+	RTT        time.Duration    `json:"rtt"`        // 建立会话时测得 RTT 的滑动平均
+	Throughput float64          `json:"throughput"` // 大流量传输测得吞吐量的滑动平均，字节每秒
+	Config     smux2.LinkConfig `json:"config"`     // 下一个会话将使用的参数
+}
+
+// linkEstimate 一条链路的测量结果
+type linkEstimate struct {
+	rtt        time.Duration
+	throughput float64
+}
+
+// LinkTuner 按对端节点选择 SMUX 会话参数。开启自动调整时记录每条链路的 RTT 和吞吐量，
+// 新会话的窗口按带宽时延积计算。窗口限制吞吐量时测得的吞吐量约为窗口除以 RTT，
+// 下一个会话的窗口是它的两倍，因此窗口会逐步增长到链路的实际带宽
+type LinkTuner struct {
+	mu       sync.Mutex
+	defaults smux2.LinkConfig
+	peers    map[string]smux2.LinkConfig // 按对端 IP 覆盖默认参数
+	links    map[string]*linkEstimate
+}
+
+// NewLinkTuner 创建链路参数选择器，peers 中的参数覆盖 defaults 中对应的字段
+func NewLinkTuner(defaults smux2.LinkConfig, peers map[string]smux2.LinkConfig) *LinkTuner {
+	t := &LinkTuner{links: make(map[string]*linkEstimate)}
+	t.Configure(defaults, peers)
+	return t
+}
+
+// Configure 替换默认参数和各个对端的参数，之后建立的会话生效
+func (t *LinkTuner) Configure(defaults smux2.LinkConfig, peers map[string]smux2.LinkConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.defaults = defaults
+	t.peers = make(map[string]smux2.LinkConfig, len(peers))
+	for peer, c := range peers {
+		t.peers[peer] = c
+	}
+}
+
+// SessionConfig 返回到 peer 的新会话使用的 SMUX 配置，conn 为会话的底层连接，
+// 开启自动调整时用于读取 RTT。参数不合法时记录日志并使用默认配置
+func (t *LinkTuner) SessionConfig(peer string, conn net.Conn) *smux.Config {
+	if rtt := tcpRTT(conn); rtt > 0 {
+		t.observeRTT(peer, rtt)
+	}
+	lc := t.linkConfig(peer)
+	config, err := lc.SMUXConfig()
+	if err != nil {
+		log.Printf("SMUX config for %s: %v, using defaults", peer, err)
+		return smux.DefaultConfig()
+	}
+	return config
+}
+
+// ObserveTransfer 记录一次到 peer 的传输，用于估计链路的吞吐量
+func (t *LinkTuner) ObserveTransfer(peer string, bytes int64, elapsed time.Duration) {
+	if bytes < minThroughputSample || elapsed <= 0 {
+		return
+	}
+	throughput := float64(bytes) / elapsed.Seconds()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.estimate(peer)
+	if e.throughput == 0 {
+		e.throughput = throughput
+	} else {
+		e.throughput += linkEstimateWeight * (throughput - e.throughput)
+	}
+}
+
+// Links 返回所有测量过或单独配置过的链路
+func (t *LinkTuner) Links() []LinkInfo {
+	t.mu.Lock()
+	peers := make([]string, 0, len(t.links)+len(t.peers))
+	for peer := range t.links {
+		peers = append(peers, peer)
+	}
+	for peer := range t.peers {
+		if _, exists := t.links[peer]; !exists {
+			peers = append(peers, peer)
+		}
+	}
+	t.mu.Unlock()
+
+	infos := make([]LinkInfo, 0, len(peers))
+	for _, peer := range peers {
+		info := LinkInfo{Peer: peer, Config: t.linkConfig(peer)}
+		t.mu.Lock()
+		if e, exists := t.links[peer]; exists {
+			info.RTT, info.Throughput = e.rtt, e.throughput
+		}
+		t.mu.Unlock()
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Peer < infos[j].Peer })
+	return infos
+}
+
+// linkConfig 返回到 peer 的参数，开启自动调整时按测量结果填充未设置的字段
+func (t *LinkTuner) linkConfig(peer string) smux2.LinkConfig {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lc := t.defaults.Merge(t.peers[peer])
+	if e, exists := t.links[peer]; exists {
+		lc = lc.Tune(e.rtt, e.throughput)
+	}
+	return lc
+}
+
+// observeRTT 记录建立会话时测得的 RTT
+func (t *LinkTuner) observeRTT(peer string, rtt time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.estimate(peer)
+	if e.rtt == 0 {
+		e.rtt = rtt
+	} else {
+		e.rtt += time.Duration(linkEstimateWeight * float64(rtt-e.rtt))
+	}
+}
+
+// estimate 返回 peer 的测量结果，不存在时创建，调用方持有锁
+func (t *LinkTuner) estimate(peer string) *linkEstimate {
+	e, exists := t.links[peer]
+	if !exists {
+		e = &linkEstimate{}
+		t.links[peer] = e
+	}
+	return e
+}
+
+// peerIP 返回连接对端的 IP，用于按对端选择入向会话的参数
+func peerIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"demo1/proxy/config"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// LoopPathHeader 中继节点丢弃成环或超过跳数限制的数据包时，在 508 响应中用该头部返回完整转发路径
+const LoopPathHeader = "X-Overlay-Loop-Path"
+
+// LoopError 表示数据包的转发路径成环，或者剩余跳数已经用完
+type LoopError struct {
+	Node   string   // 发现问题的节点
+	Path   []string // 数据包的完整转发路径
+	Reason string
+}
+
+func (e *LoopError) Error() string {
+	return fmt.Sprintf("%s at %s, path %s", e.Reason, e.Node, strings.Join(e.Path, " -> "))
+}
+
+// checkLoop 检查收到的数据包：剩余跳数为 0 或者转发路径中多次出现本节点时返回 *LoopError
+func (api *Module2API) checkLoop(packet *config.Packet) *LoopError {
+	path := packet.HopIPs()
+	if packet.TTL == 0 {
+		return &LoopError{Node: api.NodeIP, Path: path, Reason: "hop limit exceeded"}
+	}
+
+	visits := 0
+	for _, hop := range path {
+		if hop == api.NodeIP {
+			visits++
+		}
+	}
+	if visits > 1 {
+		return &LoopError{Node: api.NodeIP, Path: path, Reason: "forwarding loop detected"}
+	}
+	return nil
+}
+
+// writeLoopResponse 向上游返回 508，经过的每个节点原样转发，最终由入口返回给客户端
+func writeLoopResponse(w io.Writer, err *LoopError) {
+	resp := errorResponse(http.StatusLoopDetected, err)
+	resp.Header.Set(LoopPathHeader, strings.Join(err.Path, ","))
+	resp.Write(w)
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"demo1/proxy/config"
+	"testing"
+)
+
+func TestCheckLoop(t *testing.T) {
+	api := &Module2API{NodeIP: "10.0.0.2"}
+	hops := func(ips ...string) []uint32 {
+		list := make([]uint32, 0, len(ips))
+		for _, ip := range ips {
+			hop, err := config.IPToUint32(ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			list = append(list, hop)
+		}
+		return list
+	}
+
+	packet := config.NewPacket(hops("10.0.0.1", "10.0.0.2", "10.0.0.3"))
+	if err := api.checkLoop(packet); err != nil {
+		t.Fatalf("expected a valid path to pass, got %v", err)
+	}
+
+	// 剩余跳数用完
+	packet.TTL = 0
+	err := api.checkLoop(packet)
+	if err == nil || err.Reason != "hop limit exceeded" || err.Node != api.NodeIP {
+		t.Fatalf("expected the hop limit to be exceeded, got %v", err)
+	}
+
+	// 转发路径中再次出现本节点
+	packet = config.NewPacket(hops("10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.2"))
+	err = api.checkLoop(packet)
+	if err == nil || err.Reason != "forwarding loop detected" || len(err.Path) != 4 {
+		t.Fatalf("expected a forwarding loop with the full path, got %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package handler
+
+import (
+	"demo1/metrics"
+	"demo1/proxy/connection"
+	smux2 "demo1/proxy/smux_usage"
+	"demo1/schedule"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	requestsTotal = metrics.NewCounter("overlay_requests_total",
+		"Requests handled by this node, by role, route, next hop and status code.",
+		"role", "route", "next_hop", "code")
+	requestDuration = metrics.NewHistogram("overlay_request_duration_seconds",
+		"Time from receiving a request to finishing its response, by role, route and next hop.",
+		nil, "role", "route", "next_hop")
+
+	// metricsNode 提供连接池和其他运行状态的节点，最近挂载管理接口的节点生效
+	metricsNode atomic.Pointer[AdminAPI]
+)
+
+// 请求指标的 route 标签在没有命中路由时使用的固定值
+const (
+	routeUnrouted = "unrouted" // 入口没有命中任何路由，请求被拒绝
+	routeDirect   = "direct"   // 入口没有命中任何路由，由入口直接访问目的主机
+	routeRelayed  = "relayed"  // 中继和出口节点不知道请求在入口命中的路由
+)
+
+func init() {
+	metrics.NewGaugeFunc("overlay_pool_idle_connections", "Idle connections in the pool to each next hop.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return poolSamples(func(s connection.Stats) float64 { return float64(s.Idle) })
+		})
+	metrics.NewCounterFunc("overlay_pool_gets_total", "Connections requested from the pool to each next hop.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return poolSamples(func(s connection.Stats) float64 { return float64(s.Gets) })
+		})
+	metrics.NewCounterFunc("overlay_pool_misses_total", "Pool gets that found no idle connection and dialed a new one.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return poolSamples(func(s connection.Stats) float64 { return float64(s.Misses) })
+		})
+	metrics.NewCounterFunc("overlay_pool_factory_errors_total", "Failed attempts to dial a new pooled connection.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return poolSamples(func(s connection.Stats) float64 { return float64(s.FactoryErrors) })
+		})
+	metrics.NewGaugeFunc("overlay_pool_in_use_connections", "Connections taken from the pool to each next hop and not yet returned.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return poolSamples(func(s connection.Stats) float64 { return float64(s.InUse) })
+		})
+	metrics.NewCounterFunc("overlay_pool_created_total", "Connections dialed by the pool to each next hop.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return poolSamples(func(s connection.Stats) float64 { return float64(s.Created) })
+		})
+	metrics.NewCounterFunc("overlay_pool_reused_total", "Pool gets served by an idle connection.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return poolSamples(func(s connection.Stats) float64 { return float64(s.Reused) })
+		})
+	metrics.NewCounterFunc("overlay_pool_discarded_total", "Pooled connections closed because they were unusable, dead or expired.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return poolSamples(func(s connection.Stats) float64 { return float64(s.Discarded) })
+		})
+	metrics.NewCounterFunc("overlay_pool_closed_total", "Connections held by an SMUX session and closed with it.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return poolSamples(func(s connection.Stats) float64 { return float64(s.Closed) })
+		})
+	metrics.NewCounterFunc("overlay_pool_closed_full_total", "Returned connections closed because the pool already held max_cap idle connections.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return poolSamples(func(s connection.Stats) float64 { return float64(s.ClosedFull) })
+		})
+	metrics.NewGaugeFunc("overlay_pool_active_connections", "Open connections to each next hop, idle and in use.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return poolSamples(func(s connection.Stats) float64 { return float64(s.Active) })
+		})
+	metrics.NewCounterFunc("overlay_pool_waits_total", "Pool gets that had to wait because the next hop reached its connection limit.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return poolSamples(func(s connection.Stats) float64 { return float64(s.Waits) })
+		})
+	metrics.NewCounterFunc("overlay_pool_wait_timeouts_total", "Pool gets that gave up waiting for a connection.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return poolSamples(func(s connection.Stats) float64 { return float64(s.WaitTimeouts) })
+		})
+	metrics.NewCounterFunc("overlay_pool_wait_seconds_total", "Total time spent waiting for a pooled connection.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return poolSamples(func(s connection.Stats) float64 { return s.WaitDuration.Seconds() })
+		})
+	metrics.NewGaugeFunc("overlay_session_pool_sessions", "Open SMUX sessions in the session pool to each next hop.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return sessionPoolSamples(func(s smux2.PoolStats) float64 { return float64(s.Sessions) })
+		})
+	metrics.NewCounterFunc("overlay_session_pool_dials_total", "SMUX sessions dialed by the session pool to each next hop.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return sessionPoolSamples(func(s smux2.PoolStats) float64 { return float64(s.Dials) })
+		})
+	metrics.NewCounterFunc("overlay_session_pool_dial_errors_total", "Failed attempts to dial an SMUX session to each next hop.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return sessionPoolSamples(func(s smux2.PoolStats) float64 { return float64(s.DialErrors) })
+		})
+	metrics.NewCounterFunc("overlay_session_pool_expired_total", "SMUX sessions closed because they were idle or past their lifetime.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return sessionPoolSamples(func(s smux2.PoolStats) float64 { return float64(s.Expired) })
+		})
+	metrics.NewCounterFunc("overlay_session_pool_reclaimed_total", "Idle SMUX sessions closed to free connections for other next hops at the total connection limit.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return sessionPoolSamples(func(s smux2.PoolStats) float64 { return float64(s.Reclaimed) })
+		})
+	metrics.NewCounterFunc("overlay_session_pool_ping_failures_total", "Idle SMUX sessions closed because the connection check failed.",
+		[]string{"next_hop"}, func() []metrics.Sample {
+			return sessionPoolSamples(func(s smux2.PoolStats) float64 { return float64(s.PingFailures) })
+		})
+
+	metrics.NewGaugeFunc("overlay_smux_sessions", "Open SMUX sessions by direction.",
+		[]string{"direction"}, func() []metrics.Sample { return sessionSamples(false) })
+	metrics.NewGaugeFunc("overlay_smux_streams", "Open SMUX streams by direction.",
+		[]string{"direction"}, func() []metrics.Sample { return sessionSamples(true) })
+
+	metrics.NewGaugeFunc("overlay_schedule_delay", "Link delay used by the route evaluation of a node.",
+		[]string{"node"}, func() []metrics.Sample {
+			return scheduleSamples(func(v schedule.Values) (float64, float64) { return v.Delay, 0 }, false)
+		})
+	metrics.NewGaugeFunc("overlay_schedule_normalized_cpu", "Normalized CPU mean and variance of a node.",
+		[]string{"node", "stat"}, func() []metrics.Sample {
+			return scheduleSamples(func(v schedule.Values) (float64, float64) { return v.NormalCPUMean, v.NormalCPUVar }, true)
+		})
+	metrics.NewGaugeFunc("overlay_schedule_virtual_queue", "CPU mean and variance virtual queue lengths of a node.",
+		[]string{"node", "stat"}, func() []metrics.Sample {
+			return scheduleSamples(func(v schedule.Values) (float64, float64) { return v.QueueMean, v.QueueVar }, true)
+		})
+	metrics.NewGaugeFunc("overlay_schedule_drift_plus_penalty", "Drift-plus-penalty value of a node, lower is preferred.",
+		[]string{"node"}, func() []metrics.Sample {
+			return scheduleSamples(func(v schedule.Values) (float64, float64) { return v.DriftPlusPenalty, 0 }, false)
+		})
+
+	metrics.NewCounterFunc("overlay_cache_lookups_total", "Ingress cache lookups by result.",
+		[]string{"result"}, cacheSamples)
+	metrics.NewGaugeFunc("overlay_backend_origin_healthy", "Whether an egress backend origin is healthy and not ejected.",
+		[]string{"pool", "origin"}, func() []metrics.Sample { return backendSamples(false) })
+	metrics.NewGaugeFunc("overlay_backend_origin_active_requests", "Requests in flight to an egress backend origin.",
+		[]string{"pool", "origin"}, func() []metrics.Sample { return backendSamples(true) })
+}
+
+// observeRequest 记录一个请求的结果和耗时
+func observeRequest(role, route, nextHop string, status int, elapsed time.Duration) {
+	requestsTotal.With(role, route, nextHop, strconv.Itoa(status)).Inc()
+	requestDuration.With(role, route, nextHop).Observe(elapsed.Seconds())
+}
+
+// poolSamples 从到各个下一跳的连接池中采集一项统计。只输出每个下一跳的样本，
+// 节点的汇总用 sum without (next_hop) 计算，避免与每个下一跳的样本重复计数
+func poolSamples(value func(connection.Stats) float64) []metrics.Sample {
+	node := metricsNode.Load()
+	if node == nil {
+		return nil
+	}
+	stats, _ := node.ProxyNodeAPI.PoolStats()
+	samples := make([]metrics.Sample, 0, len(stats))
+	for nextHop, info := range stats {
+		samples = append(samples, metrics.Sample{
+			Values: []string{nextHop},
+			Value:  value(info.Stats),
+		})
+	}
+	return samples
+}
+
+// sessionPoolSamples 从到各个下一跳的会话池中采集一项统计，与 poolSamples 一样只输出每个下一跳的样本
+func sessionPoolSamples(value func(smux2.PoolStats) float64) []metrics.Sample {
+	node := metricsNode.Load()
+	if node == nil {
+		return nil
+	}
+	stats, _ := node.ProxyNodeAPI.PoolStats()
+	samples := make([]metrics.Sample, 0, len(stats))
+	for nextHop, info := range stats {
+		samples = append(samples, metrics.Sample{
+			Values: []string{nextHop},
+			Value:  value(info.Sessions),
+		})
+	}
+	return samples
+}
+
+// scheduleSamples 从选路评价结果中采集一项取值。stats 为真时 value 返回均值和方差，
+// 分别输出 stat="mean" 和 stat="var" 两个样本，否则只使用第一个返回值
+func scheduleSamples(value func(schedule.Values) (float64, float64), stats bool) []metrics.Sample {
+	snapshot := schedule.Snapshot()
+	samples := make([]metrics.Sample, 0, 2*len(snapshot))
+	for node, v := range snapshot {
+		mean, variance := value(v)
+		if !stats {
+			samples = append(samples, metrics.Sample{Values: []string{node}, Value: mean})
+			continue
+		}
+		samples = append(samples,
+			metrics.Sample{Values: []string{node, "mean"}, Value: mean},
+			metrics.Sample{Values: []string{node, "var"}, Value: variance})
+	}
+	return samples
+}
+
+// sessionSamples 按方向统计会话数或流数
+func sessionSamples(streams bool) []metrics.Sample {
+	node := metricsNode.Load()
+	if node == nil {
+		return nil
+	}
+	counts := map[string]int{DirectionInbound: 0, DirectionOutbound: 0}
+	for _, session := range node.ProxyNodeAPI.Sessions.Sessions() {
+		if streams {
+			counts[session.Direction] += session.Streams
+		} else {
+			counts[session.Direction]++
+		}
+	}
+	samples := make([]metrics.Sample, 0, len(counts))
+	for direction, count := range counts {
+		samples = append(samples, metrics.Sample{Values: []string{direction}, Value: float64(count)})
+	}
+	return samples
+}
+
+// cacheSamples 入口缓存的命中、重新验证和未命中次数
+func cacheSamples() []metrics.Sample {
+	node := metricsNode.Load()
+	if node == nil {
+		return nil
+	}
+	stats, enabled := node.ClientServerAPI.CacheStats()
+	if !enabled {
+		return nil
+	}
+	return []metrics.Sample{
+		{Values: []string{"hit"}, Value: float64(stats.Hits)},
+		{Values: []string{"revalidated"}, Value: float64(stats.Revalidations)},
+		{Values: []string{"miss"}, Value: float64(stats.Misses)},
+	}
+}
+
+// backendSamples 出口后端池中每个源站的健康状态或活跃请求数
+func backendSamples(active bool) []metrics.Sample {
+	node := metricsNode.Load()
+	if node == nil {
+		return nil
+	}
+	var samples []metrics.Sample
+	for _, pool := range node.ProxyNodeAPI.Backends.Status() {
+		for _, origin := range pool.Origins {
+			value := float64(origin.Active)
+			if !active {
+				value = 0
+				if origin.Healthy && !origin.Ejected {
+					value = 1
+				}
+			}
+			samples = append(samples, metrics.Sample{Values: []string{pool.Name, origin.Addr}, Value: value})
+		}
+	}
+	return samples
+}
@@ -1,56 +1,206 @@
 package handler
 
 import (
-	"bytes"
+	"bufio"
+	"context"
+	"demo1/proxy/accesslog"
+	"demo1/proxy/backend"
+	"demo1/proxy/config"
+	"demo1/proxy/connection"
+	smux2 "demo1/proxy/smux_usage"
+	"demo1/proxy/stripe"
+	"demo1/proxy/tracing"
+	"errors"
 	"fmt"
 	"github.com/xtaci/smux" // 使用 SMUX 协议库
 	"io"
 	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Module2API: 模块2的对外接口
 type Module2API struct {
 	ClientServerAPI *Module1API // 模块1的接口实例
+
+	NodeIP         string             // 本节点在转发路径中的 IP
+	NodeID         string             // 本节点 ID，写入 Via 头部
+	Routes         *config.RouteTable // 用于解析下一跳的拨号地址
+	DialTimeout    time.Duration      // 连接下一跳的超时时间
+	RequestTimeout time.Duration      // 等待下一跳返回响应头的超时时间
+	Breakers       *BreakerSet        // 每个下一跳的熔断器
+	Links          *LinkTuner         // 按对端节点选择 SMUX 会话参数
+	Sessions       *SessionRegistry   // 本节点的 SMUX 会话和流
+	DrainTimeout   time.Duration      // 关闭时等待进行中的流结束的最长时间
+	Egress         bool               // 是否允许作为路径的最后一跳访问目标服务器
+	Backends       *backend.Set       // 出口节点后面的后端池，目的主机不属于任何池时直接访问
+	AccessLog      *accesslog.Logger  // 访问日志，为空表示不记录
+	Tracer         *tracing.Tracer    // 分布式追踪，为空表示不创建 span
+	// Dial 连接下一跳的拨号函数，为空时使用 net.Dialer；测试中可以替换为注入故障的链路
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// 到每个下一跳保持的 SMUX 会话数和每个会话上同时打开的流数上限，第一次转发前设置
+	SessionsPerHop       int
+	MaxStreamsPerSession int
+	// Pool 到下一跳的连接数限制和会话回收，第一次转发前设置。每个会话的底层连接（条带化时每条通道）
+	// 从按下一跳管理的连接池取出，受 MaxActive、MaxPools 和 MaxTotal 限制，达到上限时最多等待
+	// PoolWaitTimeout；Pool.Pool 中的 MaxIdleTime、MaxLifetime 和 Ping 作用于会话池中的会话
+	Pool            connection.ManagerOptions
+	PoolWaitTimeout time.Duration
+	// 到下一跳的每个会话使用的 TCP 连接数，大于 1 时数据分散到多条连接上并行发送；
+	// PeerLanes 按下一跳 IP 覆盖 Lanes，Stripe 为条带化连接的数据块和重排缓冲区参数
+	Lanes     int
+	PeerLanes map[string]int
+	Stripe    stripe.Options
+	// 反向隧道：AcceptTunnels 为真时本节点作为会合中继，接受 NAT 后面的节点注册；
+	// 注册双方的 TunnelToken 必须一致，会合中继未设置 TunnelToken 时拒绝所有注册。TunnelMaxBackoff 为 RunTunnel 重新连接的最长等待时间
+	AcceptTunnels    bool
+	TunnelToken      string
+	TunnelMaxBackoff time.Duration
+
+	inflight sync.WaitGroup // 正在处理的流
+
+	poolsMu     sync.Mutex
+	outbound    *smux2.SessionPools // 到各个下一跳的会话池，第一次转发时创建
+	connections *connection.Manager // 会话底层连接的连接池，与 outbound 一起创建
+	bonds       *stripe.Acceptor    // 收集上游节点的条带化通道，第一次收到通道时创建
+
+	tunnelsMu sync.Mutex
+	tunnels   map[string]*reverseTunnel // 按节点 IP 登记的反向隧道
 }
 
 // NewModule2API: 创建模块2实例
 func NewModule2API(clientServerAPI *Module1API) *Module2API {
-	return &Module2API{ClientServerAPI: clientServerAPI}
+	return &Module2API{
+		ClientServerAPI: clientServerAPI,
+		Routes:          config.NewRouteTable(),
+		DialTimeout:     3 * time.Second,
+		RequestTimeout:  10 * time.Second,
+		Breakers:        NewBreakerSet(DefaultBreakerSettings),
+		Links:           NewLinkTuner(smux2.LinkConfig{}, nil),
+		Sessions:        NewSessionRegistry(),
+		DrainTimeout:    30 * time.Second,
+		Egress:          true,
+
+		SessionsPerHop:       smux2.DefaultPoolSize,
+		MaxStreamsPerSession: smux2.DefaultMaxStreams,
+		Lanes:                1,
+		Pool: connection.ManagerOptions{
+			Pool:     connection.Options{MaxCap: 20},
+			MaxPools: 256,
+			MaxTotal: 1024,
+		},
+		PoolWaitTimeout: 5 * time.Second,
+	}
 }
 
-// StartProxyServer: 启动代理节点服务器
-func (api *Module2API) StartProxyServer(addr string) error {
+// StartProxyServer: 启动代理节点服务器，ctx 结束时优雅关闭
+func (api *Module2API) StartProxyServer(ctx context.Context, addr string) error {
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return fmt.Errorf("failed to start proxy server: %w", err)
 	}
-	defer listener.Close()
+	return api.ServeProxy(ctx, listener)
+}
+
+// ServeProxy: 在给定的监听器上接受代理节点连接。ctx 结束后停止接受新的连接和流，
+// 等待进行中的流结束（最长 DrainTimeout），然后关闭所有会话
+func (api *Module2API) ServeProxy(ctx context.Context, listener net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
 
-	fmt.Printf("ProxyNode: Listening on %s\n", addr)
+	fmt.Printf("ProxyNode: Listening on %s\n", listener.Addr())
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
 			fmt.Println("Failed to accept connection:", err)
 			continue
 		}
 
 		// 处理代理节点连接
-		go api.handleProxyConnection(conn)
+		go api.acceptConnection(conn)
 	}
+
+	api.shutdown()
+	return nil
+}
+
+// shutdown: 排空所有入向会话，等待进行中的流结束后关闭全部会话
+func (api *Module2API) shutdown() {
+	fmt.Println("ProxyNode: Draining streams")
+	api.Sessions.DrainAll()
+
+	done := make(chan struct{})
+	go func() {
+		api.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(api.DrainTimeout):
+		fmt.Println("ProxyNode: Drain timeout, closing remaining streams")
+	}
+
+	api.Sessions.CloseAll()
+	fmt.Println("ProxyNode: Stopped")
+}
+
+// acceptConnection: 按第一个字节区分普通的 SMUX 连接、条带化通道和反向隧道的注册，
+// 通道到齐后在组成的逻辑连接上处理会话
+func (api *Module2API) acceptConnection(conn net.Conn) {
+	conn, first, err := stripe.Peek(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	switch {
+	case first == tunnelMagic[0]:
+		api.acceptTunnel(conn)
+		return
+	case !stripe.IsLane(first):
+		api.handleProxyConnection(conn)
+		return
+	}
+
+	bonded, err := api.laneAcceptor().Add(conn)
+	if err != nil {
+		fmt.Println("Failed to accept stripe lane:", err)
+		return
+	}
+	if bonded != nil {
+		api.handleProxyConnection(bonded)
+	}
+}
+
+// laneAcceptor 返回条带化通道的收集器，不存在时按当前参数创建
+func (api *Module2API) laneAcceptor() *stripe.Acceptor {
+	api.poolsMu.Lock()
+	defer api.poolsMu.Unlock()
+	if api.bonds == nil {
+		api.bonds = stripe.NewAcceptor(api.Stripe)
+	}
+	return api.bonds
 }
 
 // handleProxyConnection: 处理代理节点连接
 func (api *Module2API) handleProxyConnection(conn net.Conn) {
 	defer conn.Close()
 
-	// 初始化 SMUX 会话
-	session, err := smux.Server(conn, nil)
+	// 初始化 SMUX 会话，接收窗口按上游节点的链路参数设置
+	session, err := smux.Server(conn, api.Links.SessionConfig(peerIP(conn), conn))
 	if err != nil {
 		fmt.Println("Failed to create SMUX session:", err)
 		return
 	}
 	defer session.Close()
 
+	sessionID := api.Sessions.AddSession(DirectionInbound, conn, session)
+	defer api.Sessions.RemoveSession(sessionID)
+
 	for {
 		// 接收 SMUX 流
 		stream, err := session.AcceptStream()
@@ -59,129 +209,463 @@ func (api *Module2API) handleProxyConnection(conn net.Conn) {
 			return
 		}
 
+		// 排空中的会话不再处理新的流，返回 503 并标明本节点，上游换路径重试
+		if api.Sessions.Draining(sessionID) {
+			go rejectDrainingStream(stream, api.NodeIP)
+			continue
+		}
+
 		// 处理 SMUX 流数据
-		go api.handleStream(stream)
+		api.inflight.Add(1)
+		go func() {
+			defer api.inflight.Done()
+			api.handleStream(sessionID, stream)
+		}()
 	}
 }
 
-// handleStream: 处理 SMUX 流
-func (api *Module2API) handleStream(stream *smux.Stream) {
+// handleStream: 处理 SMUX 流，流中依次是包头和 HTTP 请求
+func (api *Module2API) handleStream(sessionID uint64, stream *smux.Stream) {
 	defer stream.Close()
 
-	// 读取请求数据
-	data, err := io.ReadAll(stream)
+	reader := bufio.NewReader(stream)
+	packet, err := config.ReadPacket(reader)
 	if err != nil {
-		fmt.Println("Failed to read stream data:", err)
+		fmt.Println("Failed to read packet header:", err)
 		return
 	}
 
-	// 解封数据并解析为请求信息
-	method, targetURL, headers, body := api.parseRequest(data)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		fmt.Println("Failed to read request:", err)
+		writeErrorResponse(stream, http.StatusBadRequest, "", err)
+		return
+	}
+	defer req.Body.Close()
 
-	// 判断目标：如果是服务器，交给模块1处理；如果是代理节点，转发到下一跳
-	if api.isServerTarget(targetURL) {
-		// 转发到目标服务器
-		responseBytes, err := api.ClientServerAPI.forwardToServer(data, targetURL)
-		if err != nil {
-			fmt.Println("Failed to forward to server:", err)
-			stream.Write([]byte(fmt.Sprintf("Error: %v", err)))
-			return
+	// 每个流写一条访问记录
+	start := time.Now()
+	body := &countingReader{ReadCloser: req.Body}
+	req.Body = body
+	var respBody *countingReader
+	record := &accesslog.Record{
+		Time:      start,
+		Node:      api.NodeID,
+		Role:      accesslog.RoleRelay,
+		RequestID: req.Header.Get(accesslog.RequestIDHeader),
+		PacketID:  packet.PacketID,
+		Client:    stream.RemoteAddr().String(),
+		Method:    req.Method,
+		Host:      req.Host,
+		Path:      req.URL.Path,
+		Hops:      packet.HopIPs(),
+	}
+	// 中继 span，父 span 是上一跳的 relay.forward
+	ctx, span := api.Tracer.Start(tracing.Extract(context.Background(), req.Header), "relay", tracing.KindServer)
+	span.SetAttribute("overlay.node", api.NodeID)
+	span.SetAttribute("overlay.packet_id", packet.PacketID)
+	span.SetAttribute("overlay.ttl", packet.TTL)
+	defer func() {
+		span.SetAttribute("overlay.role", record.Role)
+		finishServerSpan(span, record.Status)
+	}()
+	// 请求指标按下一跳统计，出口统一记为 origin；route 标签用固定值，避免目的主机过多
+	metricHop := "none"
+	defer func() {
+		observeRequest(record.Role, routeRelayed, metricHop, record.Status, time.Since(start))
+	}()
+	defer func() {
+		record.BytesIn = body.n
+		if respBody != nil {
+			record.BytesOut = respBody.n
 		}
+		record.DurationMs = accesslog.Milliseconds(time.Since(start))
+		api.AccessLog.Log(record)
+	}()
 
-		// 返回响应给请求方
-		stream.Write(responseBytes)
-	} else {
-		// 转发到下一跳代理节点
-		responseBytes, err := api.SendRequestToProxy(targetURL, method, targetURL, headers, body)
-		if err != nil {
-			fmt.Println("Failed to forward to next proxy:", err)
-			stream.Write([]byte(fmt.Sprintf("Error: %v", err)))
-			return
-		}
+	// 丢弃成环或超过跳数限制的数据包，并把完整路径返回给客户端
+	if err := api.checkLoop(packet); err != nil {
+		fmt.Println("Dropping packet:", err)
+		record.Status, record.Error = http.StatusLoopDetected, err.Error()
+		writeLoopResponse(stream, err)
+		return
+	}
 
-		// 返回响应给请求方
-		stream.Write(responseBytes)
+	nextHop, _ := api.nextHopOf(packet)
+	metricHop = nextHop
+	if nextHop == "" {
+		nextHop = req.Host
+		metricHop = "origin"
+		record.Role = accesslog.RoleEgress
 	}
-}
+	done := api.Sessions.AddStream(sessionID, DirectionInbound, stream.RemoteAddr().String(), nextHop)
+	defer done()
 
-// parseRequest: 解析 SMUX 流中的请求数据
-func (api *Module2API) parseRequest(data []byte) (method, targetURL string, headers map[string][]string, body []byte) {
-	// 示例实现，解析请求格式：第一行为方法和URL，接下来为头部，最后为Body
-	headers = make(map[string][]string)
-	parts := bytes.Split(data, []byte("\n\n")) // 分为头部和Body
-	headerPart := bytes.Split(parts[0], []byte("\n"))
+	resp, err := api.relay(ctx, packet, req)
+	if err != nil {
+		fmt.Println("Failed to relay request:", err)
+		record.Status, record.Error = http.StatusBadGateway, err.Error()
+		var hopErr *HopError
+		switch {
+		case errors.As(err, &hopErr):
+			writeErrorResponse(stream, http.StatusBadGateway, hopErr.Hop, err)
+		case errors.Is(err, connection.ErrWaitTimeout):
+			record.Status = http.StatusServiceUnavailable
+			writeErrorResponse(stream, http.StatusServiceUnavailable, "", err)
+		default:
+			writeErrorResponse(stream, http.StatusBadGateway, "", err)
+		}
+		return
+	}
+	defer resp.Body.Close()
 
-	// 第一行是方法和URL
-	firstLine := bytes.Split(headerPart[0], []byte(" "))
-	method = string(firstLine[0])
-	targetURL = string(firstLine[1])
+	// 返回响应给请求方，并在各跳耗时中加上本节点
+	record.Status = resp.StatusCode
+	record.HopTimings = accesslog.ParseHopTimings(resp.Header)
+	accesslog.AddHopTiming(resp.Header, api.NodeIP, time.Since(start))
+	addVia(resp.Header, resp.ProtoMajor, resp.ProtoMinor, api.NodeID)
+	respBody = &countingReader{ReadCloser: resp.Body}
+	resp.Body = respBody
+	if err := resp.Write(stream); err != nil {
+		fmt.Println("Failed to write response to stream:", err)
+		record.Error = err.Error()
+	}
+}
 
-	// 剩余部分是头部
-	for _, line := range headerPart[1:] {
-		header := bytes.SplitN(line, []byte(": "), 2)
-		if len(header) == 2 {
-			key := string(header[0])
-			value := string(header[1])
-			headers[key] = append(headers[key], value)
+// nextHopOf: 根据本节点在转发路径中的位置返回下一跳，本节点是最后一跳时返回空字符串
+func (api *Module2API) nextHopOf(packet *config.Packet) (string, error) {
+	for i, hop := range packet.HopList {
+		if config.Uint32ToIP(hop) != api.NodeIP {
+			continue
 		}
+		if i == len(packet.HopList)-1 {
+			return "", nil
+		}
+		return config.Uint32ToIP(packet.HopList[i+1]), nil
 	}
+	return "", fmt.Errorf("node %s is not in hop list", api.NodeIP)
+}
 
-	// Body部分
-	if len(parts) > 1 {
-		body = parts[1]
+// relay: 根据本节点在转发路径中的位置，把请求交给下一跳或者目标服务器
+func (api *Module2API) relay(ctx context.Context, packet *config.Packet, req *http.Request) (*http.Response, error) {
+	nextHop, err := api.nextHopOf(packet)
+	if err != nil {
+		return nil, err
 	}
+	addVia(req.Header, req.ProtoMajor, req.ProtoMinor, api.NodeID)
 
-	return
+	// 本节点是路径上的最后一跳，直接转发到目标服务器
+	if nextHop == "" {
+		if !api.Egress {
+			return nil, fmt.Errorf("node %s is not an egress", api.NodeIP)
+		}
+		target := "http://" + req.Host + req.RequestURI
+		pool := api.Backends.Match(req.Host)
+		if pool != nil {
+			target = "backend:" + pool.Config.Name
+		}
+		return traceOrigin(ctx, req.Header, target, func() (*http.Response, error) {
+			if pool != nil {
+				return forwardToBackend(pool, req)
+			}
+			return api.ClientServerAPI.forwardToServer(req, target)
+		})
+	}
+
+	// 转发到下一跳代理节点，剩余跳数减一
+	packet.TTL--
+	return api.SendRequestToProxy(ctx, nextHop, packet, req)
 }
 
-// isServerTarget: 判断目标是否是服务器
-func (api *Module2API) isServerTarget(targetURL string) bool {
-	// 示例实现：根据目标URL判断是否为服务器
-	// 如果是以 "http://" 或 "https://" 开头，则认为是服务器
-	return bytes.HasPrefix([]byte(targetURL), []byte("http://")) || bytes.HasPrefix([]byte(targetURL), []byte("https://"))
+// SendRequestToProxy: 将包头和请求发送到下一跳代理节点，返回下一跳的响应。
+// 连接或读取响应头失败时返回 *HopError，下一跳熔断时返回包装了 ErrBreakerOpen 的 *HopError；
+// 调用方负责关闭响应体，关闭时会一并关闭流
+func (api *Module2API) SendRequestToProxy(ctx context.Context, nextHop string, packet *config.Packet, req *http.Request) (*http.Response, error) {
+	ctx, span := tracing.StartChild(ctx, "relay.forward", tracing.KindClient)
+	span.SetAttribute("overlay.next_hop", nextHop)
+	span.SetAttribute("overlay.packet_id", packet.PacketID)
+	span.SetAttribute("overlay.ttl", packet.TTL)
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := api.sendRequestToProxy(ctx, nextHop, packet, req)
+	if err == nil {
+		span.SetAttribute("http.status_code", resp.StatusCode)
+	}
+	span.Finish(err)
+	return resp, err
 }
 
-// SendRequestToProxy: 将请求发送到代理节点
-func (api *Module2API) SendRequestToProxy(proxyAddr, method, url string, headers map[string][]string, body []byte) ([]byte, error) {
-	conn, err := net.Dial("tcp", proxyAddr)
+// sendRequestToProxy: 建立到下一跳的 SMUX 会话和流，发送包头和请求并读取响应头
+func (api *Module2API) sendRequestToProxy(ctx context.Context, nextHop string, packet *config.Packet, req *http.Request) (*http.Response, error) {
+	if err := api.Breakers.Allow(nextHop); err != nil {
+		return nil, &HopError{Hop: nextHop, Err: err}
+	}
+
+	// 在到下一跳的会话池或反向隧道中打开流，没有可用的会话时建立新的连接和会话
+	openCtx, openSpan := tracing.StartChild(ctx, "smux.open_stream", tracing.KindInternal)
+	openSpan.SetAttribute("overlay.next_hop", nextHop)
+	stream, err := api.openStream(openCtx, nextHop)
+	openSpan.Finish(err)
+	if errors.Is(err, connection.ErrWaitTimeout) {
+		// 连接数达到上限是本节点的排队，不计入下一跳的熔断，也不报告下一跳故障
+		api.Breakers.Release(nextHop)
+		return nil, fmt.Errorf("failed to open SMUX stream to %s: %w", nextHop, err)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to proxy: %w", err)
+		api.recordHopResult(ctx, nextHop, false)
+		return nil, &HopError{Hop: nextHop, Err: fmt.Errorf("failed to open SMUX stream: %w", err)}
 	}
-	defer conn.Close()
 
-	// 创建 SMUX 会话
-	session, err := smux.Client(conn, nil)
+	sessionID := api.Sessions.SessionID(stream.Session)
+	release := api.Sessions.AddStream(sessionID, DirectionOutbound, stream.LocalAddr().String(), nextHop)
+
+	// 请求被取消时关闭流，让阻塞中的读写立即返回；会话由其他请求共用，不随请求关闭
+	stop := context.AfterFunc(ctx, func() { stream.Close() })
+	fail := func(err error) (*http.Response, error) {
+		stop()
+		stream.Close()
+		release()
+		api.recordHopResult(ctx, nextHop, false)
+		return nil, &HopError{Hop: nextHop, Err: err}
+	}
+
+	header, err := config.SerializePacket(packet)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create SMUX session: %w", err)
+		return fail(fmt.Errorf("failed to serialize packet: %w", err))
+	}
+	if _, err = stream.Write(header); err != nil {
+		return fail(fmt.Errorf("failed to write to stream: %w", err))
+	}
+	if err = req.Write(stream); err != nil {
+		return fail(fmt.Errorf("failed to write to stream: %w", err))
 	}
-	defer session.Close()
 
-	// 创建 SMUX 流
-	stream, err := session.OpenStream()
+	// 接收响应头，超时视为下一跳故障
+	if api.RequestTimeout > 0 {
+		stream.SetReadDeadline(time.Now().Add(api.RequestTimeout))
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(stream), req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open SMUX stream: %w", err)
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return fail(fmt.Errorf("failed to read response: %w", err))
 	}
-	defer stream.Close()
+	stream.SetReadDeadline(time.Time{})
+	api.recordHopResult(ctx, nextHop, true)
+
+	resp.Body = &streamBody{ReadCloser: resp.Body, stream: stream, stop: stop, release: release}
+	return resp, nil
+}
 
-	// 构造请求数据
-	requestData := fmt.Sprintf("%s %s\n", method, url)
-	for key, values := range headers {
-		for _, value := range values {
-			requestData += fmt.Sprintf("%s: %s\n", key, value)
+// sessionPools 返回到各个下一跳的会话池，不存在时按当前参数创建会话池和底层连接的连接池
+func (api *Module2API) sessionPools() (*smux2.SessionPools, error) {
+	api.poolsMu.Lock()
+	defer api.poolsMu.Unlock()
+	if api.outbound != nil {
+		return api.outbound, nil
+	}
+
+	// 会话独占底层连接直到关闭，连接池只负责连接数的上限和统计，空闲回收和检查作用于会话
+	opts := api.Pool
+	sessionOpts := opts.Pool
+	opts.Pool = connection.Options{MaxCap: sessionOpts.MaxCap, MaxActive: sessionOpts.MaxActive}
+	manager, err := connection.NewManager(func(nextHop string) connection.Factory {
+		return func() (net.Conn, error) {
+			return api.dial(context.Background(), api.Routes.HopAddr(nextHop))
+		}
+	}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection pool settings: %w", err)
+	}
+
+	dial := func(ctx context.Context, nextHop string) (net.Conn, error) {
+		lanes := api.lanesTo(nextHop)
+		if lanes <= 1 {
+			return api.pooledConn(ctx, manager, nextHop)
 		}
+		return stripe.Dial(ctx, lanes, func(ctx context.Context) (net.Conn, error) {
+			return api.pooledConn(ctx, manager, nextHop)
+		}, api.Stripe)
 	}
-	requestData += "\n" + string(body)
+	api.connections = manager
+	api.outbound = smux2.NewSessionPools(dial, func(nextHop string) smux2.PoolConfig {
+		return smux2.PoolConfig{
+			Size:        api.SessionsPerHop,
+			MaxStreams:  api.MaxStreamsPerSession,
+			MaxIdleTime: sessionOpts.MaxIdleTime,
+			MaxLifetime: sessionOpts.MaxLifetime,
+			Ping:        sessionOpts.Ping,
+			SessionConfig: func(conn net.Conn) *smux.Config {
+				return api.Links.SessionConfig(nextHop, conn)
+			},
+			OnSession: func(conn net.Conn, session *smux.Session) func() {
+				id := api.Sessions.AddSession(DirectionOutbound, conn, session)
+				return func() { api.Sessions.RemoveSession(id) }
+			},
+		}
+	})
+	return api.outbound, nil
+}
 
-	// 发送数据
-	_, err = stream.Write([]byte(requestData))
+// pooledConn 从到下一跳的连接池取出一个连接作为会话的底层连接，连接数达到上限时最多等待 PoolWaitTimeout。
+// 连接由会话独占使用直到会话关闭，关闭时释放占用的连接数，在连接池的统计中计入 Closed；
+// 会话因空闲、生存时间或检查失败被会话池关闭时计入 Discarded
+func (api *Module2API) pooledConn(ctx context.Context, manager *connection.Manager, nextHop string) (net.Conn, error) {
+	if api.PoolWaitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, api.PoolWaitTimeout)
+		defer cancel()
+	}
+	// 在请求中同步建立会话时记录等待连接池的时间，后台建立的会话没有追踪上下文
+	_, getSpan := tracing.StartChild(ctx, "pool.get", tracing.KindInternal)
+	getSpan.SetAttribute("overlay.next_hop", nextHop)
+	conn, err := manager.GetContext(ctx, nextHop)
+	getSpan.Finish(err)
 	if err != nil {
-		return nil, fmt.Errorf("failed to write to stream: %w", err)
+		return nil, err
+	}
+	if pc, ok := conn.(*connection.PoolConn); ok {
+		pc.MarkExclusive()
+	}
+	return conn, nil
+}
+
+// PoolStats 返回到每个下一跳的连接池和会话池的统计信息，以及所有下一跳的汇总
+func (api *Module2API) PoolStats() (map[string]PoolInfo, PoolInfo) {
+	api.poolsMu.Lock()
+	sessions, manager := api.outbound, api.connections
+	api.poolsMu.Unlock()
+
+	infos := make(map[string]PoolInfo)
+	var total PoolInfo
+	if sessions == nil {
+		return infos, total
+	}
+	for nextHop, stats := range manager.Stats() {
+		info := infos[nextHop]
+		info.NextHop, info.Stats = nextHop, stats
+		infos[nextHop] = info
+		total.Stats.Add(stats)
+	}
+	for nextHop, stats := range sessions.Stats() {
+		info := infos[nextHop]
+		info.NextHop, info.Sessions = nextHop, stats
+		infos[nextHop] = info
+		total.Sessions.Add(stats)
 	}
+	return infos, total
+}
+
+// lanesTo 返回到下一跳的每个会话使用的 TCP 连接数
+func (api *Module2API) lanesTo(nextHop string) int {
+	if lanes, exists := api.PeerLanes[nextHop]; exists {
+		return lanes
+	}
+	return api.Lanes
+}
+
+// CloseSessionPools 关闭到所有下一跳的会话和连接池，节点停止时调用，之后再次转发时重新建立
+func (api *Module2API) CloseSessionPools() {
+	api.poolsMu.Lock()
+	pools, manager := api.outbound, api.connections
+	api.outbound, api.connections = nil, nil
+	api.poolsMu.Unlock()
+
+	if pools != nil {
+		pools.Close()
+	}
+	if manager != nil {
+		manager.Close()
+	}
+}
 
-	// 接收响应
-	response, err := io.ReadAll(stream)
+// DrainSession 排空会话。出向会话先移出到下一跳的会话池，新的流改用其他会话，
+// 已有的流结束后会话关闭；入向会话拒绝新的流
+func (api *Module2API) DrainSession(id uint64) error {
+	session, err := api.Sessions.Session(id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return err
+	}
+	api.poolsMu.Lock()
+	pools := api.outbound
+	api.poolsMu.Unlock()
+	if pools != nil {
+		pools.Detach(session)
+	}
+	return api.Sessions.DrainSession(id)
+}
+
+// dial 在 DialTimeout 内连接下一跳
+func (api *Module2API) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if api.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, api.DialTimeout)
+		defer cancel()
+	}
+	if api.Dial != nil {
+		return api.Dial(ctx, "tcp", addr)
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+// recordHopResult 向熔断器上报请求结果，请求被调用方取消时不计入统计
+func (api *Module2API) recordHopResult(ctx context.Context, hop string, success bool) {
+	if ctx.Err() != nil {
+		api.Breakers.Release(hop)
+		return
+	}
+	api.Breakers.Record(hop, success)
+}
+
+// streamBody 包装下一跳的响应体，关闭时关闭流，会话留在会话池中继续使用
+type streamBody struct {
+	io.ReadCloser
+	stream  *smux2.Stream
+	stop    func() bool
+	release func()
+	once    sync.Once
+}
+
+func (b *streamBody) Close() error {
+	var err error
+	b.once.Do(func() {
+		b.stop()
+		b.ReadCloser.Close()
+		err = b.stream.Close()
+		b.release()
+	})
+	return err
+}
+
+// rejectDrainingStream: 向排空中的会话上新到达的流返回 503，不读取也不处理其中的请求
+func rejectDrainingStream(stream *smux.Stream, nodeIP string) {
+	defer stream.Close()
+	stream.SetWriteDeadline(time.Now().Add(time.Second))
+	writeErrorResponse(stream, http.StatusServiceUnavailable, nodeIP, ErrHopDraining)
+}
+
+// writeErrorResponse: 向上游返回错误响应，failedHop 非空时在头部中标明故障节点
+func writeErrorResponse(w io.Writer, status int, failedHop string, err error) {
+	resp := errorResponse(status, err)
+	if failedHop != "" {
+		resp.Header.Set(FailedHopHeader, failedHop)
+	}
+	resp.Write(w)
+}
+
+// errorResponse: 构造纯文本的错误响应
+func errorResponse(status int, err error) *http.Response {
+	message := fmt.Sprintf("Error: %v", err)
+	resp := &http.Response{
+		StatusCode:    status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(strings.NewReader(message)),
+		ContentLength: int64(len(message)),
 	}
-	return response, nil
+	resp.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	return resp
 }
@@ -0,0 +1,287 @@
+package handler
+
+import (
+	"context"
+	"demo1/proxy/config"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// shapeChunk 带宽整形时每次读写的最大字节数，避免一次取走过多令牌造成突发
+	shapeChunk = 32 * 1024
+	// bucketIdleTimeout 超过该时间未使用的令牌桶会被回收
+	bucketIdleTimeout = 5 * time.Minute
+)
+
+// identityKey 认证模块在请求 context 中保存客户端身份使用的键
+type identityKey struct{}
+
+// WithIdentity 返回携带客户端身份的请求
+func WithIdentity(r *http.Request, identity string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, identity))
+}
+
+// RequestIdentity 返回请求的客户端身份，未认证时返回空字符串
+func RequestIdentity(r *http.Request) string {
+	identity, _ := r.Context().Value(identityKey{}).(string)
+	return identity
+}
+
+// clientIP 返回请求的客户端 IP
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// tokenBucket 令牌桶，rate 为每秒补充的令牌数，burst 为桶容量
+type tokenBucket struct {
+	mu       sync.Mutex
+	rate     float64
+	burst    float64
+	tokens   float64
+	last     time.Time
+	lastUsed time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	if burst < 1 {
+		burst = math.Max(rate, 1)
+	}
+	now := time.Now()
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now, lastUsed: now}
+}
+
+// refill 按经过的时间补充令牌，调用方需持有锁
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.lastUsed = now
+}
+
+// take 尝试取走 n 个令牌，令牌不足时不取并返回需要等待的时间
+func (b *tokenBucket) take(n float64) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens >= n {
+		b.tokens -= n
+		return true, 0
+	}
+	return false, time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// refund 退回之前取走的 n 个令牌
+func (b *tokenBucket) refund(n float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.burst, b.tokens+n)
+}
+
+// wait 取走 n 个令牌，令牌不足时先透支再等待补足，context 取消时提前返回
+func (b *tokenBucket) wait(ctx context.Context, n float64) error {
+	b.mu.Lock()
+	b.refill(time.Now())
+	b.tokens -= n
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// idle 判断令牌桶是否长时间未使用
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return now.Sub(b.lastUsed) > bucketIdleTimeout
+}
+
+// limitBuckets 一个限流键对应的令牌桶，带宽桶为空表示不限制带宽
+type limitBuckets struct {
+	requests *tokenBucket
+	upload   *tokenBucket
+	download *tokenBucket
+}
+
+// RateLimiter 入口限流器，按规则的分组键为每个客户端、身份或路由维护令牌桶
+type RateLimiter struct {
+	mu        sync.Mutex
+	rules     []*config.RateLimitRule
+	buckets   map[string]*limitBuckets
+	lastSweep time.Time
+}
+
+// NewRateLimiter 创建限流器
+func NewRateLimiter(rules []*config.RateLimitRule) *RateLimiter {
+	return &RateLimiter{
+		rules:     rules,
+		buckets:   make(map[string]*limitBuckets),
+		lastSweep: time.Now(),
+	}
+}
+
+// keyFor 返回请求在规则下的分组键值
+func keyFor(rule *config.RateLimitRule, r *http.Request, route string) string {
+	switch rule.Key {
+	case config.LimitByIdentity:
+		if identity := RequestIdentity(r); identity != "" {
+			return identity
+		}
+		return clientIP(r)
+	case config.LimitByRoute:
+		return route
+	}
+	return clientIP(r)
+}
+
+// bucketsFor 返回规则下某个键值的令牌桶，不存在则创建
+func (l *RateLimiter) bucketsFor(index int, rule *config.RateLimitRule, key string) *limitBuckets {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > bucketIdleTimeout {
+		l.sweep(now)
+	}
+
+	id := strconv.Itoa(index) + "|" + key
+	if buckets, exists := l.buckets[id]; exists {
+		return buckets
+	}
+	buckets := &limitBuckets{}
+	if rule.RequestsPerSecond > 0 {
+		buckets.requests = newTokenBucket(rule.RequestsPerSecond, float64(rule.Burst))
+	}
+	if rule.BytesPerSecond > 0 {
+		rate, burst := float64(rule.BytesPerSecond), float64(rule.BurstBytes)
+		buckets.upload = newTokenBucket(rate, burst)
+		buckets.download = newTokenBucket(rate, burst)
+	}
+	l.buckets[id] = buckets
+	return buckets
+}
+
+// sweep 回收长时间未使用的令牌桶，调用方需持有锁
+func (l *RateLimiter) sweep(now time.Time) {
+	for id, buckets := range l.buckets {
+		if (buckets.requests == nil || buckets.requests.idle(now)) &&
+			(buckets.upload == nil || buckets.upload.idle(now)) &&
+			(buckets.download == nil || buckets.download.idle(now)) {
+			delete(l.buckets, id)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Admit 对请求做速率检查。通过时返回请求体和响应体需要经过的带宽桶；
+// 被拒绝时返回 false 以及客户端应等待的时间。请求要通过所有匹配的规则才会被放行，
+// 任一规则拒绝时退回已经从其他规则取走的令牌，被拒绝的请求不占用任何规则的配额
+func (l *RateLimiter) Admit(r *http.Request, route string) (upload, download []*tokenBucket, retryAfter time.Duration, ok bool) {
+	var taken []*tokenBucket
+	for i, rule := range l.rules {
+		key := keyFor(rule, r, route)
+		if rule.Match != "" && rule.Match != key {
+			continue
+		}
+
+		buckets := l.bucketsFor(i, rule, key)
+		if buckets.requests != nil {
+			allowed, wait := buckets.requests.take(1)
+			if !allowed {
+				for _, bucket := range taken {
+					bucket.refund(1)
+				}
+				return nil, nil, wait, false
+			}
+			taken = append(taken, buckets.requests)
+		}
+		if buckets.upload != nil {
+			upload = append(upload, buckets.upload)
+			download = append(download, buckets.download)
+		}
+	}
+	return upload, download, 0, true
+}
+
+// shapedReader 按带宽桶限制读取速度的请求体
+type shapedReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	buckets []*tokenBucket
+}
+
+func (s *shapedReader) Read(p []byte) (int, error) {
+	if len(p) > shapeChunk {
+		p = p[:shapeChunk]
+	}
+	n, err := s.ReadCloser.Read(p)
+	for _, bucket := range s.buckets {
+		if waitErr := bucket.wait(s.ctx, float64(n)); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// shapedWriter 按带宽桶限制写出速度的响应
+type shapedWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	buckets []*tokenBucket
+}
+
+func (s *shapedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > shapeChunk {
+			chunk = chunk[:shapeChunk]
+		}
+		for _, bucket := range s.buckets {
+			if err := bucket.wait(s.ctx, float64(len(chunk))); err != nil {
+				return written, err
+			}
+		}
+		n, err := s.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Flush 透传到底层 ResponseWriter，保证流式响应能及时发出
+func (s *shapedWriter) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// retryAfterSeconds 将等待时间换算为 Retry-After 头部的秒数，至少为 1
+func retryAfterSeconds(wait time.Duration) int {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
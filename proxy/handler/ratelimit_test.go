@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"demo1/proxy/config"
+	"net/http"
+	"testing"
+)
+
+func TestAdmit(t *testing.T) {
+	limiter := NewRateLimiter([]*config.RateLimitRule{
+		{Key: config.LimitByIP, RequestsPerSecond: 1, Burst: 2},
+		{Key: config.LimitByRoute, Match: "shaped", BytesPerSecond: 1 << 20},
+	})
+	request := func(ip string) *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+		r.RemoteAddr = ip + ":12345"
+		return r
+	}
+
+	// 每个客户端 IP 有各自的突发量，用完后返回需要等待的时间
+	for i := 0; i < 2; i++ {
+		if _, _, _, ok := limiter.Admit(request("10.0.0.1"), "default"); !ok {
+			t.Fatalf("request %d within the burst was rejected", i)
+		}
+	}
+	_, _, retryAfter, ok := limiter.Admit(request("10.0.0.1"), "default")
+	if ok || retryAfter <= 0 {
+		t.Fatalf("expected a rejection with a retry delay, got ok=%v retryAfter=%s", ok, retryAfter)
+	}
+	if _, _, _, ok := limiter.Admit(request("10.0.0.2"), "default"); !ok {
+		t.Fatal("expected another client to have its own bucket")
+	}
+
+	// 只有匹配的路由经过带宽桶
+	upload, download, _, ok := limiter.Admit(request("10.0.0.3"), "shaped")
+	if !ok || len(upload) != 1 || len(download) != 1 {
+		t.Fatalf("expected bandwidth buckets on the shaped route, got %d/%d ok=%v", len(upload), len(download), ok)
+	}
+	if upload, _, _, _ := limiter.Admit(request("10.0.0.4"), "default"); len(upload) != 0 {
+		t.Error("expected no bandwidth buckets on other routes")
+	}
+
+	// 被后面的规则拒绝的请求不占用前面规则的配额
+	limiter = NewRateLimiter([]*config.RateLimitRule{
+		{Key: config.LimitByIP, RequestsPerSecond: 0.001, Burst: 1},
+		{Key: config.LimitByRoute, Match: "busy", RequestsPerSecond: 0.001, Burst: 1},
+	})
+	if _, _, _, ok := limiter.Admit(request("10.0.0.5"), "busy"); !ok {
+		t.Fatal("expected the first request to be admitted")
+	}
+	if _, _, _, ok := limiter.Admit(request("10.0.0.6"), "busy"); ok {
+		t.Fatal("expected the route limit to reject the second client")
+	}
+	if _, _, _, ok := limiter.Admit(request("10.0.0.6"), "quiet"); !ok {
+		t.Error("expected the rejected request to leave the client's own quota untouched")
+	}
+}
+
+func TestRouteName(t *testing.T) {
+	api := &Module1API{}
+	route := &config.Route{Destination: "api.example.com"}
+	if got := api.routeName(route); got != "api.example.com" {
+		t.Errorf("matched route: got %q", got)
+	}
+
+	// 没有路由时不使用客户端发来的 Host，限流桶和指标标签的数量保持固定
+	if got := api.routeName(nil); got != routeUnrouted {
+		t.Errorf("unrouted request: got %q", got)
+	}
+	api.DirectFetch = true
+	if got := api.routeName(nil); got != routeDirect {
+		t.Errorf("direct fetch: got %q", got)
+	}
+}
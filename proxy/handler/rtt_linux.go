@@ -0,0 +1,32 @@
+//go:build linux
+
+package handler
+
+import (
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// tcpRTT 通过 TCP_INFO 读取内核统计的平滑 RTT，非 TCP 连接或读取失败时返回 0
+func tcpRTT(conn net.Conn) time.Duration {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return 0
+	}
+
+	var rtt time.Duration
+	raw.Control(func(fd uintptr) {
+		info, err := unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+		if err == nil {
+			rtt = time.Duration(info.Rtt) * time.Microsecond
+		}
+	})
+	return rtt
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package handler
+
+import (
+	"net"
+	"time"
+)
+
+// tcpRTT 当前平台无法读取 TCP_INFO，始终返回 0
+func tcpRTT(conn net.Conn) time.Duration {
+	return 0
+}
@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtaci/smux"
+)
+
+// ErrSessionNotFound 指定的 SMUX 会话不存在
+var ErrSessionNotFound = errors.New("session not found")
+
+// 会话和流的方向
+const (
+	DirectionInbound  = "inbound"  // 上游节点发起，本节点接受
+	DirectionOutbound = "outbound" // 本节点发起，连接下一跳
+)
+
+// SessionInfo SMUX 会话的运行状态
+type SessionInfo struct {
+	ID         uint64        `json:"id"`
+	Direction  string        `json:"direction"`
+	LocalAddr  string        `json:"local_addr"`
+	RemoteAddr string        `json:"remote_addr"`
+	Streams    int           `json:"streams"`
+	RTT        time.Duration `json:"rtt"`             // 底层 TCP 连接的平滑 RTT，无法获取时为 0
+	Lanes      int           `json:"lanes,omitempty"` // 条带化会话使用的 TCP 连接数
+	Created    time.Time     `json:"created"`
+	Draining   bool          `json:"draining"`
+}
+
+// StreamInfo 正在处理中的 SMUX 流
+type StreamInfo struct {
+	ID          uint64        `json:"id"`
+	SessionID   uint64        `json:"session_id"`
+	Direction   string        `json:"direction"`
+	Source      string        `json:"source"`
+	Destination string        `json:"destination"`
+	Started     time.Time     `json:"started"`
+	Age         time.Duration `json:"age"`
+}
+
+// trackedSession 登记中的会话
+type trackedSession struct {
+	id        uint64
+	direction string
+	conn      net.Conn
+	session   *smux.Session
+	created   time.Time
+	draining  atomic.Bool
+}
+
+// SessionRegistry 登记本节点所有的 SMUX 会话和正在处理的流，供管理接口查看和操作
+type SessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[uint64]*trackedSession
+	streams  map[uint64]*StreamInfo
+	nextID   uint64
+}
+
+// NewSessionRegistry 创建会话登记表
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{
+		sessions: make(map[uint64]*trackedSession),
+		streams:  make(map[uint64]*StreamInfo),
+	}
+}
+
+// AddSession 登记一个会话，返回会话 ID；会话关闭后调用 RemoveSession
+func (r *SessionRegistry) AddSession(direction string, conn net.Conn, session *smux.Session) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	r.sessions[r.nextID] = &trackedSession{
+		id:        r.nextID,
+		direction: direction,
+		conn:      conn,
+		session:   session,
+		created:   time.Now(),
+	}
+	return r.nextID
+}
+
+// SessionID 返回已登记会话的 ID，会话未登记时返回 0
+func (r *SessionRegistry) SessionID(session *smux.Session) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, s := range r.sessions {
+		if s.session == session {
+			return id
+		}
+	}
+	return 0
+}
+
+// RemoveSession 注销会话
+func (r *SessionRegistry) RemoveSession(id uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, id)
+}
+
+// AddStream 登记一个正在处理的流，处理结束后调用返回的函数注销
+func (r *SessionRegistry) AddStream(sessionID uint64, direction, source, destination string) func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	id := r.nextID
+	r.streams[id] = &StreamInfo{
+		ID:          id,
+		SessionID:   sessionID,
+		Direction:   direction,
+		Source:      source,
+		Destination: destination,
+		Started:     time.Now(),
+	}
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.streams, id)
+	}
+}
+
+// Draining 判断会话是否正在排空，排空中的会话不再接受新的流
+func (r *SessionRegistry) Draining(id uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, exists := r.sessions[id]
+	return exists && s.draining.Load()
+}
+
+// Sessions 返回所有会话的状态
+func (r *SessionRegistry) Sessions() []SessionInfo {
+	r.mu.Lock()
+	tracked := make([]*trackedSession, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		tracked = append(tracked, s)
+	}
+	r.mu.Unlock()
+
+	infos := make([]SessionInfo, 0, len(tracked))
+	for _, s := range tracked {
+		lanes := 0
+		if striped, ok := s.conn.(interface{ Lanes() int }); ok {
+			lanes = striped.Lanes()
+		}
+		infos = append(infos, SessionInfo{
+			ID:         s.id,
+			Direction:  s.direction,
+			LocalAddr:  s.conn.LocalAddr().String(),
+			RemoteAddr: s.conn.RemoteAddr().String(),
+			Streams:    s.session.NumStreams(),
+			RTT:        tcpRTT(s.conn),
+			Lanes:      lanes,
+			Created:    s.created,
+			Draining:   s.draining.Load(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// Streams 返回所有正在处理的流
+func (r *SessionRegistry) Streams() []StreamInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	infos := make([]StreamInfo, 0, len(r.streams))
+	for _, s := range r.streams {
+		info := *s
+		info.Age = now.Sub(s.Started)
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// Session 返回已登记的会话
+func (r *SessionRegistry) Session(id uint64) (*smux.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, exists := r.sessions[id]
+	if !exists {
+		return nil, ErrSessionNotFound
+	}
+	return s.session, nil
+}
+
+// DrainSession 排空会话：不再接受新的流，现有的流全部结束后关闭会话。
+// 出向会话还要移出会话池，见 Module2API.DrainSession
+func (r *SessionRegistry) DrainSession(id uint64) error {
+	r.mu.Lock()
+	s, exists := r.sessions[id]
+	r.mu.Unlock()
+	if !exists {
+		return ErrSessionNotFound
+	}
+	if s.draining.Swap(true) {
+		return nil
+	}
+
+	log.Printf("Draining SMUX session %d (%s)", id, s.conn.RemoteAddr())
+	go func() {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for range ticker.C {
+			if s.session.IsClosed() || s.session.NumStreams() == 0 {
+				s.session.Close()
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// CloseSession 立即关闭会话及其上所有的流
+func (r *SessionRegistry) CloseSession(id uint64) error {
+	r.mu.Lock()
+	s, exists := r.sessions[id]
+	r.mu.Unlock()
+	if !exists {
+		return ErrSessionNotFound
+	}
+	log.Printf("Closing SMUX session %d (%s)", id, s.conn.RemoteAddr())
+	return s.session.Close()
+}
+
+// DrainAll 将所有入向会话标记为排空，不再接受新的流
+func (r *SessionRegistry) DrainAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.sessions {
+		if s.direction == DirectionInbound {
+			s.draining.Store(true)
+		}
+	}
+}
+
+// CloseAll 关闭所有会话
+func (r *SessionRegistry) CloseAll() {
+	r.mu.Lock()
+	sessions := make([]*trackedSession, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		sessions = append(sessions, s)
+	}
+	r.mu.Unlock()
+
+	for _, s := range sessions {
+		s.session.Close()
+	}
+}
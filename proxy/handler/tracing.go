@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"context"
+	"demo1/proxy/tracing"
+	"net/http"
+)
+
+// traceOrigin: 在 "origin" span 中访问源站，并把追踪上下文传给源站。span 在收到响应头时结束
+func traceOrigin(ctx context.Context, header http.Header, target string, call func() (*http.Response, error)) (*http.Response, error) {
+	ctx, span := tracing.StartChild(ctx, "origin", tracing.KindClient)
+	span.SetAttribute("http.url", target)
+	tracing.Inject(ctx, header)
+
+	resp, err := call()
+	if err == nil {
+		span.SetAttribute("http.status_code", resp.StatusCode)
+	}
+	span.Finish(err)
+	return resp, err
+}
+
+// finishServerSpan: 按返回给请求方的状态码结束入口或中继节点的 span，5xx 标记为失败
+func finishServerSpan(span *tracing.Span, status int) {
+	span.SetAttribute("http.status_code", status)
+	if status >= http.StatusInternalServerError {
+		span.SetError(errStatus(status))
+	}
+	span.Finish(nil)
+}
+
+// errStatus 把状态码表示为错误，用于标记失败的 span
+type errStatus int
+
+func (e errStatus) Error() string {
+	return http.StatusText(int(e))
+}
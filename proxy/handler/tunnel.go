@@ -0,0 +1,304 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	smux2 "demo1/proxy/smux_usage"
+	"demo1/proxy/tracing"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/xtaci/smux"
+)
+
+// 反向隧道：位于 NAT 后面、无法接受入向连接的节点主动连接会合中继，发送注册消息后
+// 在这条连接上作为 SMUX 服务端接受流；会合中继作为客户端，在转发路径的下一跳为该节点时
+// 通过这条连接打开流。注册消息依次为魔数 "RTUN" 和长度前缀的节点 ID、节点 IP、共享密钥，
+// 会合中继回复一个字节表示是否接受。魔数的第一个字节既不是 SMUX 协议版本，也不是条带化通道的握手
+var tunnelMagic = [4]byte{'R', 'T', 'U', 'N'}
+
+// 注册的回复
+const (
+	tunnelAccepted byte = 0
+	tunnelRejected byte = 1
+)
+
+const (
+	// tunnelHandshakeTimeout 发送和读取注册消息的最长时间
+	tunnelHandshakeTimeout = 10 * time.Second
+	// tunnelMinBackoff 隧道断开后第一次重新连接前的等待时间，之后每次失败加倍
+	tunnelMinBackoff = time.Second
+	// DefaultTunnelMaxBackoff 重新连接的默认最长等待时间
+	DefaultTunnelMaxBackoff = 30 * time.Second
+)
+
+var (
+	// ErrTunnelRejected 会合中继拒绝了注册
+	ErrTunnelRejected = errors.New("reverse tunnel registration rejected")
+	// errTunnelClosed 隧道上的会话结束
+	errTunnelClosed = errors.New("reverse tunnel closed")
+)
+
+// TunnelInfo 注册到本节点的反向隧道
+type TunnelInfo struct {
+	NodeID     string    `json:"node_id"`
+	NodeIP     string    `json:"node_ip"`
+	RemoteAddr string    `json:"remote_addr"`
+	Streams    int       `json:"streams"`
+	Registered time.Time `json:"registered"`
+}
+
+// reverseTunnel 会合中继上登记的一条隧道
+type reverseTunnel struct {
+	info    TunnelInfo
+	session *smux.Session
+}
+
+// tunnelHello 注册消息
+type tunnelHello struct {
+	nodeID, nodeIP, token string
+}
+
+// encode 编码注册消息，各字段不超过 255 字节
+func (h tunnelHello) encode() ([]byte, error) {
+	b := append([]byte(nil), tunnelMagic[:]...)
+	for _, field := range []string{h.nodeID, h.nodeIP, h.token} {
+		if len(field) > 255 {
+			return nil, fmt.Errorf("reverse tunnel field too long: %d bytes", len(field))
+		}
+		b = append(b, byte(len(field)))
+		b = append(b, field...)
+	}
+	return b, nil
+}
+
+// readTunnelHello 读取注册消息
+func readTunnelHello(r io.Reader) (tunnelHello, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return tunnelHello{}, err
+	}
+	if magic != tunnelMagic {
+		return tunnelHello{}, errors.New("invalid reverse tunnel magic")
+	}
+	var fields [3]string
+	for i := range fields {
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return tunnelHello{}, err
+		}
+		field := make([]byte, n[0])
+		if _, err := io.ReadFull(r, field); err != nil {
+			return tunnelHello{}, err
+		}
+		fields[i] = string(field)
+	}
+	return tunnelHello{nodeID: fields[0], nodeIP: fields[1], token: fields[2]}, nil
+}
+
+// RunTunnel 连接会合中继并注册本节点，之后在这条连接上处理会合中继打开的流。
+// 连接断开或注册失败后按退避重新连接，最长等待 TunnelMaxBackoff，直到 ctx 结束
+func (api *Module2API) RunTunnel(ctx context.Context, rendezvous string) error {
+	maxBackoff := api.TunnelMaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultTunnelMaxBackoff
+	}
+	backoff := min(tunnelMinBackoff, maxBackoff)
+	for {
+		started := time.Now()
+		err := api.runTunnelOnce(ctx, rendezvous)
+		if ctx.Err() != nil {
+			return nil
+		}
+		// 隧道保持了较长时间后断开，视为偶发故障，从最短的等待时间重新开始
+		if time.Since(started) > maxBackoff {
+			backoff = min(tunnelMinBackoff, maxBackoff)
+		}
+		log.Printf("Reverse tunnel to %s: %v, reconnecting in %s", rendezvous, err, backoff)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// runTunnelOnce 建立一条隧道并处理其上的流，直到会话结束
+func (api *Module2API) runTunnelOnce(ctx context.Context, rendezvous string) error {
+	hello, err := tunnelHello{nodeID: api.NodeID, nodeIP: api.NodeIP, token: api.TunnelToken}.encode()
+	if err != nil {
+		return err
+	}
+	conn, err := api.dial(ctx, rendezvous)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetDeadline(time.Now().Add(tunnelHandshakeTimeout))
+	if _, err := conn.Write(hello); err != nil {
+		conn.Close()
+		return err
+	}
+	var reply [1]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to read registration reply: %w", err)
+	}
+	if reply[0] != tunnelAccepted {
+		conn.Close()
+		return ErrTunnelRejected
+	}
+	conn.SetDeadline(time.Time{})
+
+	log.Printf("Reverse tunnel registered at %s as %s (%s)", rendezvous, api.NodeID, api.NodeIP)
+	// 与普通的入向连接相同地处理会合中继打开的流，保活由 SMUX 会话负责
+	api.handleProxyConnection(conn)
+	return errTunnelClosed
+}
+
+// acceptTunnel 会合中继处理一条注册连接：登记隧道并在会话结束后注销
+func (api *Module2API) acceptTunnel(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(tunnelHandshakeTimeout))
+	hello, err := readTunnelHello(conn)
+	if err != nil {
+		log.Printf("Failed to read reverse tunnel registration from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	// 未配置共享密钥时不接受任何注册，否则任何人都能以任意节点 IP 注册并接管发往该节点的流量
+	if !api.AcceptTunnels || api.TunnelToken == "" || hello.nodeIP == "" ||
+		subtle.ConstantTimeCompare([]byte(hello.token), []byte(api.TunnelToken)) != 1 {
+		log.Printf("Rejected reverse tunnel registration of %s (%s) from %s", hello.nodeID, hello.nodeIP, conn.RemoteAddr())
+		conn.Write([]byte{tunnelRejected})
+		return
+	}
+
+	// 先占住节点 IP 再回复，同一个节点 IP 已有可用的隧道时拒绝，新的注册不能替换正在使用的隧道
+	tunnel := &reverseTunnel{
+		info: TunnelInfo{
+			NodeID:     hello.nodeID,
+			NodeIP:     hello.nodeIP,
+			RemoteAddr: conn.RemoteAddr().String(),
+			Registered: time.Now(),
+		},
+	}
+	if !api.addTunnel(tunnel) {
+		log.Printf("Rejected reverse tunnel registration of %s (%s) from %s: a live tunnel is already registered", hello.nodeID, hello.nodeIP, conn.RemoteAddr())
+		conn.Write([]byte{tunnelRejected})
+		return
+	}
+	defer api.removeTunnel(tunnel)
+	if _, err := conn.Write([]byte{tunnelAccepted}); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	session, err := smux.Client(conn, api.Links.SessionConfig(hello.nodeIP, conn))
+	if err != nil {
+		log.Printf("Failed to create SMUX session for reverse tunnel: %v", err)
+		return
+	}
+	defer session.Close()
+
+	sessionID := api.Sessions.AddSession(DirectionOutbound, conn, session)
+	defer api.Sessions.RemoveSession(sessionID)
+	api.tunnelsMu.Lock()
+	tunnel.session = session
+	api.tunnelsMu.Unlock()
+	log.Printf("Reverse tunnel registered by %s (%s) from %s", hello.nodeID, hello.nodeIP, conn.RemoteAddr())
+
+	// 注册的节点不会打开流，AcceptStream 在会话关闭或底层连接断开时返回
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			log.Printf("Reverse tunnel of %s (%s) closed: %v", hello.nodeID, hello.nodeIP, err)
+			return
+		}
+		stream.Close()
+	}
+}
+
+// addTunnel 登记隧道，同一个节点 IP 已有未关闭的隧道或正在注册时返回 false
+func (api *Module2API) addTunnel(tunnel *reverseTunnel) bool {
+	api.tunnelsMu.Lock()
+	defer api.tunnelsMu.Unlock()
+	if api.tunnels == nil {
+		api.tunnels = make(map[string]*reverseTunnel)
+	}
+	if old, exists := api.tunnels[tunnel.info.NodeIP]; exists && (old.session == nil || !old.session.IsClosed()) {
+		return false
+	}
+	api.tunnels[tunnel.info.NodeIP] = tunnel
+	return true
+}
+
+// removeTunnel 注销隧道，已经被同一个节点的新隧道替换时不做任何事
+func (api *Module2API) removeTunnel(tunnel *reverseTunnel) {
+	api.tunnelsMu.Lock()
+	defer api.tunnelsMu.Unlock()
+	if api.tunnels[tunnel.info.NodeIP] == tunnel {
+		delete(api.tunnels, tunnel.info.NodeIP)
+	}
+}
+
+// tunnelSession 返回下一跳注册的隧道会话，没有注册时返回 nil
+func (api *Module2API) tunnelSession(nextHop string) *smux.Session {
+	api.tunnelsMu.Lock()
+	defer api.tunnelsMu.Unlock()
+	if tunnel, exists := api.tunnels[nextHop]; exists && tunnel.session != nil && !tunnel.session.IsClosed() {
+		return tunnel.session
+	}
+	return nil
+}
+
+// Tunnels 返回注册到本节点的所有反向隧道，不包括正在完成注册的隧道
+func (api *Module2API) Tunnels() []TunnelInfo {
+	api.tunnelsMu.Lock()
+	defer api.tunnelsMu.Unlock()
+	infos := make([]TunnelInfo, 0, len(api.tunnels))
+	for _, tunnel := range api.tunnels {
+		if tunnel.session == nil {
+			continue
+		}
+		info := tunnel.info
+		info.Streams = tunnel.session.NumStreams()
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].NodeIP < infos[j].NodeIP })
+	return infos
+}
+
+// openStream 到下一跳打开流：下一跳通过反向隧道注册到本节点时在隧道上打开，否则使用会话池，
+// 从会话池取流的过程记录为 pool.get span
+func (api *Module2API) openStream(ctx context.Context, nextHop string) (*smux2.Stream, error) {
+	if session := api.tunnelSession(nextHop); session != nil {
+		stream, err := session.OpenStream()
+		if err != nil {
+			// 隧道已经不可用，关闭后等待注册的节点重新连接
+			session.Close()
+			return nil, fmt.Errorf("reverse tunnel to %s: %w", nextHop, err)
+		}
+		return &smux2.Stream{Stream: stream, Session: session}, nil
+	}
+	pools, err := api.sessionPools()
+	if err != nil {
+		return nil, err
+	}
+	ctx, getSpan := tracing.StartChild(ctx, "pool.get", tracing.KindInternal)
+	getSpan.SetAttribute("overlay.next_hop", nextHop)
+	stream, err := pools.OpenStream(ctx, nextHop)
+	getSpan.Finish(err)
+	return stream, err
+}
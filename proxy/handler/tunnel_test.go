@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtaci/smux"
+)
+
+func TestAcceptTunnel(t *testing.T) {
+	api := NewModule2API(nil)
+	api.AcceptTunnels = true
+
+	// register 发送一条注册消息并返回会合中继的回复，接受时在连接上运行 SMUX 服务端直到测试结束
+	register := func(nodeID, token string) byte {
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		go api.acceptTunnel(server)
+
+		hello, err := tunnelHello{nodeID: nodeID, nodeIP: "10.0.0.9", token: token}.encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Write(hello); err != nil {
+			t.Fatal(err)
+		}
+		var reply [1]byte
+		if _, err := io.ReadFull(client, reply[:]); err != nil {
+			return tunnelRejected
+		}
+		if reply[0] == tunnelAccepted {
+			session, err := smux.Server(client, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { session.Close() })
+		}
+		return reply[0]
+	}
+	registered := func() string {
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if tunnels := api.Tunnels(); len(tunnels) == 1 {
+				return tunnels[0].NodeID
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return ""
+	}
+
+	// 会合中继没有设置共享密钥时拒绝所有注册
+	if reply := register("nat", ""); reply != tunnelRejected {
+		t.Fatal("expected a registration to be rejected without a configured token")
+	}
+
+	api.TunnelToken = "secret"
+	if reply := register("nat", "wrong"); reply != tunnelRejected {
+		t.Fatal("expected a registration with a wrong token to be rejected")
+	}
+	if reply := register("nat", "secret"); reply != tunnelAccepted {
+		t.Fatal("expected a registration with the right token to be accepted")
+	}
+	if id := registered(); id != "nat" {
+		t.Fatalf("expected the tunnel of nat to be registered, got %q", id)
+	}
+
+	// 同一个节点 IP 的新注册不能替换正在使用的隧道
+	if reply := register("rogue", "secret"); reply != tunnelRejected {
+		t.Fatal("expected a second registration for a live tunnel to be rejected")
+	}
+	if id := registered(); id != "nat" || api.tunnelSession("10.0.0.9") == nil {
+		t.Fatalf("expected the original tunnel to stay registered, got %q", id)
+	}
+}
@@ -0,0 +1,678 @@
+package smux_usage
+
+import (
+	"context"
+	"demo1/proxy/connection"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtaci/smux"
+)
+
+var (
+	// ErrPoolClosed 会话池已经关闭
+	ErrPoolClosed = errors.New("smux session pool is closed")
+	// ErrBackoff 所有会话都已断开，重连处于退避中
+	ErrBackoff = errors.New("smux session pool is backing off after connect failures")
+)
+
+// PoolConfig 会话池的参数
+type PoolConfig struct {
+	Size       int           // 每个下一跳最多保持的会话数，默认 1
+	MaxStreams int           // 每个会话上同时打开的流数上限，达到上限时使用其他会话或等待，0 表示不限
+	MinBackoff time.Duration // 连接失败后第一次重连前的等待时间，之后每次失败加倍
+	MaxBackoff time.Duration // 重连等待时间的上限
+	// Config 创建会话使用的 SMUX 配置，为空时使用 smux.DefaultConfig
+	Config *smux.Config
+	// SessionConfig 按新建立的底层连接返回 SMUX 配置，例如根据测得的 RTT 调整窗口；设置时代替 Config
+	SessionConfig func(conn net.Conn) *smux.Config
+	// OnSession 新会话建立后调用，返回的函数在会话关闭后调用，用于登记和注销会话
+	OnSession func(conn net.Conn, session *smux.Session) func()
+	// MaxIdleTime 没有流的会话空闲超过该时长后关闭；MaxLifetime 会话建立超过该时长后移出会话池，
+	// 现有的流结束后关闭。0 表示不限
+	MaxIdleTime time.Duration
+	MaxLifetime time.Duration
+	// Ping 在没有流的会话上打开新的流之前检查底层连接，返回错误的会话被关闭，为空表示不检查。
+	// 可以使用 connection.CheckConn
+	Ping func(conn net.Conn) error
+}
+
+// PoolStats 会话池的统计信息，计数从会话池创建时开始累计
+type PoolStats struct {
+	Sessions     int    `json:"sessions"`      // 池中的会话数
+	Streams      int    `json:"streams"`       // 池中的会话上打开的流数
+	Dials        uint64 `json:"dials"`         // 建立会话的次数
+	DialErrors   uint64 `json:"dial_errors"`   // 建立会话失败的次数
+	Expired      uint64 `json:"expired"`       // 因空闲或超过生存时间被关闭的会话数
+	PingFailures uint64 `json:"ping_failures"` // 复用空闲会话前检查失败的次数
+	Reclaimed    uint64 `json:"reclaimed"`     // 本节点的连接总数达到上限时为其他下一跳关闭的空闲会话数
+}
+
+// Add 累加另一个会话池的统计信息，用于汇总到所有下一跳的会话池
+func (s *PoolStats) Add(other PoolStats) {
+	s.Sessions += other.Sessions
+	s.Streams += other.Streams
+	s.Dials += other.Dials
+	s.DialErrors += other.DialErrors
+	s.Expired += other.Expired
+	s.PingFailures += other.PingFailures
+	s.Reclaimed += other.Reclaimed
+}
+
+// 会话池的默认参数
+const (
+	DefaultPoolSize   = 2
+	DefaultMaxStreams = 256
+	DefaultMinBackoff = 50 * time.Millisecond
+	DefaultMaxBackoff = 5 * time.Second
+)
+
+const (
+	// retireInterval 移出会话池的会话检查流是否全部结束的间隔
+	retireInterval = 100 * time.Millisecond
+	// reclaimMinIdle 会话至少空闲这么久才能被连接池回收
+	reclaimMinIdle = 100 * time.Millisecond
+)
+
+// sessionSlot 会话池中的一个位置，最多持有一个会话
+type sessionSlot struct {
+	session  *smux.Session
+	conn     net.Conn  // 会话的底层连接
+	created  time.Time // 会话建立的时间
+	lastUsed time.Time // 最近一次打开或关闭流的时间
+	release  func()    // OnSession 返回的注销函数
+	dialing  bool
+	failures int       // 连续连接失败的次数
+	retryAt  time.Time // 退避结束的时间
+}
+
+// SessionPool 到同一个下一跳的一组 SMUX 会话。新的流打开在流数最少的会话上，
+// 所有会话都有流时在后台建立新的会话，直到 Size 个；会话断开后在下一次使用时按退避重新连接
+type SessionPool struct {
+	mu      sync.Mutex
+	dial    func(ctx context.Context) (net.Conn, error)
+	cfg     PoolConfig
+	slots   []sessionSlot
+	changed chan struct{} // 有流关闭或会话状态变化时关闭并替换，唤醒等待的调用方
+	lastErr error         // 最近一次连接失败的原因
+	closed  bool
+	// ctx 在会话池关闭时取消，用于后台建立的会话和定期回收
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	dials        atomic.Uint64
+	dialErrors   atomic.Uint64
+	expired      atomic.Uint64
+	pingFailures atomic.Uint64
+	reclaimed    atomic.Uint64
+}
+
+// NewSessionPool 创建会话池，dial 建立到下一跳的底层连接。会话在第一次打开流时才建立
+func NewSessionPool(dial func(ctx context.Context) (net.Conn, error), cfg PoolConfig) *SessionPool {
+	if cfg.Size <= 0 {
+		cfg.Size = 1
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = max(DefaultMaxBackoff, cfg.MinBackoff)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &SessionPool{
+		dial:    dial,
+		cfg:     cfg,
+		slots:   make([]sessionSlot, cfg.Size),
+		changed: make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	if interval := p.reapInterval(); interval > 0 {
+		go p.reap(interval)
+	}
+	return p
+}
+
+// OpenStream 在负载最低的会话上打开一个流。负载最低的会话也有流时在后台建立新的会话，
+// 本次仍使用现有会话；没有可用会话时建立新的会话并等待它完成，已有会话正在建立时等待该会话；
+// 所有会话的流数都达到上限时等待其他流关闭，直到 ctx 结束；
+// 所有会话都已断开且处于重连退避中时立即返回 ErrBackoff
+func (p *SessionPool) OpenStream(ctx context.Context) (*Stream, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		now := time.Now()
+		p.prune(now)
+		best := p.leastLoaded()
+		empty := p.dialable(now)
+
+		if best >= 0 {
+			s := &p.slots[best]
+			session, conn, idle := s.session, s.conn, s.session.NumStreams() == 0
+			s.lastUsed = now
+			if !idle && empty >= 0 {
+				p.slots[empty].dialing = true
+				go p.connect(p.ctx, empty)
+			}
+			p.mu.Unlock()
+
+			// 空闲的会话在使用前检查底层连接，对端已经关闭时换用其他会话
+			if idle && p.cfg.Ping != nil {
+				if err := p.cfg.Ping(conn); err != nil {
+					p.pingFailures.Add(1)
+					discardConn(conn)
+					session.Close()
+					continue
+				}
+			}
+			stream, err := session.OpenStream()
+			if err != nil {
+				// 会话不再可用（例如流 ID 耗尽），关闭后由下一轮重新连接
+				session.Close()
+				continue
+			}
+			return p.wrap(session, stream), nil
+		}
+
+		// 已经有会话正在建立时等待它完成后共用，不同时占用更多的连接
+		if empty >= 0 && !p.connecting() {
+			p.slots[empty].dialing = true
+			p.mu.Unlock()
+			session, err := p.connect(ctx, empty)
+			if err != nil {
+				return nil, err
+			}
+			stream, err := session.OpenStream()
+			if err != nil {
+				session.Close()
+				return nil, err
+			}
+			return p.wrap(session, stream), nil
+		}
+
+		// 所有会话都已断开，没有正在进行的连接，重连仍在退避中
+		if best < 0 && p.live() == 0 && !p.connecting() {
+			err := fmt.Errorf("%w: %v", ErrBackoff, p.lastErr)
+			p.mu.Unlock()
+			return nil, err
+		}
+
+		// 等待其他流关闭或者正在建立的会话完成
+		changed := p.changed
+		p.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// prune 清除已经关闭的会话，关闭空闲过久的会话，把超过生存时间的会话移出会话池，调用方持有锁
+func (p *SessionPool) prune(now time.Time) {
+	for i := range p.slots {
+		s := &p.slots[i]
+		switch {
+		case s.session == nil:
+		case s.session.IsClosed():
+			p.clear(i)
+		case p.cfg.MaxLifetime > 0 && now.Sub(s.created) >= p.cfg.MaxLifetime:
+			p.expired.Add(1)
+			discardConn(s.conn)
+			p.retire(i)
+		case p.cfg.MaxIdleTime > 0 && s.session.NumStreams() == 0 && now.Sub(s.lastUsed) >= p.cfg.MaxIdleTime:
+			p.expired.Add(1)
+			discardConn(s.conn)
+			s.session.Close()
+			p.clear(i)
+		}
+	}
+}
+
+// discardConn 会话因过期或检查失败而关闭时，让连接池把底层连接计为丢弃而不是正常关闭
+func discardConn(conn net.Conn) {
+	if pc, ok := conn.(*connection.PoolConn); ok {
+		pc.MarkUnusable()
+	}
+}
+
+// clear 清空一个位置并注销会话，调用方持有锁
+func (p *SessionPool) clear(i int) {
+	s := &p.slots[i]
+	if s.release != nil {
+		s.release()
+	}
+	s.session, s.conn, s.release = nil, nil, nil
+	p.notify()
+}
+
+// detach 清空一个位置但不关闭会话，返回会话及其注销函数，调用方持有锁
+func (p *SessionPool) detach(i int) (*smux.Session, func()) {
+	s := &p.slots[i]
+	session, release := s.session, s.release
+	s.session, s.conn, s.release = nil, nil, nil
+	p.notify()
+	return session, release
+}
+
+// retire 把会话移出会话池，新的流不再使用它，现有的流全部结束后关闭，调用方持有锁
+func (p *SessionPool) retire(i int) {
+	session, release := p.detach(i)
+	go func() {
+		ticker := time.NewTicker(retireInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if session.IsClosed() || session.NumStreams() == 0 {
+				break
+			}
+		}
+		session.Close()
+		if release != nil {
+			release()
+		}
+	}()
+}
+
+// reapInterval 返回定期回收会话的间隔，不需要回收时返回 0
+func (p *SessionPool) reapInterval() time.Duration {
+	limit := p.cfg.MaxIdleTime
+	if limit == 0 || (p.cfg.MaxLifetime > 0 && p.cfg.MaxLifetime < limit) {
+		limit = p.cfg.MaxLifetime
+	}
+	return limit / 2
+}
+
+// reap 定期回收空闲过久和超过生存时间的会话，不必等到下一次打开流，会话池关闭时退出
+func (p *SessionPool) reap(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			if !p.closed {
+				p.prune(time.Now())
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// leastLoaded 返回流数最少且未达到上限的会话位置，没有时返回 -1，调用方持有锁
+func (p *SessionPool) leastLoaded() int {
+	best, bestStreams := -1, 0
+	for i := range p.slots {
+		s := &p.slots[i]
+		if s.session == nil {
+			continue
+		}
+		n := s.session.NumStreams()
+		if p.cfg.MaxStreams > 0 && n >= p.cfg.MaxStreams {
+			continue
+		}
+		if best < 0 || n < bestStreams {
+			best, bestStreams = i, n
+		}
+	}
+	return best
+}
+
+// dialable 返回可以建立新会话的空位置，没有时返回 -1，调用方持有锁
+func (p *SessionPool) dialable(now time.Time) int {
+	for i := range p.slots {
+		s := &p.slots[i]
+		if s.session == nil && !s.dialing && !now.Before(s.retryAt) {
+			return i
+		}
+	}
+	return -1
+}
+
+// live 返回持有会话的位置数，调用方持有锁
+func (p *SessionPool) live() int {
+	n := 0
+	for i := range p.slots {
+		if p.slots[i].session != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// connecting 判断是否有正在建立的会话，调用方持有锁
+func (p *SessionPool) connecting() bool {
+	for i := range p.slots {
+		if p.slots[i].dialing {
+			return true
+		}
+	}
+	return false
+}
+
+// connect 为位置 i 建立会话，失败时按连续失败次数设置退避时间。
+// 等待本节点的连接数上限超时不是下一跳的故障，不计入连续失败
+func (p *SessionPool) connect(ctx context.Context, i int) (*smux.Session, error) {
+	p.dials.Add(1)
+	conn, err := p.dial(ctx)
+	var session *smux.Session
+	if err == nil {
+		config := p.cfg.Config
+		if p.cfg.SessionConfig != nil {
+			config = p.cfg.SessionConfig(conn)
+		}
+		watched := &closeOnReadError{Conn: conn}
+		if session, err = smux.Client(watched, config); err != nil {
+			conn.Close()
+		} else {
+			watched.setSession(session)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := &p.slots[i]
+	s.dialing = false
+	defer p.notify()
+	if err != nil {
+		p.dialErrors.Add(1)
+		p.lastErr = err
+		if !errors.Is(err, connection.ErrWaitTimeout) {
+			s.failures++
+			s.retryAt = time.Now().Add(p.backoff(s.failures))
+		}
+		return nil, err
+	}
+	if p.closed {
+		session.Close()
+		return nil, ErrPoolClosed
+	}
+
+	now := time.Now()
+	s.failures, s.retryAt = 0, time.Time{}
+	s.session, s.conn, s.created, s.lastUsed = session, conn, now, now
+	if p.cfg.OnSession != nil {
+		s.release = p.cfg.OnSession(conn, session)
+	}
+	// 本节点的连接总数达到上限时，连接池可以请求关闭这个会话，为其他下一跳空出连接
+	if pc, ok := conn.(*connection.PoolConn); ok {
+		pc.SetReclaim(func() bool { return p.reclaim(session) })
+	}
+	go p.watch(i, session)
+	return session, nil
+}
+
+// reclaim 会话没有流且空闲了至少 reclaimMinIdle 时关闭它，释放底层连接。
+// 刚建立或刚被选中、还没有打开流的会话不会被关闭
+func (p *SessionPool) reclaim(session *smux.Session) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.slots {
+		s := &p.slots[i]
+		if s.session != session {
+			continue
+		}
+		if session.NumStreams() > 0 || time.Since(s.lastUsed) < reclaimMinIdle {
+			return false
+		}
+		p.reclaimed.Add(1)
+		session.Close()
+		p.clear(i)
+		return true
+	}
+	return false
+}
+
+// watch 会话关闭后立即清出会话池，不必等到下一次打开流
+func (p *SessionPool) watch(i int, session *smux.Session) {
+	<-session.CloseChan()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.slots[i].session == session {
+		log.Printf("SMUX session to next hop closed, slot %d will reconnect on demand", i)
+		p.clear(i)
+	}
+}
+
+// closeOnReadError 底层连接读失败时关闭会话。SMUX 会话在读失败后不会自行关闭，
+// 要等到保活超时，期间会话池会继续在断开的会话上打开流
+type closeOnReadError struct {
+	net.Conn
+	session atomic.Pointer[smux.Session]
+	failed  atomic.Bool
+}
+
+func (c *closeOnReadError) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil && !c.failed.Swap(true) {
+		if session := c.session.Load(); session != nil {
+			go session.Close()
+		}
+	}
+	return n, err
+}
+
+// setSession 记录使用连接的会话，会话创建前连接已经读失败时立即关闭会话
+func (c *closeOnReadError) setSession(session *smux.Session) {
+	c.session.Store(session)
+	if c.failed.Load() {
+		session.Close()
+	}
+}
+
+// backoff 返回第 failures 次连续失败后的退避时间，带有 ±20% 的随机抖动
+func (p *SessionPool) backoff(failures int) time.Duration {
+	d := p.cfg.MaxBackoff
+	if shift := failures - 1; shift < 32 {
+		d = min(p.cfg.MinBackoff<<shift, p.cfg.MaxBackoff)
+	}
+	jitter := time.Duration(rand.Int64N(int64(d)/5 + 1))
+	if rand.IntN(2) == 0 {
+		return d - jitter
+	}
+	return d + jitter
+}
+
+// notify 唤醒等待的调用方，调用方持有锁
+func (p *SessionPool) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// wrap 封装流，关闭时唤醒等待流数的调用方
+func (p *SessionPool) wrap(session *smux.Session, stream *smux.Stream) *Stream {
+	return &Stream{Stream: stream, Session: session, pool: p}
+}
+
+// Detach 把会话移出会话池，之后的流打开在其他会话或新建的会话上。
+// 会话本身不关闭，已有的流继续使用，OnSession 的注销函数在会话关闭后调用。会话不在池中时返回 false
+func (p *SessionPool) Detach(session *smux.Session) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.slots {
+		if p.slots[i].session != session {
+			continue
+		}
+		if _, release := p.detach(i); release != nil {
+			go func() {
+				<-session.CloseChan()
+				release()
+			}()
+		}
+		return true
+	}
+	return false
+}
+
+// Sessions 返回当前打开的会话数
+func (p *SessionPool) Sessions() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for i := range p.slots {
+		if s := p.slots[i].session; s != nil && !s.IsClosed() {
+			n++
+		}
+	}
+	return n
+}
+
+// Stats 返回会话池的统计信息
+func (p *SessionPool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := PoolStats{
+		Dials:        p.dials.Load(),
+		DialErrors:   p.dialErrors.Load(),
+		Expired:      p.expired.Load(),
+		PingFailures: p.pingFailures.Load(),
+		Reclaimed:    p.reclaimed.Load(),
+	}
+	for i := range p.slots {
+		if s := p.slots[i].session; s != nil && !s.IsClosed() {
+			stats.Sessions++
+			stats.Streams += s.NumStreams()
+		}
+	}
+	return stats
+}
+
+// Close 关闭会话池和其中所有的会话
+func (p *SessionPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	p.cancel()
+	for i := range p.slots {
+		if s := p.slots[i].session; s != nil {
+			s.Close()
+			p.clear(i)
+		}
+	}
+}
+
+// Stream 会话池中打开的流，关闭时通知会话池。会话由会话池管理，使用方只关闭流；
+// 直接在会话上打开的流不属于任何会话池
+type Stream struct {
+	*smux.Stream
+	Session *smux.Session // 流所在的会话
+	pool    *SessionPool
+	once    sync.Once
+}
+
+// Close 关闭流
+func (s *Stream) Close() error {
+	err := s.Stream.Close()
+	if s.pool == nil {
+		return err
+	}
+	s.once.Do(func() {
+		s.pool.mu.Lock()
+		for i := range s.pool.slots {
+			if slot := &s.pool.slots[i]; slot.session == s.Session {
+				slot.lastUsed = time.Now()
+			}
+		}
+		s.pool.notify()
+		s.pool.mu.Unlock()
+	})
+	return err
+}
+
+// SessionPools 按下一跳管理会话池，第一次使用某个下一跳时创建
+type SessionPools struct {
+	mu     sync.Mutex
+	dial   func(ctx context.Context, key string) (net.Conn, error)
+	cfg    func(key string) PoolConfig
+	pools  map[string]*SessionPool
+	closed bool
+}
+
+// NewSessionPools 创建按下一跳管理的会话池，dial 建立到某个下一跳的底层连接，
+// cfg 返回到某个下一跳的会话池参数，在第一次使用该下一跳时调用
+func NewSessionPools(dial func(ctx context.Context, key string) (net.Conn, error), cfg func(key string) PoolConfig) *SessionPools {
+	return &SessionPools{dial: dial, cfg: cfg, pools: make(map[string]*SessionPool)}
+}
+
+// Pool 返回到 key 的会话池，不存在时创建
+func (ps *SessionPools) Pool(key string) (*SessionPool, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.closed {
+		return nil, ErrPoolClosed
+	}
+	pool, exists := ps.pools[key]
+	if !exists {
+		pool = NewSessionPool(func(ctx context.Context) (net.Conn, error) { return ps.dial(ctx, key) }, ps.cfg(key))
+		ps.pools[key] = pool
+	}
+	return pool, nil
+}
+
+// OpenStream 在到 key 的会话池中打开一个流
+func (ps *SessionPools) OpenStream(ctx context.Context, key string) (*Stream, error) {
+	pool, err := ps.Pool(key)
+	if err != nil {
+		return nil, err
+	}
+	return pool.OpenStream(ctx)
+}
+
+// Stats 返回到每个 key 的会话池的统计信息
+func (ps *SessionPools) Stats() map[string]PoolStats {
+	ps.mu.Lock()
+	pools := make(map[string]*SessionPool, len(ps.pools))
+	for key, pool := range ps.pools {
+		pools[key] = pool
+	}
+	ps.mu.Unlock()
+
+	stats := make(map[string]PoolStats, len(pools))
+	for key, pool := range pools {
+		stats[key] = pool.Stats()
+	}
+	return stats
+}
+
+// Detach 把会话移出所在的会话池，会话不属于任何会话池时返回 false
+func (ps *SessionPools) Detach(session *smux.Session) bool {
+	ps.mu.Lock()
+	pools := make([]*SessionPool, 0, len(ps.pools))
+	for _, pool := range ps.pools {
+		pools = append(pools, pool)
+	}
+	ps.mu.Unlock()
+
+	for _, pool := range pools {
+		if pool.Detach(session) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close 关闭所有会话池
+func (ps *SessionPools) Close() {
+	ps.mu.Lock()
+	pools := ps.pools
+	ps.pools = make(map[string]*SessionPool)
+	ps.closed = true
+	ps.mu.Unlock()
+
+	for _, pool := range pools {
+		pool.Close()
+	}
+}
@@ -0,0 +1,255 @@
+package smux_usage
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xtaci/smux"
+)
+
+// serve 启动接受 SMUX 会话的本地服务器，返回拨号函数和接受到的会话
+func serve(t *testing.T) (func(context.Context) (net.Conn, error), chan *smux.Session) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := make(chan *smux.Session, 16)
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			session, _ := smux.Server(conn, nil)
+			sessions <- session
+			go func() {
+				for {
+					if _, err := session.AcceptStream(); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	var dialer net.Dialer
+	return func(ctx context.Context) (net.Conn, error) {
+		return dialer.DialContext(ctx, "tcp", listener.Addr().String())
+	}, sessions
+}
+
+func TestSessionPoolSpreadsAndCapsStreams(t *testing.T) {
+	dial, _ := serve(t)
+	pool := NewSessionPool(dial, PoolConfig{Size: 2, MaxStreams: 1})
+	defer pool.Close()
+
+	ctx := context.Background()
+	first, err := pool.OpenStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pool.OpenStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Session == second.Session || pool.Sessions() != 2 {
+		t.Fatalf("expected streams on two sessions, got %d sessions", pool.Sessions())
+	}
+
+	// 两个会话都达到流数上限，等待直到有流关闭
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.OpenStream(timeout); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected to wait at the stream cap, got %v", err)
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		first.Close()
+	}()
+	third, err := pool.OpenStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Session != first.Session {
+		t.Error("expected the freed session to be reused")
+	}
+}
+
+func TestSessionPoolReconnects(t *testing.T) {
+	dial, accepted := serve(t)
+	var fail atomic.Bool
+	pool := NewSessionPool(func(ctx context.Context) (net.Conn, error) {
+		if fail.Load() {
+			return nil, errors.New("refused")
+		}
+		return dial(ctx)
+	}, PoolConfig{Size: 1, MinBackoff: 30 * time.Millisecond})
+	defer pool.Close()
+
+	stream, err := pool.OpenStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+
+	// 对端关闭会话，下一次连接失败后进入退避
+	(<-accepted).Close()
+	<-stream.Session.CloseChan()
+	fail.Store(true)
+	if _, err := pool.OpenStream(context.Background()); err == nil {
+		t.Fatal("expected dial failure")
+	}
+	if _, err := pool.OpenStream(context.Background()); !errors.Is(err, ErrBackoff) {
+		t.Fatalf("expected ErrBackoff, got %v", err)
+	}
+
+	// 退避结束后重新连接
+	fail.Store(false)
+	time.Sleep(50 * time.Millisecond)
+	stream, err = pool.OpenStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+}
+
+func TestSessionPoolDetach(t *testing.T) {
+	dial, _ := serve(t)
+	var released atomic.Int32
+	pool := NewSessionPool(dial, PoolConfig{Size: 1, OnSession: func(net.Conn, *smux.Session) func() {
+		return func() { released.Add(1) }
+	}})
+	defer pool.Close()
+
+	first, err := pool.OpenStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pool.Detach(first.Session) {
+		t.Fatal("expected the session to be detached")
+	}
+
+	// 移出的会话继续承载已有的流，新的流打开在新建的会话上
+	second, err := pool.OpenStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Session == first.Session {
+		t.Error("expected a new session after detaching")
+	}
+	if first.Session.IsClosed() || released.Load() != 0 {
+		t.Fatal("expected the detached session to stay open and registered")
+	}
+
+	first.Session.Close()
+	deadline := time.Now().Add(time.Second)
+	for released.Load() != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if released.Load() != 1 {
+		t.Error("expected the detached session to be released once closed")
+	}
+}
+
+func TestSessionPoolExpiresSessions(t *testing.T) {
+	dial, _ := serve(t)
+	eventually := func(what string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+
+	// 没有流的会话空闲超过 MaxIdleTime 后由后台回收
+	idle := NewSessionPool(dial, PoolConfig{Size: 1, MaxIdleTime: 20 * time.Millisecond})
+	defer idle.Close()
+	stream, err := idle.OpenStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+	eventually("the idle session to be closed", func() bool { return stream.Session.IsClosed() })
+	if stats := idle.Stats(); stats.Sessions != 0 || stats.Expired != 1 {
+		t.Errorf("unexpected stats after idle expiry %+v", stats)
+	}
+
+	// 超过 MaxLifetime 的会话不再打开新的流，现有的流结束后关闭
+	aged := NewSessionPool(dial, PoolConfig{Size: 1, MaxLifetime: 20 * time.Millisecond})
+	defer aged.Close()
+	old, err := aged.OpenStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	fresh, err := aged.OpenStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh.Session == old.Session {
+		t.Fatal("expected a new session after the lifetime expired")
+	}
+	if old.Session.IsClosed() {
+		t.Fatal("expected the expired session to stay open for its stream")
+	}
+	old.Close()
+	eventually("the expired session to be closed", func() bool { return old.Session.IsClosed() })
+	fresh.Close()
+}
+
+func TestSessionPoolGrowsInBackground(t *testing.T) {
+	dial, _ := serve(t)
+	release := make(chan struct{})
+	var dials atomic.Int32
+	pool := NewSessionPool(func(ctx context.Context) (net.Conn, error) {
+		// 第二个会话的连接迟迟建立不起来，请求不能等它
+		if dials.Add(1) > 1 {
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		return dial(ctx)
+	}, PoolConfig{Size: 2})
+	defer pool.Close()
+
+	first, err := pool.OpenStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	second, err := pool.OpenStream(timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Session != first.Session {
+		t.Error("expected the busy session to be used while a new one is dialed")
+	}
+	close(release)
+	for deadline := time.Now().Add(time.Second); pool.Sessions() != 2; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the pool to grow to two sessions in the background")
+		}
+	}
+}
@@ -4,16 +4,27 @@ import (
 	"github.com/xtaci/smux"
 	"log"
 	"net"
+	"sync"
 	"time"
 )
 
-// smuxSessions 用来维护 TCP 连接与 SMUX 会话之间的映射关系
-var smuxSessions = make(map[net.Conn]*smux.Session)
+var (
+	// smuxSessions 用来维护 TCP 连接与 SMUX 会话之间的映射关系，会话关闭后自动删除。
+	// 转发请求应使用按下一跳管理的 SessionPools，这里只保留给直接持有连接的调用方
+	smuxSessions   = make(map[net.Conn]*smux.Session)
+	smuxSessionsMu sync.Mutex
+
+	// DefaultLink CreateSMUXSession 和 AcceptSMUXSession 使用的链路参数
+	DefaultLink = LinkConfig{KeepAliveTimeout: 10 * time.Second}
+)
 
 // GetOrCreateSMUXSession 创建或获取SMUX会话
 func GetOrCreateSMUXSession(conn net.Conn) (*smux.Session, error) {
-	// 检查是否已有与该连接相关的SMUX会话
-	if session, exists := smuxSessions[conn]; exists {
+	smuxSessionsMu.Lock()
+	defer smuxSessionsMu.Unlock()
+
+	// 检查是否已有与该连接相关、仍然可用的SMUX会话
+	if session, exists := smuxSessions[conn]; exists && !session.IsClosed() {
 		return session, nil
 	}
 
@@ -24,26 +35,39 @@ func GetOrCreateSMUXSession(conn net.Conn) (*smux.Session, error) {
 		return nil, err
 	}
 
-	// 将SMUX会话保存到映射中
+	// 将SMUX会话保存到映射中，会话关闭后删除
 	smuxSessions[conn] = session
-	log.Println("SMUX session created successfully")
+	go func() {
+		<-session.CloseChan()
+		smuxSessionsMu.Lock()
+		defer smuxSessionsMu.Unlock()
+		if smuxSessions[conn] == session {
+			delete(smuxSessions, conn)
+		}
+	}()
 	return session, nil
 }
 
 // CloseSMUXSessionByConn 关闭与给定 TCP 连接相关的 SMUX 会话
 func CloseSMUXSessionByConn(conn net.Conn) {
-	if session, exists := smuxSessions[conn]; exists {
+	smuxSessionsMu.Lock()
+	session, exists := smuxSessions[conn]
+	delete(smuxSessions, conn) // 删除映射，释放资源
+	smuxSessionsMu.Unlock()
+
+	if exists {
 		session.Close()
-		delete(smuxSessions, conn) // 删除映射，释放资源
 		log.Println("SMUX session closed and removed from session map")
 	}
 }
 
 // CreateSMUXSession 创建一个 SMUX 客户端会话，通过给定的 TCP 连接
 func CreateSMUXSession(conn net.Conn) (*smux.Session, error) {
-	// 创建 SMUX 配置，这里可以设置超时、流控制等参数
-	config := smux.DefaultConfig()
-	config.KeepAliveTimeout = 10 * time.Second // 配置保持活动超时
+	// 按 DefaultLink 设置超时、流控制等参数
+	config, err := DefaultLink.SMUXConfig()
+	if err != nil {
+		return nil, err
+	}
 
 	// 通过 TCP 连接创建一个 SMUX 会话
 	session, err := smux.Client(conn, config)
@@ -58,8 +82,11 @@ func CreateSMUXSession(conn net.Conn) (*smux.Session, error) {
 
 // AcceptSMUXSession 用于在代理 B 或服务端接受 SMUX 连接
 func AcceptSMUXSession(conn net.Conn) (*smux.Session, error) {
-	// 使用默认配置创建 SMUX 会话
-	config := smux.DefaultConfig()
+	// 与客户端使用相同的链路参数，两端的协议版本必须一致
+	config, err := DefaultLink.SMUXConfig()
+	if err != nil {
+		return nil, err
+	}
 
 	// 在已有的 TCP 连接上创建一个 SMUX 服务端会话
 	session, err := smux.Server(conn, config)
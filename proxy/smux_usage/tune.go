@@ -0,0 +1,119 @@
+package smux_usage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xtaci/smux"
+)
+
+// LinkConfig 一条链路上 SMUX 会话的流控和保活参数，零值的字段使用 smux.DefaultConfig 的值，
+// 开启 AutoTune 时由测得的 RTT 和吞吐量计算
+type LinkConfig struct {
+	// Version SMUX 协议版本，链路两端必须一致。版本 2 按流做流量控制，MaxStreamBuffer 只在版本 2 下生效
+	Version           int           `json:"version,omitempty"`
+	MaxReceiveBuffer  int           `json:"max_receive_buffer,omitempty"` // 整个会话的接收缓冲区，字节
+	MaxStreamBuffer   int           `json:"max_stream_buffer,omitempty"`  // 单个流的接收窗口，字节，决定单个流在长距离链路上的吞吐量上限
+	MaxFrameSize      int           `json:"max_frame_size,omitempty"`     // 单个数据帧的大小上限，不超过 65535
+	KeepAliveInterval time.Duration `json:"keepalive_interval,omitempty"` // 发送保活帧的间隔
+	KeepAliveTimeout  time.Duration `json:"keepalive_timeout,omitempty"`  // 超过该时长没有收到任何数据时关闭会话
+	AutoTune          bool          `json:"auto_tune"`                    // 按链路的带宽时延积调整未设置的参数
+}
+
+// 自动调整的范围
+const (
+	// DefaultLinkBandwidth 还没有测得吞吐量时估计的链路带宽，字节每秒（100 Mbit/s）
+	DefaultLinkBandwidth = 12_500_000
+	// MaxTunedStreamBuffer 自动调整时单个流接收窗口的上限
+	MaxTunedStreamBuffer = 32 << 20
+	// MaxTunedReceiveBuffer 自动调整时会话接收缓冲区的上限
+	MaxTunedReceiveBuffer = 128 << 20
+	// maxFrameSize SMUX 协议允许的最大帧
+	maxFrameSize = 65535
+)
+
+// Merge 用 override 中设置了的字段覆盖 c，返回合并后的参数
+func (c LinkConfig) Merge(override LinkConfig) LinkConfig {
+	if override.MaxReceiveBuffer > 0 {
+		c.MaxReceiveBuffer = override.MaxReceiveBuffer
+	}
+	if override.MaxStreamBuffer > 0 {
+		c.MaxStreamBuffer = override.MaxStreamBuffer
+	}
+	if override.MaxFrameSize > 0 {
+		c.MaxFrameSize = override.MaxFrameSize
+	}
+	if override.KeepAliveInterval > 0 {
+		c.KeepAliveInterval = override.KeepAliveInterval
+	}
+	if override.KeepAliveTimeout > 0 {
+		c.KeepAliveTimeout = override.KeepAliveTimeout
+	}
+	if override.Version > 0 {
+		c.Version = override.Version
+	}
+	c.AutoTune = c.AutoTune || override.AutoTune
+	return c
+}
+
+// Tune 按 RTT 和吞吐量（字节每秒）填充未设置的参数：流窗口取两倍带宽时延积，
+// 会话缓冲区容纳四个满窗口的流，保活超时至少覆盖几个 RTT。显式设置的字段保持不变；
+// 未开启 AutoTune 或 RTT 未知时原样返回
+func (c LinkConfig) Tune(rtt time.Duration, throughput float64) LinkConfig {
+	if !c.AutoTune || rtt <= 0 {
+		return c
+	}
+	if throughput <= 0 {
+		throughput = DefaultLinkBandwidth
+	}
+	defaults := smux.DefaultConfig()
+	bdp := int(throughput * rtt.Seconds())
+
+	if c.MaxStreamBuffer == 0 {
+		c.MaxStreamBuffer = min(max(2*bdp, defaults.MaxStreamBuffer), MaxTunedStreamBuffer)
+	}
+	if c.MaxReceiveBuffer == 0 {
+		c.MaxReceiveBuffer = min(max(4*c.MaxStreamBuffer, defaults.MaxReceiveBuffer), MaxTunedReceiveBuffer)
+	}
+	// 窗口较大时使用最大的帧，减少帧头和系统调用的开销
+	if c.MaxFrameSize == 0 && bdp > 1<<20 {
+		c.MaxFrameSize = maxFrameSize
+	}
+	if c.KeepAliveTimeout == 0 {
+		interval := c.KeepAliveInterval
+		if interval == 0 {
+			interval = defaults.KeepAliveInterval
+		}
+		c.KeepAliveTimeout = max(defaults.KeepAliveTimeout, 2*interval+4*rtt)
+	}
+	return c
+}
+
+// SMUXConfig 返回在 smux.DefaultConfig 上应用参数后的配置，参数不合法时返回错误
+func (c LinkConfig) SMUXConfig() (*smux.Config, error) {
+	config := smux.DefaultConfig()
+	if c.Version > 0 {
+		config.Version = c.Version
+	}
+	if c.MaxReceiveBuffer > 0 {
+		config.MaxReceiveBuffer = c.MaxReceiveBuffer
+	}
+	if c.MaxStreamBuffer > 0 {
+		config.MaxStreamBuffer = c.MaxStreamBuffer
+	}
+	if c.MaxFrameSize > 0 {
+		config.MaxFrameSize = c.MaxFrameSize
+	}
+	if c.KeepAliveInterval > 0 {
+		config.KeepAliveInterval = c.KeepAliveInterval
+	}
+	if c.KeepAliveTimeout > 0 {
+		config.KeepAliveTimeout = c.KeepAliveTimeout
+	}
+	// 会话缓冲区小于流窗口时流永远无法用满窗口
+	config.MaxReceiveBuffer = max(config.MaxReceiveBuffer, config.MaxStreamBuffer)
+	if err := smux.VerifyConfig(config); err != nil {
+		return nil, fmt.Errorf("invalid SMUX link config: %w", err)
+	}
+	return config, nil
+}
@@ -0,0 +1,37 @@
+package smux_usage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLinkConfigTune(t *testing.T) {
+	// 100ms、100MB/s 的链路，带宽时延积 10MB
+	base := LinkConfig{Version: 2, AutoTune: true}
+	tuned := base.Tune(100*time.Millisecond, 100e6)
+	if tuned.MaxStreamBuffer != 20e6 || tuned.MaxReceiveBuffer != 80e6 {
+		t.Errorf("expected windows sized from the BDP, got stream %d session %d", tuned.MaxStreamBuffer, tuned.MaxReceiveBuffer)
+	}
+	if tuned.MaxFrameSize != maxFrameSize {
+		t.Errorf("expected the largest frame on a high BDP link, got %d", tuned.MaxFrameSize)
+	}
+	config, err := tuned.SMUXConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Version != 2 || config.MaxStreamBuffer != tuned.MaxStreamBuffer {
+		t.Errorf("tuned values not applied: %+v", config)
+	}
+
+	// 显式设置的字段保持不变，窗口不超过上限
+	explicit := base.Merge(LinkConfig{MaxStreamBuffer: 1 << 20})
+	tuned = explicit.Tune(time.Second, 1e9)
+	if tuned.MaxStreamBuffer != 1<<20 || tuned.MaxReceiveBuffer != 4<<20 {
+		t.Errorf("expected the explicit stream buffer to be kept, got stream %d session %d", tuned.MaxStreamBuffer, tuned.MaxReceiveBuffer)
+	}
+
+	// 未开启自动调整时不修改
+	if manual := (LinkConfig{}).Tune(time.Second, 1e9); manual != (LinkConfig{}) {
+		t.Errorf("expected no tuning without AutoTune, got %+v", manual)
+	}
+}
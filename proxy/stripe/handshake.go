@@ -0,0 +1,198 @@
+package stripe
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// magic 通道握手的开头，第一个字节不是合法的 SMUX 协议版本（1 或 2）
+var magic = [4]byte{'O', 'V', 'L', 'B'}
+
+const (
+	handshakeLen = len(magic) + 16 + 2
+	// HandshakeTimeout 接收端读取通道握手、等待同一组的其他通道到达的最长时间
+	HandshakeTimeout = 10 * time.Second
+)
+
+// ErrHandshake 通道的握手不合法
+var ErrHandshake = errors.New("invalid stripe lane handshake")
+
+// Dial 并行建立 lanes 条通道并组成一条逻辑连接，dial 建立单条底层连接。任意一条通道失败时
+// 关闭已经建立的通道并返回错误
+func Dial(ctx context.Context, lanes int, dial func(ctx context.Context) (net.Conn, error), opts Options) (*Conn, error) {
+	if lanes < 1 || lanes > MaxLanes {
+		return nil, fmt.Errorf("stripe lanes must be between 1 and %d", MaxLanes)
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+
+	conns := make([]net.Conn, lanes)
+	errs := make([]error, lanes)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := dial(ctx)
+			if err == nil {
+				if _, err = conn.Write(handshake(id, i, lanes)); err != nil {
+					conn.Close()
+				}
+			}
+			conns[i], errs[i] = conn, err
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		for i, conn := range conns {
+			if errs[i] == nil {
+				conn.Close()
+			}
+		}
+		return nil, fmt.Errorf("failed to dial stripe lanes: %w", err)
+	}
+	return newConn(conns, opts), nil
+}
+
+// handshake 编码一条通道的握手
+func handshake(id [16]byte, index, lanes int) []byte {
+	b := make([]byte, 0, handshakeLen)
+	b = append(b, magic[:]...)
+	b = append(b, id[:]...)
+	return append(b, byte(index), byte(lanes))
+}
+
+// Sniff 读取连接的第一个字节，判断对端是否在建立条带化通道。
+// 返回的连接仍然从第一个字节开始读取，可以交给 Acceptor 或者直接作为普通连接使用
+func Sniff(conn net.Conn) (net.Conn, bool, error) {
+	conn, first, err := Peek(conn)
+	return conn, err == nil && IsLane(first), err
+}
+
+// IsLane 判断连接的第一个字节是否为条带化通道的握手
+func IsLane(first byte) bool {
+	return first == magic[0]
+}
+
+// Peek 读取连接的第一个字节，返回的连接仍然从第一个字节开始读取
+func Peek(conn net.Conn) (net.Conn, byte, error) {
+	var first [1]byte
+	if _, err := io.ReadFull(conn, first[:]); err != nil {
+		return conn, 0, err
+	}
+	return &prefixConn{Conn: conn, prefix: first[:]}, first[0], nil
+}
+
+// prefixConn 先读出已经从连接上读取的前缀，再读取连接本身
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// SyscallConn 返回底层连接，用于读取 TCP_INFO 等内核统计
+func (c *prefixConn) SyscallConn() (syscall.RawConn, error) {
+	if sc, ok := c.Conn.(syscall.Conn); ok {
+		return sc.SyscallConn()
+	}
+	return nil, errors.New("connection does not expose a syscall connection")
+}
+
+// Acceptor 接收端按组 ID 收集通道，同一组的通道全部到达后组成逻辑连接。
+// 在 HandshakeTimeout 内没有到齐的组被丢弃
+type Acceptor struct {
+	opts    Options
+	mu      sync.Mutex
+	pending map[[16]byte]*pendingGroup
+}
+
+// pendingGroup 还没有到齐的一组通道
+type pendingGroup struct {
+	lanes   []net.Conn
+	arrived int
+	timer   *time.Timer
+}
+
+// NewAcceptor 创建通道收集器，opts 为组成的逻辑连接的参数
+func NewAcceptor(opts Options) *Acceptor {
+	return &Acceptor{opts: opts, pending: make(map[[16]byte]*pendingGroup)}
+}
+
+// Add 读取一条通道的握手并登记。该通道是所在组最后到达的一条时返回组成的逻辑连接，
+// 否则返回 nil；握手不合法时关闭连接并返回错误
+func (a *Acceptor) Add(conn net.Conn) (*Conn, error) {
+	buf := make([]byte, handshakeLen)
+	conn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	_, err := io.ReadFull(conn, buf)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read stripe lane handshake: %w", err)
+	}
+
+	var id [16]byte
+	copy(id[:], buf[len(magic):])
+	index, lanes := int(buf[handshakeLen-2]), int(buf[handshakeLen-1])
+	if !bytes.Equal(buf[:len(magic)], magic[:]) || lanes < 1 || lanes > MaxLanes || index >= lanes {
+		conn.Close()
+		return nil, ErrHandshake
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	group, exists := a.pending[id]
+	if !exists {
+		group = &pendingGroup{lanes: make([]net.Conn, lanes)}
+		group.timer = time.AfterFunc(HandshakeTimeout, func() { a.expire(id, group) })
+		a.pending[id] = group
+	}
+	if len(group.lanes) != lanes || group.lanes[index] != nil {
+		conn.Close()
+		return nil, ErrHandshake
+	}
+	group.lanes[index] = conn
+	group.arrived++
+	if group.arrived < lanes {
+		return nil, nil
+	}
+
+	group.timer.Stop()
+	delete(a.pending, id)
+	return newConn(group.lanes, a.opts), nil
+}
+
+// expire 丢弃超时仍未到齐的一组通道
+func (a *Acceptor) expire(id [16]byte, group *pendingGroup) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pending[id] != group {
+		return
+	}
+	delete(a.pending, id)
+	log.Printf("Stripe: only %d of %d lanes arrived, closing them", group.arrived, len(group.lanes))
+	for _, lane := range group.lanes {
+		if lane != nil {
+			lane.Close()
+		}
+	}
+}
@@ -0,0 +1,394 @@
+// Package stripe 把到同一个对端的多条 TCP 连接（通道）组成一条逻辑连接。
+// 写入的数据切分为带序号的数据块，由各条通道并行发送，接收端按序号重新排列后读出，
+// 因此一条逻辑连接的吞吐量不再受单条 TCP 连接拥塞窗口的限制。
+//
+// 每条通道建立后先发送握手：魔数 "OVLB"、16 字节的组 ID、通道序号和通道数；
+// 之后的每个数据块为 8 字节序号、4 字节长度和数据，整数均为大端序。
+// 魔数的第一个字节不是合法的 SMUX 协议版本，接收端可以据此区分通道和普通的 SMUX 连接
+package stripe
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 条带化连接的默认参数和限制
+const (
+	DefaultChunkSize     = 64 << 10 // 单个数据块的默认大小
+	DefaultReorderBuffer = 8 << 20  // 接收端等待重排的数据默认上限
+	MaxLanes             = 16       // 一条逻辑连接最多的通道数
+	maxChunkSize         = 1 << 20  // 接收端允许的最大数据块，防止异常的长度字段
+	chunkHeaderLen       = 12
+	flushTimeout         = 5 * time.Second // 关闭时等待已写入的数据发送完的最长时间
+)
+
+// ErrClosed 条带化连接已经关闭
+var ErrClosed = errors.New("striped connection is closed")
+
+// Options 条带化连接的参数，零值使用默认值
+type Options struct {
+	ChunkSize     int // 发送的数据块大小上限
+	ReorderBuffer int // 接收端已经收到、但前面还有数据块未到达的数据上限，达到上限时暂停读取其他通道
+}
+
+func (o Options) withDefaults() Options {
+	if o.ChunkSize <= 0 {
+		o.ChunkSize = DefaultChunkSize
+	}
+	o.ChunkSize = min(o.ChunkSize, maxChunkSize)
+	if o.ReorderBuffer <= 0 {
+		o.ReorderBuffer = DefaultReorderBuffer
+	}
+	return o
+}
+
+// chunk 一个带序号的数据块
+type chunk struct {
+	seq  uint64
+	data []byte
+}
+
+// Conn 由多条通道组成的逻辑连接，实现 net.Conn。任意一条通道出错时整条连接失败；
+// 对端正常关闭时，所有通道上的数据都读完后 Read 返回 io.EOF
+type Conn struct {
+	lanes []net.Conn
+	opts  Options
+
+	// 发送：Write 按顺序编号后放入队列，每条通道的发送协程从队列中取数据块，空闲的通道取得更多
+	writeMu   sync.Mutex
+	nextSeq   uint64
+	queue     chan chunk
+	closing   chan struct{} // Close 时关闭，唤醒等待队列的 Write
+	closeOnce sync.Once
+	writers   sync.WaitGroup
+
+	// 接收：每条通道的接收协程把数据块放入 pending，Read 按序号取出
+	mu           sync.Mutex
+	pending      map[uint64][]byte
+	pendingBytes int
+	expect       uint64 // 下一个要读出的序号
+	cur          []byte // 当前数据块中尚未读出的部分
+	finished     int    // 已经正常结束的通道数
+	err          error
+	readDeadline time.Time
+	changed      chan struct{} // 接收状态变化时关闭并替换
+
+	failOnce sync.Once
+	done     chan struct{}
+}
+
+// newConn 在已经完成握手的通道上创建逻辑连接，lanes 按通道序号排列
+func newConn(lanes []net.Conn, opts Options) *Conn {
+	opts = opts.withDefaults()
+	c := &Conn{
+		lanes:   lanes,
+		opts:    opts,
+		queue:   make(chan chunk, 2*len(lanes)),
+		closing: make(chan struct{}),
+		pending: make(map[uint64][]byte),
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for _, lane := range lanes {
+		c.writers.Add(1)
+		go c.send(lane)
+		go c.receive(lane)
+	}
+	return c
+}
+
+// Lanes 返回通道数
+func (c *Conn) Lanes() int {
+	return len(c.lanes)
+}
+
+// Write 把数据切分为数据块放入发送队列，队列满时等待。返回时数据已经复制，但不一定已经发出
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	select {
+	case <-c.closing:
+		return 0, ErrClosed
+	default:
+	}
+
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), c.opts.ChunkSize)
+		data := make([]byte, n)
+		copy(data, b[:n])
+		select {
+		case c.queue <- chunk{seq: c.nextSeq, data: data}:
+		case <-c.done:
+			return written, c.failure()
+		case <-c.closing:
+			return written, ErrClosed
+		}
+		c.nextSeq++
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// send 一条通道的发送协程
+func (c *Conn) send(lane net.Conn) {
+	defer c.writers.Done()
+	buf := make([]byte, chunkHeaderLen+c.opts.ChunkSize)
+	for {
+		select {
+		case ch, ok := <-c.queue:
+			if !ok {
+				return
+			}
+			binary.BigEndian.PutUint64(buf[0:8], ch.seq)
+			binary.BigEndian.PutUint32(buf[8:12], uint32(len(ch.data)))
+			n := copy(buf[chunkHeaderLen:], ch.data)
+			if _, err := lane.Write(buf[:chunkHeaderLen+n]); err != nil {
+				c.fail(err)
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// receive 一条通道的接收协程
+func (c *Conn) receive(lane net.Conn) {
+	var header [chunkHeaderLen]byte
+	for {
+		if _, err := io.ReadFull(lane, header[:]); err != nil {
+			if err == io.EOF {
+				c.finish()
+			} else {
+				c.fail(err)
+			}
+			return
+		}
+		seq := binary.BigEndian.Uint64(header[0:8])
+		n := binary.BigEndian.Uint32(header[8:12])
+		if n > maxChunkSize {
+			c.fail(errors.New("striped chunk too large"))
+			return
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(lane, data); err != nil {
+			c.fail(err)
+			return
+		}
+		if !c.deliver(seq, data) {
+			return
+		}
+	}
+}
+
+// deliver 把数据块放入重排缓冲区。缓冲区已满时等待，但下一个要读出的数据块总是直接放入：
+// 同一条通道上的序号是递增的，所以携带它的通道一定不会因为缓冲区已满而阻塞在更大的序号上
+func (c *Conn) deliver(seq uint64, data []byte) bool {
+	for {
+		c.mu.Lock()
+		if c.err != nil {
+			c.mu.Unlock()
+			return false
+		}
+		if seq == c.expect || c.pendingBytes+len(data) <= c.opts.ReorderBuffer {
+			c.pending[seq] = data
+			c.pendingBytes += len(data)
+			c.notify()
+			c.mu.Unlock()
+			return true
+		}
+		changed := c.changed
+		c.mu.Unlock()
+		select {
+		case <-changed:
+		case <-c.done:
+			return false
+		}
+	}
+}
+
+// Read 按序号读出数据，下一个数据块还没有到达时等待
+func (c *Conn) Read(b []byte) (int, error) {
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		c.mu.Lock()
+		if c.err == ErrClosed {
+			c.mu.Unlock()
+			return 0, ErrClosed
+		}
+		if len(c.cur) > 0 {
+			n := copy(b, c.cur)
+			c.cur = c.cur[n:]
+			c.mu.Unlock()
+			return n, nil
+		}
+		if data, exists := c.pending[c.expect]; exists {
+			delete(c.pending, c.expect)
+			c.expect++
+			c.pendingBytes -= len(data)
+			c.cur = data
+			c.notify()
+			c.mu.Unlock()
+			continue
+		}
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return 0, err
+		}
+		deadline := c.readDeadline
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			c.mu.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		var expired <-chan time.Time
+		if !deadline.IsZero() {
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.NewTimer(time.Until(deadline))
+			expired = timer.C
+		}
+		select {
+		case <-changed:
+		case <-expired:
+		}
+	}
+}
+
+// notify 唤醒等待接收状态的协程，调用方持有锁
+func (c *Conn) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// finish 一条通道被对端正常关闭，所有通道都关闭后连接以 io.EOF 结束
+func (c *Conn) finish() {
+	c.mu.Lock()
+	c.finished++
+	all := c.finished == len(c.lanes)
+	c.mu.Unlock()
+	if all {
+		c.fail(io.EOF)
+	}
+}
+
+// fail 以 err 结束连接并关闭所有通道，已经按序到达的数据仍然可以读出
+func (c *Conn) fail(err error) {
+	c.failOnce.Do(func() {
+		c.mu.Lock()
+		if c.err == nil {
+			c.err = err
+		}
+		c.notify()
+		c.mu.Unlock()
+		close(c.done)
+		for _, lane := range c.lanes {
+			lane.Close()
+		}
+	})
+}
+
+// failure 返回连接失败的原因
+func (c *Conn) failure() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == io.EOF {
+		return ErrClosed
+	}
+	return c.err
+}
+
+// Close 关闭连接，之后的读写立即返回 ErrClosed。与关闭 TCP 连接一样不等待数据发出：
+// 已经写入的数据在后台继续发送（最长 flushTimeout），发送完后关闭所有通道
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		if c.err == nil {
+			c.err = ErrClosed
+		}
+		c.notify()
+		c.mu.Unlock()
+
+		// 先让等待队列的 Write 返回，再关闭队列，发送协程发完队列中的数据后退出
+		close(c.closing)
+		c.writeMu.Lock()
+		close(c.queue)
+		c.writeMu.Unlock()
+
+		go func() {
+			flushed := make(chan struct{})
+			go func() {
+				c.writers.Wait()
+				close(flushed)
+			}()
+			select {
+			case <-flushed:
+			case <-c.done:
+			case <-time.After(flushTimeout):
+			}
+			c.fail(ErrClosed)
+		}()
+	})
+	return nil
+}
+
+// LocalAddr 返回第一条通道的本地地址
+func (c *Conn) LocalAddr() net.Addr {
+	return c.lanes[0].LocalAddr()
+}
+
+// RemoteAddr 返回第一条通道的对端地址
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.lanes[0].RemoteAddr()
+}
+
+// SetDeadline 设置读写超时
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline 设置 Read 等待数据的超时
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	c.notify()
+	return nil
+}
+
+// SetWriteDeadline 设置每条通道的写超时，超时的通道使整条连接失败
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	for _, lane := range c.lanes {
+		if err := lane.SetWriteDeadline(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyscallConn 返回第一条通道的底层连接，用于读取 TCP_INFO 等内核统计
+func (c *Conn) SyscallConn() (syscall.RawConn, error) {
+	if sc, ok := c.lanes[0].(syscall.Conn); ok {
+		return sc.SyscallConn()
+	}
+	return nil, errors.New("lane does not expose a syscall connection")
+}
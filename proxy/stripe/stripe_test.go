@@ -0,0 +1,73 @@
+package stripe
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"demo1/proxy/faultnet"
+)
+
+func TestStripedTransferKeepsOrder(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// 接收端用很小的重排缓冲区，验证缓冲区满时不会死锁
+	accepted := make(chan *Conn, 1)
+	acceptor := NewAcceptor(Options{ReorderBuffer: 8 << 10})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn, isLane, err := Sniff(conn)
+				if err != nil || !isLane {
+					conn.Close()
+					return
+				}
+				if bonded, _ := acceptor.Add(conn); bonded != nil {
+					accepted <- bonded
+				}
+			}()
+		}
+	}()
+
+	// 各条通道的时延随机波动，数据块到达接收端的顺序被打乱
+	link := faultnet.NewLink(faultnet.Faults{Latency: time.Millisecond, Jitter: time.Millisecond})
+	var dialer net.Dialer
+	dial := link.Dial(dialer.DialContext)
+	client, err := Dial(context.Background(), 4, func(ctx context.Context) (net.Conn, error) {
+		return dial(ctx, "tcp", listener.Addr().String())
+	}, Options{ChunkSize: 1 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	if client.Lanes() != 4 || server.Lanes() != 4 {
+		t.Fatalf("expected 4 lanes, got %d and %d", client.Lanes(), server.Lanes())
+	}
+
+	data := make([]byte, 512<<10)
+	rand.Read(data)
+	go func() {
+		client.Write(data)
+		client.Close()
+	}()
+	got, err := io.ReadAll(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("reassembled %d bytes differ from the %d bytes sent", len(got), len(data))
+	}
+	server.Close()
+}
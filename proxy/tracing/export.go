@@ -0,0 +1,210 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// scopeName 写入 OTLP instrumentation scope 的名称
+const scopeName = "demo1/proxy"
+
+// OTLP/HTTP JSON 编码使用的结构，字段名与 opentelemetry-proto 的 JSON 映射一致
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              SpanKind       `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"` // 0 未设置，2 错误
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// encodeOTLP 把一批 span 编码为 OTLP ExportTraceServiceRequest
+func encodeOTLP(service string, spans []*Span) otlpRequest {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		span.mu.Lock()
+		s := otlpSpan{
+			TraceID:           span.Context.TraceID.String(),
+			SpanID:            span.Context.SpanID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		}
+		if !span.Parent.IsZero() {
+			s.ParentSpanID = span.Parent.String()
+		}
+		for _, attr := range span.Attributes {
+			s.Attributes = append(s.Attributes, otlpAttribute(attr.Key, attr.Value))
+		}
+		if span.Err != "" {
+			s.Status = otlpStatus{Code: 2, Message: span.Err}
+		}
+		span.mu.Unlock()
+		encoded = append(encoded, s)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpKeyValue{otlpAttribute("service.name", service)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: scopeName}, Spans: encoded}},
+	}}}
+}
+
+// otlpAttribute 按值的类型编码属性，其他类型按字符串处理
+func otlpAttribute(key string, value interface{}) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	switch v := value.(type) {
+	case string:
+		kv.Value.StringValue = &v
+	case bool:
+		kv.Value.BoolValue = &v
+	case int:
+		s := strconv.FormatInt(int64(v), 10)
+		kv.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		kv.Value.IntValue = &s
+	case uint32:
+		s := strconv.FormatUint(uint64(v), 10)
+		kv.Value.IntValue = &s
+	case uint8:
+		s := strconv.FormatUint(uint64(v), 10)
+		kv.Value.IntValue = &s
+	case float64:
+		kv.Value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		kv.Value.StringValue = &s
+	}
+	return kv
+}
+
+// OTLPExporter 通过 OTLP/HTTP (JSON) 把 span 发送到收集器
+type OTLPExporter struct {
+	Endpoint string            // 收集器地址，例如 http://collector:4318/v1/traces
+	Headers  map[string]string // 附加的请求头部，例如认证信息
+	Service  string
+	Client   *http.Client
+}
+
+// NewOTLPExporter 创建 OTLP/HTTP 导出器
+func NewOTLPExporter(endpoint, service string, headers map[string]string) *OTLPExporter {
+	return &OTLPExporter{
+		Endpoint: endpoint,
+		Headers:  headers,
+		Service:  service,
+		Client:   &http.Client{Timeout: exportTimeout},
+	}
+}
+
+// Export 发送一批 span，收集器返回非 2xx 时返回错误
+func (e *OTLPExporter) Export(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(encodeOTLP(e.Service, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector %s returned %s", e.Endpoint, resp.Status)
+	}
+	return nil
+}
+
+// Close 关闭导出器
+func (e *OTLPExporter) Close() error {
+	e.Client.CloseIdleConnections()
+	return nil
+}
+
+// FileExporter 把 span 写入本地文件，每批一行 OTLP JSON，便于离线分析或之后导入收集器
+type FileExporter struct {
+	Service string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileExporter 以追加方式打开导出文件
+func NewFileExporter(path, service string) (*FileExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+	return &FileExporter{Service: service, file: file}, nil
+}
+
+// Export 写入一批 span
+func (e *FileExporter) Export(_ context.Context, spans []*Span) error {
+	line, err := json.Marshal(encodeOTLP(e.Service, spans))
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close 关闭导出文件
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
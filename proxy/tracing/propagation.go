@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader W3C Trace Context 头部
+const TraceparentHeader = "traceparent"
+
+// FlagSampled traceparent 中的采样标志
+const FlagSampled byte = 0x01
+
+// TraceID 16 字节的追踪 ID
+type TraceID [16]byte
+
+// SpanID 8 字节的 span ID
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// IsZero 判断是否为零值
+func (id SpanID) IsZero() bool { return id == SpanID{} }
+
+// SpanContext 跨节点传递的追踪上下文
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+}
+
+// Sampled 判断该追踪是否被采样
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// Traceparent 按 W3C 格式编码，例如 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent 解析 traceparent 头部，格式错误或 ID 全为零时返回错误
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, fmt.Errorf("invalid traceparent %q", value)
+	}
+	// 版本 00 只有四个字段，更高的版本允许在后面追加字段
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, fmt.Errorf("invalid traceparent %q", value)
+	}
+	if err := decodeHex(sc.TraceID[:], parts[1]); err != nil || sc.TraceID == (TraceID{}) {
+		return sc, fmt.Errorf("invalid trace id in traceparent %q", value)
+	}
+	if err := decodeHex(sc.SpanID[:], parts[2]); err != nil || sc.SpanID.IsZero() {
+		return sc, fmt.Errorf("invalid span id in traceparent %q", value)
+	}
+	var flags [1]byte
+	if err := decodeHex(flags[:], parts[3]); err != nil {
+		return sc, fmt.Errorf("invalid flags in traceparent %q", value)
+	}
+	sc.Flags = flags[0]
+	return sc, nil
+}
+
+// decodeHex 解码定长的小写十六进制字符串
+func decodeHex(dst []byte, s string) error {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return fmt.Errorf("invalid hex %q", s)
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+type remoteKey struct{}
+
+// Extract 从请求头部读取上游的追踪上下文，返回携带它的 ctx；头部不存在或无效时原样返回
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Inject 把 ctx 中当前 span 的上下文写入请求头部，传给下一跳；未启用追踪时不修改头部
+func Inject(ctx context.Context, header http.Header) {
+	if span := SpanFromContext(ctx); span != nil {
+		header.Set(TraceparentHeader, span.Context.Traceparent())
+	}
+}
+
+// SpanContextFromContext 返回 ctx 中当前 span 的上下文，没有时返回上游传来的远端上下文
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.Context, true
+	}
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok
+}
@@ -0,0 +1,224 @@
+// Package tracing 实现跨节点的分布式追踪：用 W3C traceparent 头部在入口、中继和出口之间传递追踪上下文，
+// 在各个处理阶段创建 span，并批量导出到 OTLP/HTTP 收集器或本地文件
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"log"
+	"sync"
+	"time"
+)
+
+// SpanKind span 的类型，取值与 OTLP 一致
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// 批量导出的参数
+const (
+	queueSize     = 4096
+	batchSize     = 256
+	flushInterval = 2 * time.Second
+	exportTimeout = 10 * time.Second
+)
+
+// Attribute span 的属性，Value 支持 string、bool、整数和浮点数
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Span 一个处理阶段。为空的 *Span 表示未启用追踪，所有方法都可以安全调用
+type Span struct {
+	tracer *Tracer
+
+	Name       string
+	Kind       SpanKind
+	Context    SpanContext
+	Parent     SpanID // 父 span，根 span 为零值
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	Err        string // 不为空表示该阶段失败
+
+	mu    sync.Mutex
+	ended bool
+}
+
+// SetAttribute 设置属性
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Attributes = append(s.Attributes, Attribute{Key: key, Value: value})
+	s.mu.Unlock()
+}
+
+// SetError 标记该阶段失败
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Err = err.Error()
+	s.mu.Unlock()
+}
+
+// Finish 结束 span，err 不为空时同时标记失败；重复调用只有第一次生效
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.SetError(err)
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	if s.Context.Sampled() {
+		s.tracer.enqueue(s)
+	}
+}
+
+// Exporter 把结束的 span 发送到追踪后端
+type Exporter interface {
+	Export(ctx context.Context, spans []*Span) error
+	Close() error
+}
+
+// Tracer 创建 span 并在后台批量导出，为空时不做追踪
+type Tracer struct {
+	SampleRatio float64    // 根 span 的采样比例，下游节点沿用上游的采样决定
+	Exporters   []Exporter // 为空时只传播追踪上下文，不导出 span
+
+	queue chan *Span
+}
+
+// NewTracer 创建追踪器，需要调用 Run 才会导出 span
+func NewTracer(sampleRatio float64, exporters ...Exporter) *Tracer {
+	return &Tracer{
+		SampleRatio: sampleRatio,
+		Exporters:   exporters,
+		queue:       make(chan *Span, queueSize),
+	}
+}
+
+type spanKey struct{}
+
+// Start 创建 span：ctx 中有 span 时作为它的子 span，否则沿用 ctx 中的远端上下文，
+// 都没有时开始一个新的追踪。返回的 ctx 携带新的 span
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, Name: name, Kind: kind, Start: time.Now()}
+	parent, ok := SpanContextFromContext(ctx)
+	if ok {
+		span.Context = SpanContext{TraceID: parent.TraceID, Flags: parent.Flags}
+		span.Parent = parent.SpanID
+	} else {
+		rand.Read(span.Context.TraceID[:])
+		if t.sample(span.Context.TraceID) {
+			span.Context.Flags = FlagSampled
+		}
+	}
+	rand.Read(span.Context.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// StartChild 在 ctx 中的 span 下创建子 span，使用父 span 的追踪器；ctx 中没有 span 时不做追踪。
+// 用于没有直接持有追踪器的辅助函数，例如连接池和 SMUX 流的获取
+func StartChild(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, kind)
+}
+
+// SpanFromContext 返回 ctx 中的 span，没有时返回 nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// sample 按追踪 ID 决定是否采样，同一个追踪在各节点上的决定一致
+func (t *Tracer) sample(traceID TraceID) bool {
+	if t.SampleRatio >= 1 {
+		return true
+	}
+	if t.SampleRatio <= 0 {
+		return false
+	}
+	bound := uint64(t.SampleRatio * (1 << 63))
+	return binary.BigEndian.Uint64(traceID[8:])>>1 < bound
+}
+
+// enqueue 把结束的 span 放入导出队列，队列满时丢弃
+func (t *Tracer) enqueue(span *Span) {
+	if len(t.Exporters) == 0 {
+		return
+	}
+	select {
+	case t.queue <- span:
+	default:
+	}
+}
+
+// Run 在后台批量导出 span，ctx 结束时导出剩余的 span 并关闭导出器
+func (t *Tracer) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		t.export(batch)
+		batch = make([]*Span, 0, batchSize)
+	}
+
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for len(t.queue) > 0 {
+				batch = append(batch, <-t.queue)
+			}
+			flush()
+			for _, exporter := range t.Exporters {
+				exporter.Close()
+			}
+			return
+		}
+	}
+}
+
+// export 把一批 span 交给所有导出器
+func (t *Tracer) export(spans []*Span) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	for _, exporter := range t.Exporters {
+		if err := exporter.Export(ctx, spans); err != nil {
+			log.Printf("Failed to export %d spans: %v", len(spans), err)
+		}
+	}
+}
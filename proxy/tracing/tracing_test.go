@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// discardExporter 丢弃所有 span，只用于让追踪器把结束的 span 放入导出队列
+type discardExporter struct{}
+
+func (discardExporter) Export(context.Context, []*Span) error { return nil }
+func (discardExporter) Close() error                          { return nil }
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	const value = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Sampled() || sc.Traceparent() != value {
+		t.Fatalf("round trip mismatch: %s", sc.Traceparent())
+	}
+
+	for _, invalid := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, err := ParseTraceparent(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestSpansContinueRemoteTrace(t *testing.T) {
+	tracer := NewTracer(0) // 不采样新的追踪，但要沿用上游的采样决定
+	header := http.Header{}
+	header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	ctx, server := tracer.Start(Extract(context.Background(), header), "relay", KindServer)
+	if server.Context.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent.String() != "00f067aa0ba902b7" {
+		t.Fatalf("server span did not continue the remote trace: %+v", server.Context)
+	}
+	if !server.Context.Sampled() {
+		t.Fatal("sampling decision from upstream was not kept")
+	}
+
+	ctx, child := StartChild(ctx, "relay.forward", KindClient)
+	if child.Parent != server.Context.SpanID || child.Context.TraceID != server.Context.TraceID {
+		t.Fatal("child span is not linked to its parent")
+	}
+	out := http.Header{}
+	Inject(ctx, out)
+	if out.Get(TraceparentHeader) != child.Context.Traceparent() {
+		t.Fatalf("injected %q", out.Get(TraceparentHeader))
+	}
+
+	// 连接池的取用作为子 span 导出
+	_, get := StartChild(ctx, "pool.get", KindInternal)
+	if get.Parent != child.Context.SpanID {
+		t.Fatal("pool.get span is not linked to its parent")
+	}
+	tracer.Exporters = []Exporter{discardExporter{}}
+	get.Finish(nil)
+	select {
+	case span := <-tracer.queue:
+		if span != get {
+			t.Fatalf("expected pool.get to be queued for export, got %s", span.Name)
+		}
+	default:
+		t.Fatal("pool.get span was not queued for export")
+	}
+
+	// 没有 span 的 ctx 不创建子 span
+	if _, span := StartChild(context.Background(), "pool.get", KindInternal); span != nil {
+		t.Fatal("expected no span without a parent")
+	}
+}
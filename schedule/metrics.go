@@ -0,0 +1,44 @@
+package schedule
+
+import "sync"
+
+// Values 一个节点的选路评价取值，由 /metrics 导出
+type Values struct {
+	Delay            float64 // 链路时延
+	NormalCPUMean    float64 // 归一化的 CPU 均值
+	NormalCPUVar     float64 // 归一化的 CPU 方差
+	QueueMean        float64 // CPU 均值虚拟队列长度
+	QueueVar         float64 // CPU 方差虚拟队列长度
+	DriftPlusPenalty float64 // 漂移加惩罚，越小越优先
+}
+
+// 每个节点最近一次记录的评价结果
+var (
+	mu     sync.Mutex
+	latest = make(map[string]Values)
+)
+
+// Record 记录节点当前的评价结果，供指标导出
+func (e *Evaluate) Record(node string) {
+	mu.Lock()
+	defer mu.Unlock()
+	latest[node] = Values{
+		Delay:            e.delay,
+		NormalCPUMean:    e.normalCpuMean,
+		NormalCPUVar:     e.normalCpuVar,
+		QueueMean:        e.qMean,
+		QueueVar:         e.qVar,
+		DriftPlusPenalty: e.driftPlusPenalty(),
+	}
+}
+
+// Snapshot 返回每个节点最近一次记录的评价结果
+func Snapshot() map[string]Values {
+	mu.Lock()
+	defer mu.Unlock()
+	values := make(map[string]Values, len(latest))
+	for node, v := range latest {
+		values[node] = v
+	}
+	return values
+}
@@ -1,13 +1,15 @@
 package tcp
 
 import (
+	"context"
 	"log"
 	"net"
 )
 
 // StartTCPServer 启动一个简单的TCP服务器
 // 这个函数接受一个端口号作为参数，并在该端口上启动TCP服务器
-func StartTCPServer(port string) {
+// ctx 结束时关闭监听器，不再接受新的连接，函数随之返回
+func StartTCPServer(ctx context.Context, port string) {
 	// 使用 net.Listen 在指定的端口上启动TCP监听器
 	// 第一个参数 "tcp" 表示协议，第二个参数是监听的地址和端口
 	listener, err := net.Listen("tcp", ":"+port)
@@ -15,17 +17,31 @@ func StartTCPServer(port string) {
 		// 如果监听失败，记录错误并终止程序执行
 		log.Fatalf("Failed to start TCP server on port %s: %v", port, err)
 	}
+	ServeTCP(ctx, listener)
+}
+
+// ServeTCP 在给定的监听器上接受探测连接，ctx 结束时关闭监听器并返回。
+// 测试中可以传入监听临时端口的监听器
+func ServeTCP(ctx context.Context, listener net.Listener) {
 	// defer 语句确保在函数返回时关闭监听器，释放资源
 	defer listener.Close()
 
+	// ctx 结束时关闭监听器，使阻塞中的 Accept 返回
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+
 	// 记录日志，表示服务器已经在指定端口上开始监听
-	log.Printf("TCP server is listening on port %s", port)
+	log.Printf("TCP server is listening on %s", listener.Addr())
 
-	// 无限循环，持续等待和接受新的连接
+	// 循环等待和接受新的连接，直到 ctx 结束
 	for {
 		// Accept 方法阻塞并等待客户端的连接请求
 		conn, err := listener.Accept()
 		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("TCP server on %s stopped", listener.Addr())
+				return
+			}
 			// 如果接收连接失败，记录错误并继续等待下一个连接
 			log.Printf("Failed to accept connection: %v", err)
 			continue
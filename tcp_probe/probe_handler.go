@@ -2,19 +2,43 @@ package tcp_probe
 
 import (
 	"context"
+	"demo1/metrics"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 var (
+	// ReportURL 探测结果的上报地址，可以通过节点配置修改
+	ReportURL = "http://124.70.34.63:8080/fetch_detect"
+	// DefaultPort 探测任务未指定端口时使用的端口
+	DefaultPort = "50000"
+	// ProbeInterval 探测间隔
+	ProbeInterval = 1 * time.Second
+	// ReportInterval 上报平均延迟的间隔
+	ReportInterval = 10 * time.Second
+	// ProbeTimeout 单次探测的连接超时
+	ProbeTimeout = 5 * time.Second
+	// Dial 探测使用的拨号函数，测试中可以替换为注入故障的链路
+	Dial = (&net.Dialer{}).DialContext
+
 	// 全局变量，用于控制探测任务的取消
 	currentTaskCancel context.CancelFunc
-	// 结果文件路径
+	// 保护 currentTaskCancel
+	taskMu sync.Mutex
+	// 正在执行的探测任务，服务关闭时等待它们上报完剩余的结果
+	taskWG sync.WaitGroup
+
+	// 最近一次探测的时延和失败次数，由 /metrics 导出
+	probeRTT = metrics.NewGauge("overlay_probe_rtt_seconds",
+		"TCP connect time of the latest probe from source to target.", "source", "target")
+	probeFailures = metrics.NewCounter("overlay_probe_failures_total",
+		"Failed TCP probes from source to target.", "source", "target")
 )
 
 func ProbeHandler(c *gin.Context) {
@@ -26,32 +50,39 @@ func ProbeHandler(c *gin.Context) {
 		return
 	}
 
-	// 如果未指定端口，使用默认端口 "50000"
+	// 如果未指定端口，使用默认端口（默认 "50000"）
 	if task.Port == "" {
-		task.Port = "50000"
+		task.Port = DefaultPort
 	}
 
 	// 取消当前正在执行的探测任务（如果存在）
+	taskMu.Lock()
 	if currentTaskCancel != nil {
 		currentTaskCancel()
 	}
+	taskMu.Unlock()
 
 	// 清空探测结果文件
-	err := clearProbeResultsFile(probeDataFilePath)
+	err := clearProbeResultsFile(ResultsFile)
 	if err != nil {
 		log.Printf("Failed to clear probe results file: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear previous results"})
 		return
 	}
 
-	// 创建一个新的 context 和 cancel function
-	ctx, cancel := context.WithCancel(context.Background())
+	// 创建一个新的 context 和 cancel function，接收该任务的 API 服务关闭时任务同样被取消
+	serverCtx := serverContext(c.Request.Context())
+	taskMu.Lock()
+	ctx, cancel := context.WithCancel(serverCtx)
 	currentTaskCancel = cancel
+	taskMu.Unlock()
 
 	// 启动一个新的 Goroutine 来处理探测任务
+	taskWG.Add(1)
 	go func(task ProbeTask, ctx context.Context) {
-		ticker := time.NewTicker(1 * time.Second)
-		reportTicker := time.NewTicker(10 * time.Second)
+		defer taskWG.Done()
+		ticker := time.NewTicker(ProbeInterval)
+		reportTicker := time.NewTicker(ReportInterval)
 		defer ticker.Stop()
 		defer reportTicker.Stop()
 
@@ -60,6 +91,10 @@ func ProbeHandler(c *gin.Context) {
 		for {
 			select {
 			case <-ctx.Done():
+				// 服务关闭时上报尚未上报的结果；被新任务替换时直接丢弃
+				if serverCtx.Err() != nil {
+					reportAverage(results)
+				}
 				log.Println("Probe task cancelled")
 				return
 
@@ -67,8 +102,10 @@ func ProbeHandler(c *gin.Context) {
 				tcpDelay, err := performTCPProbe(task.IP2, task.Port)
 				if err != nil {
 					log.Printf("TCP probe failed: %v", err)
+					probeFailures.With(task.IP1, task.IP2).Inc()
 					continue
 				}
+				probeRTT.With(task.IP1, task.IP2).Set(tcpDelay.Seconds())
 
 				result := ProbeResult{
 					IP1:       task.IP1,
@@ -79,36 +116,9 @@ func ProbeHandler(c *gin.Context) {
 				results = append(results, result)
 
 			case <-reportTicker.C:
-				if len(results) > 0 {
-					// 计算所有探测结果的延迟平均值
-					var totalDelay time.Duration
-					for _, result := range results {
-						totalDelay += result.TCPDelay
-					}
-					avgDelay := totalDelay / time.Duration(len(results))
-
-					// 使用第一个结果的 IP1 和 IP2，创建一个新的探测结果用于上报
-					avgResult := ProbeResult{
-						IP1:       results[0].IP1,
-						IP2:       results[0].IP2,
-						TCPDelay:  avgDelay, // 已经是毫秒单位
-						Timestamp: time.Now(),
-					}
-
-					// 将包含平均延迟的探测结果作为对象上报
-					err := reportProbeResults("http://124.70.34.63:8080/fetch_detect", avgResult)
-					if err != nil {
-						log.Printf("Failed to report probe results: %v", err)
-					} else {
-						// 如果上报成功，清空探测结果文件
-						err := clearProbeResultsFile(probeDataFilePath)
-						if err != nil {
-							log.Printf("Failed to clear probe results file after reporting: %v", err)
-						}
-					}
-					// 清空内存中的结果切片
-					results = []ProbeResult{}
-				}
+				reportAverage(results)
+				// 清空内存中的结果切片
+				results = []ProbeResult{}
 			}
 		}
 	}(task, ctx)
@@ -116,10 +126,54 @@ func ProbeHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "probe started"})
 }
 
+type serverCtxKey struct{}
+
+// serverContext 返回处理请求的 API 服务的生命周期，不是由 ServeAPI 提供服务时返回 context.Background()。
+// 同一进程中可以运行多个 API 服务，探测任务只随接收它的服务结束
+func serverContext(requestCtx context.Context) context.Context {
+	if ctx, ok := requestCtx.Value(serverCtxKey{}).(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+// reportAverage 计算探测结果的平均延迟并上报，上报成功后清空探测结果文件
+func reportAverage(results []ProbeResult) {
+	if len(results) == 0 {
+		return
+	}
+
+	// 计算所有探测结果的延迟平均值
+	var totalDelay time.Duration
+	for _, result := range results {
+		totalDelay += result.TCPDelay
+	}
+	avgDelay := totalDelay / time.Duration(len(results))
+
+	// 使用第一个结果的 IP1 和 IP2，创建一个新的探测结果用于上报
+	avgResult := ProbeResult{
+		IP1:       results[0].IP1,
+		IP2:       results[0].IP2,
+		TCPDelay:  avgDelay, // 已经是毫秒单位
+		Timestamp: time.Now(),
+	}
+
+	// 将包含平均延迟的探测结果作为对象上报
+	err := reportProbeResults(ReportURL, avgResult)
+	if err != nil {
+		log.Printf("Failed to report probe results: %v", err)
+		return
+	}
+	// 如果上报成功，清空探测结果文件
+	if err := clearProbeResultsFile(ResultsFile); err != nil {
+		log.Printf("Failed to clear probe results file after reporting: %v", err)
+	}
+}
+
 // clearProbeResultsFile 清空探测结果文件内容
 func clearProbeResultsFile(filePath string) error {
-	// 打开文件并清空内容
-	file, err := os.OpenFile(filePath, os.O_TRUNC|os.O_WRONLY, 0644)
+	// 打开文件并清空内容，文件不存在时创建一个空文件
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
@@ -132,10 +186,12 @@ func clearProbeResultsFile(filePath string) error {
 func performTCPProbe(ip, port string) (time.Duration, error) {
 	// 记录开始时间
 	start := time.Now()
-	// 设置连接超时时间为 5 秒
-	timeout := 5 * time.Second
+	// 连接超时时间（默认 5 秒）
+	timeout := ProbeTimeout
 	// 尝试在指定的 IP 和端口上建立 TCP 连接
-	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, port), timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn, err := Dial(ctx, "tcp", net.JoinHostPort(ip, port))
 	if err != nil {
 		// 如果连接失败，返回错误信息
 		return 0, err
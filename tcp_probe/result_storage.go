@@ -8,8 +8,9 @@ import (
 )
 
 var (
-	probeDataFilePath = "probe_results.json" // 定义探测结果保存的文件路径
-	probeMutex        sync.Mutex             // 定义互斥锁，用于保护文件写入的并发安全
+	// ResultsFile 探测结果保存的文件路径，测试中可以改为临时目录
+	ResultsFile = "probe_results.json"
+	probeMutex  sync.Mutex // 定义互斥锁，用于保护文件写入的并发安全
 )
 
 // saveProbeResult 保存探测结果到本地文件
@@ -23,7 +24,7 @@ func saveProbeResult(results ...ProbeResult) {
 	// os.O_WRONLY：以只写模式打开文件
 	// os.O_APPEND：以追加模式打开文件
 	// 0644：文件权限，表示文件所有者可读写，组用户和其他用户只读
-	file, err := os.OpenFile(probeDataFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	file, err := os.OpenFile(ResultsFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		// 如果打开文件失败，输出错误信息并返回
 		fmt.Printf("Failed to open probe data file: %v\n", err)
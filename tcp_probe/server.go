@@ -1,10 +1,34 @@
 package tcp_probe
 
-import "github.com/gin-gonic/gin" // 导入 Gin 框架，用于创建和管理 HTTP 服务器
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin" // 导入 Gin 框架，用于创建和管理 HTTP 服务器
+)
+
+// shutdownTimeout 关闭 API 服务时等待进行中的请求结束的最长时间
+const shutdownTimeout = 10 * time.Second
 
 // StartAPIServer 启动API服务器
-// 这个函数使用 Gin 框架创建一个简单的 HTTP 服务器，并在指定的端口上运行
-func StartAPIServer() {
+// 这个函数使用 Gin 框架创建一个简单的 HTTP 服务器，并在指定的地址（例如 ":8080"）上运行
+// registrars 用于在同一个服务器上挂载其他模块的接口，例如代理节点的管理接口
+// ctx 结束时停止服务，取消正在执行的探测任务并等待它们上报剩余的结果
+func StartAPIServer(ctx context.Context, addr string, registrars ...func(router gin.IRouter)) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("API server stopped: %v", err)
+		return
+	}
+	ServeAPI(ctx, listener, registrars...)
+}
+
+// ServeAPI 在给定的监听器上提供探测任务接口和 registrars 挂载的接口，ctx 结束时停止服务
+func ServeAPI(ctx context.Context, listener net.Listener, registrars ...func(router gin.IRouter)) {
 	// 创建一个默认的 Gin 路由器
 	// gin.Default() 返回一个默认的路由器实例，包含了 Logger 和 Recovery 中间件
 	router := gin.Default()
@@ -13,7 +37,37 @@ func StartAPIServer() {
 	// 当客户端向 "/probe" 端点发送 POST 请求时，ProbeHandler 函数将处理该请求
 	router.POST("/probe", ProbeHandler)
 
-	// 启动服务器，监听指定的端口（8080）
-	// router.Run(":8080") 会在本地的 8080 端口上启动 HTTP 服务器，并开始监听请求
-	router.Run(":8080")
+	// 挂载其他模块的接口
+	for _, register := range registrars {
+		register(router)
+	}
+
+	// 启动服务器，探测任务跟随服务的生命周期
+	server := &http.Server{
+		Handler: router,
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithValue(context.Background(), serverCtxKey{}, ctx)
+		},
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("API server stopped: %v", err)
+		}
+		return
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		server.Close()
+	}
+	taskWG.Wait()
+	log.Println("API server stopped")
 }
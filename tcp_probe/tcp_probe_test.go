@@ -1,7 +1,86 @@
 package tcp_probe
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
 
 func TestProbe(t *testing.T) {
-	StartAPIServer()
+	gin.SetMode(gin.ReleaseMode)
+
+	// 探测目标和接收上报的控制器都监听临时端口
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	reports := make(chan ProbeResult, 16)
+	controller := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result ProbeResult
+		if err := json.NewDecoder(r.Body).Decode(&result); err == nil {
+			reports <- result
+		}
+	}))
+	defer controller.Close()
+
+	ReportURL = controller.URL
+	ResultsFile = filepath.Join(t.TempDir(), "probe_results.json")
+	ProbeInterval, ReportInterval = 10*time.Millisecond, 50*time.Millisecond
+
+	api, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		ServeAPI(ctx, api)
+		close(stopped)
+	}()
+
+	_, port, _ := net.SplitHostPort(target.Addr().String())
+	task, _ := json.Marshal(ProbeTask{IP1: "10.0.0.1", IP2: "127.0.0.1", Port: port})
+	resp, err := http.Post("http://"+api.Addr().String()+"/probe", "application/json", bytes.NewReader(task))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("probe task rejected with %d", resp.StatusCode)
+	}
+
+	select {
+	case result := <-reports:
+		if result.IP1 != "10.0.0.1" || result.IP2 != "127.0.0.1" || result.TCPDelay < 0 || result.Timestamp.IsZero() {
+			t.Errorf("unexpected probe report %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no probe report received")
+	}
+
+	// 服务关闭时探测任务随之结束
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("API server did not stop")
+	}
 }